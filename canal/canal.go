@@ -1,9 +1,14 @@
 package canal
 
 import (
+	"context"
 	"fmt"
+	"sync"
+
+	"github.com/pingcap/parser"
 	"github.com/siddontang/go-mysql/canal"
 	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
 	"github.com/sirupsen/logrus"
 )
 
@@ -19,7 +24,16 @@ type Canal struct {
 
 	UseBoltStoragePosition bool `env:""`
 
-	canal *canal.Canal
+	canal   *canal.Canal
+	cfg     *canal.Config
+	parser  *parser.Parser
+	handler EventHandler
+
+	mu     sync.Mutex
+	syncer *replication.BinlogSyncer
+	cancel context.CancelFunc
+	// 最近一次同步的位置
+	pos mysql.Position
 }
 
 func (c *Canal) GetCanal() *canal.Canal {
@@ -55,17 +69,22 @@ func (c *Canal) Init() error {
 	cfg.Password = c.Password
 	cfg.Dump.Databases = c.Databases
 	cfg.Dump.Tables = c.Tables
+	// decimal 以 decimal.Decimal 解析，避免精度丢失
+	cfg.UseDecimal = true
 	canal, err := canal.NewCanal(cfg)
 	if err != nil {
 		logrus.Errorf("canal newCanal err:%s", err.Error())
 		return err
 	}
 	c.canal = canal
+	c.cfg = cfg
+	c.parser = parser.New()
 	return nil
 }
 
-func (c *Canal) SetEventHandler(handler canal.EventHandler) {
-	c.canal.SetEventHandler(handler)
+// SetEventHandler handler 不注册到 canal，binlog 由 run 读取
+func (c *Canal) SetEventHandler(handler EventHandler) {
+	c.handler = handler
 }
 
 func (c *Canal) RunFrom(pos *mysql.Position) error {
@@ -75,9 +94,27 @@ func (c *Canal) RunFrom(pos *mysql.Position) error {
 			Pos:  c.BinlogPosition,
 		}
 	}
-	return c.canal.RunFrom(*pos)
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mu.Lock()
+	c.cancel = cancel
+	c.mu.Unlock()
+	defer cancel()
+	return c.run(ctx, *pos)
 }
 
 func (c *Canal) Close() {
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.syncer != nil {
+		c.syncer.Close()
+	}
+	pos := c.pos
+	c.mu.Unlock()
 	c.canal.Close()
+	// 与 canal.Close 一致，关闭时同步一次位置
+	if c.handler != nil && pos.Name != "" {
+		c.handler.OnPosSynced(pos, nil, true)
+	}
 }
@@ -0,0 +1,365 @@
+package canal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/JieWaZi/transfer-mysql/jsondiff"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// go-mysql 未定义 PARTIAL_UPDATE_ROWS_EVENT，解析为 GenericEvent
+const partialUpdateRowsEvent replication.EventType = 39
+
+// binlog_row_value_options 中的 PARTIAL_JSON_UPDATES
+const partialJSONUpdates = 1
+
+var errCorruptedRows = errors.New("corrupted partial update rows event")
+
+// partialDecoder 将 PARTIAL_UPDATE_ROWS_EVENT 改写为 UPDATE_ROWS_EVENTv2 后交给独立的 parser 解析。
+// 修改后镜像中以 diff 记录的 JSON 字段替换为修改前的值，diff 单独返回
+type partialDecoder struct {
+	parser *replication.BinlogParser
+	format *replication.FormatDescriptionEvent
+	tables map[uint64]*replication.TableMapEvent
+}
+
+func newPartialDecoder(cfg *canal.Config) *partialDecoder {
+	parser := replication.NewBinlogParser()
+	parser.SetFlavor(cfg.Flavor)
+	parser.SetUseDecimal(cfg.UseDecimal)
+	parser.SetParseTime(cfg.ParseTime)
+	parser.SetTimestampStringLocation(cfg.TimestampStringLocation)
+	// 改写后的事件没有有效的校验和
+	parser.SetVerifyChecksum(false)
+	return &partialDecoder{
+		parser: parser,
+		tables: make(map[uint64]*replication.TableMapEvent),
+	}
+}
+
+// feed 同步 FORMAT_DESCRIPTION_EVENT 与 TABLE_MAP_EVENT
+func (d *partialDecoder) feed(ev *replication.BinlogEvent) error {
+	switch e := ev.Event.(type) {
+	case *replication.FormatDescriptionEvent:
+		d.format = e
+	case *replication.TableMapEvent:
+		d.tables[e.TableID] = e
+	default:
+		return nil
+	}
+	_, err := d.parser.Parse(ev.RawData)
+	return err
+}
+
+// decode 返回改写后的事件及 JSON diff，diff 按 Rows 下标及字段下标索引
+func (d *partialDecoder) decode(ev *replication.BinlogEvent) (*replication.BinlogEvent, map[int]map[int][]jsondiff.Diff, error) {
+	if d.format == nil {
+		return nil, nil, errors.New("partial update rows event before format description event")
+	}
+	generic, ok := ev.Event.(*replication.GenericEvent)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected partial update rows event %T", ev.Event)
+	}
+	headerLengths := d.format.EventTypeHeaderLengths
+	if int(partialUpdateRowsEvent) > len(headerLengths) ||
+		headerLengths[partialUpdateRowsEvent-1] != headerLengths[replication.UPDATE_ROWS_EVENTv2-1] {
+		return nil, nil, errors.New("unsupported partial update rows event post header")
+	}
+	tableIDSize := 6
+	if headerLengths[partialUpdateRowsEvent-1] == 6 {
+		tableIDSize = 4
+	}
+
+	body, diffs, err := rewritePartialRows(generic.Data, tableIDSize, d.tables)
+	if err != nil {
+		return nil, nil, err
+	}
+	raw := make([]byte, replication.EventHeaderSize, replication.EventHeaderSize+len(body)+replication.BinlogChecksumLength)
+	copy(raw, ev.RawData[:replication.EventHeaderSize])
+	raw = append(raw, body...)
+	if d.format.ChecksumAlgorithm == replication.BINLOG_CHECKSUM_ALG_CRC32 {
+		raw = append(raw, make([]byte, replication.BinlogChecksumLength)...)
+	}
+	raw[4] = byte(replication.UPDATE_ROWS_EVENTv2)
+	binary.LittleEndian.PutUint32(raw[9:], uint32(len(raw)))
+
+	rewritten, err := d.parser.Parse(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rewritten, diffs, nil
+}
+
+// rewritePartialRows 去掉修改后镜像前的 value_options 与 partial 位图，
+// diff 字段改为修改前镜像中的原始值，修改前镜像中没有该字段时置为 NULL
+func rewritePartialRows(data []byte, tableIDSize int, tables map[uint64]*replication.TableMapEvent) ([]byte, map[int]map[int][]jsondiff.Diff, error) {
+	if len(data) < tableIDSize+4 {
+		return nil, nil, errCorruptedRows
+	}
+	pos := tableIDSize
+	tableID := mysql.FixedLengthInt(data[:tableIDSize])
+	table, ok := tables[tableID]
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid table id %d, no corresponding table map event", tableID)
+	}
+	// flags 与 extra data
+	pos += 2
+	extraLength := int(binary.LittleEndian.Uint16(data[pos:]))
+	if extraLength < 2 || pos+extraLength > len(data) {
+		return nil, nil, errCorruptedRows
+	}
+	pos += extraLength
+	columnCount, n, err := lengthEncodedInt(data[pos:])
+	if err != nil {
+		return nil, nil, err
+	}
+	pos += n
+	if columnCount != table.ColumnCount {
+		return nil, nil, fmt.Errorf("column count %d mismatch table map %d", columnCount, table.ColumnCount)
+	}
+	bitmapSize := int(columnCount+7) / 8
+	if pos+bitmapSize*2 > len(data) {
+		return nil, nil, errCorruptedRows
+	}
+	before := data[pos : pos+bitmapSize]
+	after := data[pos+bitmapSize : pos+bitmapSize*2]
+	pos += bitmapSize * 2
+
+	jsonColumns := 0
+	for _, tp := range table.ColumnType {
+		if tp == mysql.MYSQL_TYPE_JSON {
+			jsonColumns++
+		}
+	}
+
+	out := make([]byte, pos, len(data))
+	copy(out, data[:pos])
+	diffs := make(map[int]map[int][]jsondiff.Diff)
+	for row := 0; pos < len(data); row += 2 {
+		beforeValues, n, err := readImage(data[pos:], table, before)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = append(out, data[pos:pos+n]...)
+		pos += n
+
+		options, n, err := lengthEncodedInt(data[pos:])
+		if err != nil {
+			return nil, nil, err
+		}
+		pos += n
+		var partial []byte
+		if options&partialJSONUpdates != 0 {
+			size := (jsonColumns + 7) / 8
+			if pos+size > len(data) {
+				return nil, nil, errCorruptedRows
+			}
+			partial = data[pos : pos+size]
+			pos += size
+		}
+		afterValues, n, err := readImage(data[pos:], table, after)
+		if err != nil {
+			return nil, nil, err
+		}
+		pos += n
+
+		// partial 位图中每个 JSON 字段一位，与是否在修改后镜像中无关
+		jsonIndex := 0
+		for i, tp := range table.ColumnType {
+			if tp != mysql.MYSQL_TYPE_JSON {
+				continue
+			}
+			isPartial := partial != nil && isBitSet(partial, jsonIndex)
+			jsonIndex++
+			if !isPartial || afterValues[i] == nil {
+				continue
+			}
+			if table.ColumnMeta[i] != 4 {
+				return nil, nil, fmt.Errorf("unsupported json length bytes %d", table.ColumnMeta[i])
+			}
+			columnDiffs, err := jsondiff.Decode(afterValues[i])
+			if err != nil {
+				return nil, nil, err
+			}
+			if diffs[row+1] == nil {
+				diffs[row+1] = make(map[int][]jsondiff.Diff)
+			}
+			diffs[row+1][i] = columnDiffs
+			afterValues[i] = beforeValues[i]
+		}
+		out = appendImage(out, after, afterValues)
+	}
+	return out, diffs, nil
+}
+
+// readImage 按字段位图读取一行镜像，返回每个字段的原始字节，不在镜像中或为 NULL 时为 nil
+func readImage(data []byte, table *replication.TableMapEvent, bitmap []byte) ([][]byte, int, error) {
+	present := 0
+	for i := 0; i < int(table.ColumnCount); i++ {
+		if isBitSet(bitmap, i) {
+			present++
+		}
+	}
+	nullBitmap := (present + 7) / 8
+	if len(data) < nullBitmap {
+		return nil, 0, errCorruptedRows
+	}
+	pos := nullBitmap
+	values := make([][]byte, table.ColumnCount)
+	index := 0
+	for i := 0; i < int(table.ColumnCount); i++ {
+		if !isBitSet(bitmap, i) {
+			continue
+		}
+		isNull := isBitSet(data[:nullBitmap], index)
+		index++
+		if isNull {
+			continue
+		}
+		n, err := valueLength(data[pos:], table.ColumnType[i], table.ColumnMeta[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		if n <= 0 || pos+n > len(data) {
+			return nil, 0, errCorruptedRows
+		}
+		values[i] = data[pos : pos+n]
+		pos += n
+	}
+	return values, pos, nil
+}
+
+func appendImage(out []byte, bitmap []byte, values [][]byte) []byte {
+	present := make([]int, 0, len(values))
+	for i := range values {
+		if isBitSet(bitmap, i) {
+			present = append(present, i)
+		}
+	}
+	nullBitmap := make([]byte, (len(present)+7)/8)
+	for index, i := range present {
+		if values[i] == nil {
+			nullBitmap[index>>3] |= 1 << (uint(index) & 7)
+		}
+	}
+	out = append(out, nullBitmap...)
+	for _, i := range present {
+		out = append(out, values[i]...)
+	}
+	return out
+}
+
+// valueLength 与 replication.RowsEvent.decodeValue 一致计算字段在 binlog 中的长度
+func valueLength(data []byte, tp byte, meta uint16) (int, error) {
+	length := 0
+	if tp == mysql.MYSQL_TYPE_STRING {
+		if meta >= 256 {
+			b0 := uint8(meta >> 8)
+			b1 := uint8(meta & 0xFF)
+			if b0&0x30 != 0x30 {
+				length = int(uint16(b1) | (uint16((b0&0x30)^0x30) << 4))
+				tp = b0 | 0x30
+			} else {
+				length = int(meta & 0xFF)
+				tp = b0
+			}
+		} else {
+			length = int(meta)
+		}
+	}
+
+	switch tp {
+	case mysql.MYSQL_TYPE_NULL:
+		return 0, nil
+	case mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_YEAR:
+		return 1, nil
+	case mysql.MYSQL_TYPE_SHORT:
+		return 2, nil
+	case mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_TIME, mysql.MYSQL_TYPE_DATE:
+		return 3, nil
+	case mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_FLOAT, mysql.MYSQL_TYPE_TIMESTAMP:
+		return 4, nil
+	case mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_DOUBLE, mysql.MYSQL_TYPE_DATETIME:
+		return 8, nil
+	case mysql.MYSQL_TYPE_TIMESTAMP2:
+		return int(4 + (meta+1)/2), nil
+	case mysql.MYSQL_TYPE_DATETIME2:
+		return int(5 + (meta+1)/2), nil
+	case mysql.MYSQL_TYPE_TIME2:
+		return int(3 + (meta+1)/2), nil
+	case mysql.MYSQL_TYPE_NEWDECIMAL:
+		return decimalLength(int(meta>>8), int(meta&0xFF)), nil
+	case mysql.MYSQL_TYPE_BIT:
+		nbits := ((meta >> 8) * 8) + (meta & 0xFF)
+		return int(nbits+7) / 8, nil
+	case mysql.MYSQL_TYPE_ENUM:
+		switch meta & 0xFF {
+		case 1, 2:
+			return int(meta & 0xFF), nil
+		}
+		return 0, fmt.Errorf("unknown enum packlen %d", meta&0xFF)
+	case mysql.MYSQL_TYPE_SET:
+		return int(meta & 0xFF), nil
+	case mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_GEOMETRY, mysql.MYSQL_TYPE_JSON:
+		size := int(meta)
+		if size < 1 || size > 4 || len(data) < size {
+			return 0, errCorruptedRows
+		}
+		return size + int(mysql.FixedLengthInt(data[:size])), nil
+	case mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING:
+		return stringLength(data, int(meta))
+	case mysql.MYSQL_TYPE_STRING:
+		return stringLength(data, length)
+	}
+	return 0, fmt.Errorf("unsupport type %d in binlog", tp)
+}
+
+func lengthEncodedInt(data []byte) (uint64, int, error) {
+	if len(data) == 0 {
+		return 0, 0, errCorruptedRows
+	}
+	size := 1
+	switch data[0] {
+	case 0xfc:
+		size = 3
+	case 0xfd:
+		size = 4
+	case 0xfe:
+		size = 9
+	}
+	if len(data) < size {
+		return 0, 0, errCorruptedRows
+	}
+	if size == 1 {
+		return uint64(data[0]), 1, nil
+	}
+	return mysql.FixedLengthInt(data[1:size]), size, nil
+}
+
+func stringLength(data []byte, length int) (int, error) {
+	if length < 256 {
+		if len(data) < 1 {
+			return 0, errCorruptedRows
+		}
+		return 1 + int(data[0]), nil
+	}
+	if len(data) < 2 {
+		return 0, errCorruptedRows
+	}
+	return 2 + int(binary.LittleEndian.Uint16(data)), nil
+}
+
+var compressedBytes = []int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+
+func decimalLength(precision, scale int) int {
+	integral := precision - scale
+	return integral/9*4 + compressedBytes[integral%9] + scale/9*4 + compressedBytes[scale%9]
+}
+
+func isBitSet(bitmap []byte, i int) bool {
+	return i>>3 < len(bitmap) && bitmap[i>>3]&(1<<(uint(i)&7)) > 0
+}
@@ -0,0 +1,144 @@
+package canal
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/JieWaZi/transfer-mysql/jsondiff"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+func TestRewritePartialRows(t *testing.T) {
+	tables := map[uint64]*replication.TableMapEvent{
+		1: {
+			TableID:     1,
+			ColumnCount: 2,
+			ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_JSON},
+			ColumnMeta:  []uint16{0, 4},
+		},
+	}
+	header := []byte{
+		1, 0, 0, 0, 0, 0, // table id
+		0, 0, // flags
+		2, 0, // extra data
+		2, // column count
+	}
+	id := []byte{7, 0, 0, 0}
+	beforeJSON := []byte{2, 0, 0, 0, 0x04, 0x01}
+	diffBody := []byte{byte(jsondiff.OperationReplace), 3, '$', '.', 'a', 3, 0x05, 5, 0}
+	afterJSON := append([]byte{byte(len(diffBody)), 0, 0, 0}, diffBody...)
+	wantDiffs := map[int]map[int][]jsondiff.Diff{
+		1: {1: {{Operation: jsondiff.OperationReplace, Path: "$.a", Value: int64(5)}}},
+	}
+
+	cases := []struct {
+		name   string
+		data   []byte
+		output []byte
+	}{
+		{
+			name: "full before image",
+			data: concat(header, []byte{0x03, 0x03},
+				[]byte{0x00}, id, beforeJSON,
+				[]byte{partialJSONUpdates, 0x01},
+				[]byte{0x00}, id, afterJSON),
+			output: concat(header, []byte{0x03, 0x03},
+				[]byte{0x00}, id, beforeJSON,
+				[]byte{0x00}, id, beforeJSON),
+		},
+		{
+			// 修改前镜像中没有 JSON 字段时改写为 NULL
+			name: "minimal before image",
+			data: concat(header, []byte{0x01, 0x03},
+				[]byte{0x00}, id,
+				[]byte{partialJSONUpdates, 0x01},
+				[]byte{0x00}, id, afterJSON),
+			output: concat(header, []byte{0x01, 0x03},
+				[]byte{0x00}, id,
+				[]byte{0x02}, id),
+		},
+	}
+	for _, c := range cases {
+		output, diffs, err := rewritePartialRows(c.data, 6, tables)
+		if err != nil {
+			t.Errorf("%s: rewritePartialRows err:%s", c.name, err.Error())
+			continue
+		}
+		if !bytes.Equal(output, c.output) {
+			t.Errorf("%s: rewritePartialRows = %v, want %v", c.name, output, c.output)
+		}
+		if !reflect.DeepEqual(diffs, wantDiffs) {
+			t.Errorf("%s: diffs = %+v, want %+v", c.name, diffs, wantDiffs)
+		}
+	}
+
+	// 未设置 PARTIAL_JSON_UPDATES 时修改后镜像保持不变
+	full := concat(header, []byte{0x03, 0x03},
+		[]byte{0x00}, id, beforeJSON,
+		[]byte{0x00},
+		[]byte{0x00}, id, beforeJSON)
+	output, diffs, err := rewritePartialRows(full, 6, tables)
+	if err != nil {
+		t.Fatalf("rewritePartialRows err:%s", err.Error())
+	}
+	if want := concat(header, []byte{0x03, 0x03}, []byte{0x00}, id, beforeJSON, []byte{0x00}, id, beforeJSON); !bytes.Equal(output, want) || len(diffs) != 0 {
+		t.Errorf("rewritePartialRows = %v %+v, want %v", output, diffs, want)
+	}
+
+	// 只有在行边界截断时合法
+	for i := 0; i < len(full); i++ {
+		if _, _, err := rewritePartialRows(full[:i], 6, tables); err == nil && i != len(header)+2 {
+			t.Errorf("rewritePartialRows truncated at %d should fail", i)
+		}
+	}
+	if _, _, err := rewritePartialRows(concat([]byte{2, 0, 0, 0, 0, 0}, header[6:]), 6, tables); err == nil {
+		t.Errorf("rewritePartialRows with unknown table should fail")
+	}
+}
+
+func TestValueLength(t *testing.T) {
+	cases := []struct {
+		data   []byte
+		tp     byte
+		meta   uint16
+		length int
+	}{
+		{data: []byte{1}, tp: mysql.MYSQL_TYPE_TINY, length: 1},
+		{data: []byte{3, 'a', 'b', 'c'}, tp: mysql.MYSQL_TYPE_VARCHAR, meta: 10, length: 4},
+		{data: []byte{3, 0, 'a', 'b', 'c'}, tp: mysql.MYSQL_TYPE_VARCHAR, meta: 300, length: 5},
+		{data: []byte{2, 0, 'a', 'b'}, tp: mysql.MYSQL_TYPE_BLOB, meta: 2, length: 4},
+		{data: []byte{1, 0, 0, 0, 0}, tp: mysql.MYSQL_TYPE_JSON, meta: 4, length: 5},
+		// DECIMAL(10,2)
+		{tp: mysql.MYSQL_TYPE_NEWDECIMAL, meta: 10<<8 | 2, length: 5},
+		{tp: mysql.MYSQL_TYPE_DATETIME2, meta: 3, length: 7},
+		// CHAR(20) 实际类型及长度记录在 meta 中
+		{data: []byte{2, 'a', 'b'}, tp: mysql.MYSQL_TYPE_STRING, meta: uint16(mysql.MYSQL_TYPE_STRING)<<8 | 20, length: 3},
+	}
+	for _, c := range cases {
+		length, err := valueLength(c.data, c.tp, c.meta)
+		if err != nil {
+			t.Errorf("valueLength(%d, %d) err:%s", c.tp, c.meta, err.Error())
+			continue
+		}
+		if length != c.length {
+			t.Errorf("valueLength(%d, %d) = %d, want %d", c.tp, c.meta, length, c.length)
+		}
+	}
+
+	if _, err := valueLength([]byte{1, 0}, mysql.MYSQL_TYPE_BLOB, 4); err == nil {
+		t.Errorf("valueLength with truncated length should fail")
+	}
+	if _, _, err := lengthEncodedInt([]byte{0xfc, 1}); err == nil {
+		t.Errorf("lengthEncodedInt with truncated data should fail")
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	var data []byte
+	for _, part := range parts {
+		data = append(data, part...)
+	}
+	return data
+}
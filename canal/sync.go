@@ -0,0 +1,301 @@
+package canal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/JieWaZi/transfer-mysql/jsondiff"
+	"github.com/google/uuid"
+	"github.com/pingcap/parser/ast"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+	"github.com/siddontang/go-mysql/schema"
+	"github.com/sirupsen/logrus"
+)
+
+// RowsEvent canal.RowsEvent 附带每行镜像中包含的字段。
+// binlog_row_image 为 MINIMAL/NOBLOB 时，镜像中缺少的字段与值为 NULL 的字段都解析为 nil，需按位图区分
+type RowsEvent struct {
+	*canal.RowsEvent
+	// 与 Rows 一一对应，为 nil 时视为包含全部字段
+	Present [][]bool
+	// PARTIAL_UPDATE_ROWS_EVENT 中 JSON 字段的局部修改，按 Rows 下标及字段下标索引，
+	// 对应的修改后镜像中为修改前的值，修改前镜像中没有该字段时为 nil
+	JSONDiffs map[int]map[int][]jsondiff.Diff
+}
+
+// IsPresent 第 row 行镜像中是否包含第 column 个字段
+func (e *RowsEvent) IsPresent(row, column int) bool {
+	if row >= len(e.Present) || e.Present[row] == nil {
+		return true
+	}
+	return column < len(e.Present[row]) && e.Present[row][column]
+}
+
+// EventHandler 行事件通过 OnRowsEvent 回调，不会调用 OnRow
+type EventHandler interface {
+	canal.EventHandler
+	OnRowsEvent(e *RowsEvent) error
+}
+
+// run 直接读取 binlog 代替 canal.RunFrom，canal 只用于获取表结构，
+// 以便拿到 canal 未暴露的字段位图并解析 PARTIAL_UPDATE_ROWS_EVENT
+func (c *Canal) run(ctx context.Context, pos mysql.Position) error {
+	partial := newPartialDecoder(c.cfg)
+	syncer := replication.NewBinlogSyncer(replication.BinlogSyncerConfig{
+		ServerID:                c.cfg.ServerID,
+		Flavor:                  c.cfg.Flavor,
+		Host:                    c.Host,
+		Port:                    uint16(c.Port),
+		User:                    c.User,
+		Password:                c.Password,
+		Charset:                 c.cfg.Charset,
+		HeartbeatPeriod:         c.cfg.HeartbeatPeriod,
+		ReadTimeout:             c.cfg.ReadTimeout,
+		UseDecimal:              c.cfg.UseDecimal,
+		ParseTime:               c.cfg.ParseTime,
+		MaxReconnectAttempts:    c.cfg.MaxReconnectAttempts,
+		TimestampStringLocation: c.cfg.TimestampStringLocation,
+	})
+	c.mu.Lock()
+	c.syncer = syncer
+	c.pos = pos
+	c.mu.Unlock()
+
+	streamer, err := syncer.StartSync(pos)
+	if err != nil {
+		logrus.Errorf("canal start sync at %s:%d err:%s", pos.Name, pos.Pos, err.Error())
+		return err
+	}
+	logrus.Infof("canal start sync at %s:%d", pos.Name, pos.Pos)
+
+	// fake rotate 事件中的文件名，保存新位置前需保留
+	fakeRotateLogName := ""
+	for {
+		ev, err := streamer.GetEvent(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if ev.Header.LogPos == 0 {
+			if e, ok := ev.Event.(*replication.RotateEvent); ok {
+				fakeRotateLogName = string(e.NextLogName)
+			}
+			continue
+		}
+
+		savePos, force := false, false
+		curPos := pos.Pos
+		pos.Pos = ev.Header.LogPos
+		if fakeRotateLogName != "" {
+			pos.Name = fakeRotateLogName
+		}
+
+		switch e := ev.Event.(type) {
+		case *replication.RotateEvent:
+			pos.Name = string(e.NextLogName)
+			pos.Pos = uint32(e.Position)
+			savePos, force = true, true
+			if err := c.handler.OnRotate(e); err != nil {
+				return err
+			}
+		case *replication.FormatDescriptionEvent, *replication.TableMapEvent:
+			if err := partial.feed(ev); err != nil {
+				logrus.Errorf("canal feed partial decoder at %s:%d err:%s", pos.Name, curPos, err.Error())
+				return err
+			}
+			continue
+		case *replication.RowsEvent:
+			if err := c.handleRowsEvent(ev, nil); err != nil && !skipRowsError(err) {
+				logrus.Errorf("canal handle rows event at %s:%d err:%s", pos.Name, curPos, err.Error())
+				return err
+			}
+			continue
+		case *replication.GenericEvent:
+			if ev.Header.EventType != partialUpdateRowsEvent {
+				continue
+			}
+			rewritten, diffs, err := partial.decode(ev)
+			if err == nil {
+				err = c.handleRowsEvent(rewritten, diffs)
+			}
+			if err != nil && !skipRowsError(err) {
+				logrus.Errorf("canal handle partial update rows event at %s:%d err:%s", pos.Name, curPos, err.Error())
+				return err
+			}
+			continue
+		case *replication.XIDEvent:
+			savePos = true
+			if err := c.handler.OnXID(pos); err != nil {
+				return err
+			}
+		case *replication.GTIDEvent:
+			u, _ := uuid.FromBytes(e.SID)
+			gtid, err := mysql.ParseMysqlGTIDSet(fmt.Sprintf("%s:%d", u.String(), e.GNO))
+			if err != nil {
+				return err
+			}
+			if err := c.handler.OnGTID(gtid); err != nil {
+				return err
+			}
+		case *replication.MariadbGTIDEvent:
+			gtid, err := mysql.ParseMariadbGTIDSet(e.GTID.String())
+			if err != nil {
+				return err
+			}
+			if err := c.handler.OnGTID(gtid); err != nil {
+				return err
+			}
+		case *replication.QueryEvent:
+			ddl, err := c.handleQueryEvent(e)
+			if err != nil {
+				return err
+			}
+			if ddl {
+				savePos, force = true, true
+				if err := c.handler.OnDDL(pos, e); err != nil {
+					return err
+				}
+			}
+		default:
+			continue
+		}
+
+		if savePos {
+			fakeRotateLogName = ""
+			c.mu.Lock()
+			c.pos = pos
+			c.mu.Unlock()
+			if err := c.handler.OnPosSynced(pos, nil, force); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func skipRowsError(err error) bool {
+	return err == canal.ErrExcludedTable || err == schema.ErrTableNotExist || err == schema.ErrMissingTableMeta
+}
+
+func (c *Canal) handleRowsEvent(ev *replication.BinlogEvent, diffs map[int]map[int][]jsondiff.Diff) error {
+	e := ev.Event.(*replication.RowsEvent)
+	table, err := c.canal.GetTable(string(e.Table.Schema), string(e.Table.Table))
+	if err != nil {
+		return err
+	}
+	var action string
+	switch ev.Header.EventType {
+	case replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		action = canal.InsertAction
+	case replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		action = canal.DeleteAction
+	case replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		action = canal.UpdateAction
+	default:
+		return fmt.Errorf("%s not supported now", ev.Header.EventType)
+	}
+
+	present := make([][]bool, len(e.Rows))
+	for i := range e.Rows {
+		// update 时修改前后的镜像交替出现，分别对应两个位图
+		bitmap := e.ColumnBitmap1
+		if action == canal.UpdateAction && i%2 == 1 {
+			bitmap = e.ColumnBitmap2
+		}
+		present[i] = columnPresent(bitmap, int(e.ColumnCount))
+	}
+	rowsEvent := &canal.RowsEvent{
+		Table:  table,
+		Action: action,
+		Rows:   e.Rows,
+		Header: ev.Header,
+	}
+	handleUnsigned(rowsEvent)
+	return c.handler.OnRowsEvent(&RowsEvent{RowsEvent: rowsEvent, Present: present, JSONDiffs: diffs})
+}
+
+// handleQueryEvent 表结构变更时清除 canal 的表结构缓存，返回是否为表结构变更
+func (c *Canal) handleQueryEvent(e *replication.QueryEvent) (bool, error) {
+	stmts, _, err := c.parser.Parse(string(e.Query), "", "")
+	if err != nil {
+		logrus.Warnf("canal parse query %s err:%s, skip", string(e.Query), err.Error())
+		return false, nil
+	}
+	ddl := false
+	for _, stmt := range stmts {
+		for _, table := range ddlTables(stmt) {
+			db, name := table.Schema.String(), table.Name.String()
+			if db == "" {
+				db = string(e.Schema)
+			}
+			c.canal.ClearTableCache([]byte(db), []byte(name))
+			logrus.Infof("canal table %s.%s changed, clear table cache", db, name)
+			err := c.handler.OnTableChanged(db, name)
+			if err != nil && err != schema.ErrTableNotExist {
+				return false, err
+			}
+			ddl = true
+		}
+	}
+	return ddl, nil
+}
+
+func ddlTables(stmt ast.StmtNode) []*ast.TableName {
+	switch t := stmt.(type) {
+	case *ast.RenameTableStmt:
+		tables := make([]*ast.TableName, 0, len(t.TableToTables))
+		for _, tableToTable := range t.TableToTables {
+			tables = append(tables, tableToTable.OldTable)
+		}
+		return tables
+	case *ast.AlterTableStmt:
+		return []*ast.TableName{t.Table}
+	case *ast.DropTableStmt:
+		return t.Tables
+	case *ast.CreateTableStmt:
+		return []*ast.TableName{t.Table}
+	case *ast.TruncateTableStmt:
+		return []*ast.TableName{t.Table}
+	}
+	return nil
+}
+
+func columnPresent(bitmap []byte, count int) []bool {
+	present := make([]bool, count)
+	for i := range present {
+		present[i] = isBitSet(bitmap, i)
+	}
+	return present
+}
+
+const maxMediumintUnsigned int32 = 16777215
+
+// handleUnsigned binlog 中整数均按有符号解析，与 canal 一致按表结构转换无符号字段
+func handleUnsigned(e *canal.RowsEvent) {
+	for i := range e.Rows {
+		for _, idx := range e.Table.UnsignedColumns {
+			if idx >= len(e.Rows[i]) {
+				continue
+			}
+			switch value := e.Rows[i][idx].(type) {
+			case int8:
+				e.Rows[i][idx] = uint8(value)
+			case int16:
+				e.Rows[i][idx] = uint16(value)
+			case int32:
+				if value < 0 && e.Table.Columns[idx].Type == schema.TYPE_MEDIUM_INT {
+					e.Rows[i][idx] = uint32(maxMediumintUnsigned + value + 1)
+				} else {
+					e.Rows[i][idx] = uint32(value)
+				}
+			case int64:
+				e.Rows[i][idx] = uint64(value)
+			case int:
+				e.Rows[i][idx] = uint(value)
+			}
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package encoder
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/schema"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ColumnTypeNumber    = "number"
+	ColumnTypeFloat     = "float"
+	ColumnTypeDecimal   = "decimal"
+	ColumnTypeEnum      = "enum"
+	ColumnTypeSet       = "set"
+	ColumnTypeString    = "string"
+	ColumnTypeBinary    = "binary"
+	ColumnTypeBit       = "bit"
+	ColumnTypeJSON      = "json"
+	ColumnTypeDatetime  = "datetime"
+	ColumnTypeTimestamp = "timestamp"
+	ColumnTypeDate      = "date"
+	ColumnTypeTime      = "time"
+	ColumnTypeGeometry  = "geometry"
+)
+
+// Encoder 按字段类型编码 binlog 行数据
+type Encoder struct {
+	// DATETIME 按该时区解释，DATETIME/TIMESTAMP 均以该时区输出
+	Timezone string `env:""`
+	// 空间字段默认输出格式，可在规则中按字段覆盖
+	GeometryFormat string `env:""`
+
+	location *time.Location
+}
+
+func (e *Encoder) SetDefaults() {
+	if e.Timezone == "" {
+		e.Timezone = "Local"
+	}
+	if e.GeometryFormat == "" {
+		e.GeometryFormat = GeometryFormatGeoJSON
+	}
+}
+
+func (e *Encoder) Init() error {
+	location, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		logrus.Errorf("encoder load timezone %s err:%s", e.Timezone, err.Error())
+		return err
+	}
+	e.location = location
+	return nil
+}
+
+func (e *Encoder) Columns(table *schema.Table) []models.Column {
+	columns := make([]models.Column, len(table.Columns))
+	for i := range table.Columns {
+		columns[i] = models.Column{
+			Name:    table.Columns[i].Name,
+			Type:    ColumnType(&table.Columns[i]),
+			RawType: table.Columns[i].RawType,
+		}
+	}
+	return columns
+}
+
+func (e *Encoder) EncodeRow(table *schema.Table, r *rule.Rule, row []interface{}) []interface{} {
+	if row == nil {
+		return nil
+	}
+	values := make([]interface{}, len(row))
+	for i := range row {
+		if i >= len(table.Columns) {
+			values[i] = row[i]
+			continue
+		}
+		values[i] = e.EncodeValue(&table.Columns[i], r, row[i])
+	}
+	return values
+}
+
+func (e *Encoder) EncodeValue(column *schema.TableColumn, r *rule.Rule, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch ColumnType(column) {
+	case ColumnTypeDecimal:
+		return encodeDecimal(value)
+	case ColumnTypeEnum:
+		return encodeEnum(column, value)
+	case ColumnTypeSet:
+		return encodeSet(column, value)
+	case ColumnTypeBit:
+		return encodeBit(column, value)
+	case ColumnTypeBinary:
+		return base64.StdEncoding.EncodeToString(toBytes(value))
+	case ColumnTypeGeometry:
+		format := r.GeometryFormat(column.Name)
+		if format == "" {
+			format = e.GeometryFormat
+		}
+		return encodeGeometry(value, format)
+	case ColumnTypeJSON:
+		return encodeJSON(value)
+	case ColumnTypeDatetime:
+		return e.encodeTime(value, e.location)
+	case ColumnTypeTimestamp:
+		// go-mysql 按进程本地时区格式化 TIMESTAMP
+		return e.encodeTime(value, time.Local)
+	case ColumnTypeString, ColumnTypeDate, ColumnTypeTime:
+		if b, ok := value.([]byte); ok {
+			return string(b)
+		}
+	}
+	return value
+}
+
+// NormalizeValue 回源查询返回的字符串类型为 []byte，与 binlog 解析结果保持一致
+func NormalizeValue(column *schema.TableColumn, value interface{}) interface{} {
+	b, ok := value.([]byte)
+	if !ok {
+		return value
+	}
+	switch ColumnType(column) {
+	case ColumnTypeBinary, ColumnTypeGeometry, ColumnTypeBit:
+		return value
+	}
+	return string(b)
+}
+
+func ColumnType(column *schema.TableColumn) string {
+	rawType := strings.ToLower(column.RawType)
+	switch column.Type {
+	case schema.TYPE_NUMBER, schema.TYPE_MEDIUM_INT:
+		return ColumnTypeNumber
+	case schema.TYPE_FLOAT:
+		return ColumnTypeFloat
+	case schema.TYPE_DECIMAL:
+		return ColumnTypeDecimal
+	case schema.TYPE_ENUM:
+		return ColumnTypeEnum
+	case schema.TYPE_SET:
+		return ColumnTypeSet
+	case schema.TYPE_BIT:
+		return ColumnTypeBit
+	case schema.TYPE_JSON:
+		return ColumnTypeJSON
+	case schema.TYPE_DATETIME:
+		return ColumnTypeDatetime
+	case schema.TYPE_TIMESTAMP:
+		return ColumnTypeTimestamp
+	case schema.TYPE_DATE:
+		return ColumnTypeDate
+	case schema.TYPE_TIME:
+		return ColumnTypeTime
+	case schema.TYPE_POINT:
+		return ColumnTypeGeometry
+	case schema.TYPE_BINARY:
+		return ColumnTypeBinary
+	}
+	if strings.Contains(rawType, "blob") || strings.Contains(rawType, "binary") {
+		return ColumnTypeBinary
+	}
+	if strings.Contains(rawType, "geometry") || strings.Contains(rawType, "point") ||
+		strings.Contains(rawType, "linestring") || strings.Contains(rawType, "polygon") {
+		return ColumnTypeGeometry
+	}
+	return ColumnTypeString
+}
+
+func encodeDecimal(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+func encodeEnum(column *schema.TableColumn, value interface{}) interface{} {
+	index, ok := toInt64(value)
+	if !ok {
+		return value
+	}
+	if index <= 0 || int(index) > len(column.EnumValues) {
+		return ""
+	}
+	return column.EnumValues[index-1]
+}
+
+func encodeSet(column *schema.TableColumn, value interface{}) interface{} {
+	bits, ok := toInt64(value)
+	if !ok {
+		return value
+	}
+	names := make([]string, 0)
+	for i := range column.SetValues {
+		if bits&(1<<uint(i)) != 0 {
+			names = append(names, column.SetValues[i])
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// encodeBit 以 bit(n) 宽度的 0/1 字符串输出
+func encodeBit(column *schema.TableColumn, value interface{}) interface{} {
+	bits, ok := toInt64(value)
+	if !ok {
+		if b, isBytes := value.([]byte); isBytes {
+			for i := range b {
+				bits = bits<<8 | int64(b[i])
+			}
+		} else {
+			return value
+		}
+	}
+	width := 1
+	rawType := strings.ToLower(column.RawType)
+	if start := strings.Index(rawType, "("); start >= 0 {
+		if end := strings.Index(rawType, ")"); end > start {
+			if n, err := strconv.Atoi(rawType[start+1 : end]); err == nil {
+				width = n
+			}
+		}
+	}
+	s := strconv.FormatUint(uint64(bits), 2)
+	if len(s) < width {
+		s = strings.Repeat("0", width-len(s)) + s
+	}
+	return s
+}
+
+func encodeJSON(value interface{}) interface{} {
+	data := toBytes(value)
+	if len(data) == 0 {
+		return nil
+	}
+	if !json.Valid(data) {
+		return string(data)
+	}
+	return json.RawMessage(data)
+}
+
+func encodeGeometry(value interface{}, format string) interface{} {
+	data := toBytes(value)
+	if format == GeometryFormatWKB {
+		return base64.StdEncoding.EncodeToString(data)
+	}
+	srid, g, err := decodeGeometry(data)
+	if err != nil {
+		logrus.Warnf("decode geometry err:%s", err.Error())
+		return base64.StdEncoding.EncodeToString(data)
+	}
+	if format == GeometryFormatWKT {
+		return EWKT(srid, g.WKT())
+	}
+	geoJSON := g.GeoJSON()
+	if srid != 0 {
+		geoJSON["crs"] = geoJSONCRS(srid)
+	}
+	return geoJSON
+}
+
+func (e *Encoder) encodeTime(value interface{}, location *time.Location) interface{} {
+	if location == nil {
+		location = time.Local
+	}
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case string, []byte:
+		s := string(toBytes(v))
+		parsed, err := time.ParseInLocation(mysql.TimeFormat, strings.SplitN(s, ".", 2)[0], location)
+		if err != nil {
+			// 0000-00-00 00:00:00 等无法解析的值原样输出
+			return s
+		}
+		if i := strings.Index(s, "."); i >= 0 {
+			if frac, err := time.ParseDuration("0." + s[i+1:] + "s"); err == nil {
+				parsed = parsed.Add(frac)
+			}
+		}
+		t = parsed
+	default:
+		return value
+	}
+	if e.location != nil {
+		t = t.In(e.location)
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	case uint:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func toBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	}
+	return []byte(fmt.Sprint(value))
+}
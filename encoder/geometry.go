@@ -0,0 +1,305 @@
+package encoder
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	GeometryFormatGeoJSON = "geojson"
+	GeometryFormatWKT     = "wkt"
+	// MySQL 内部格式，4 字节小端序 SRID + WKB，按 base64 输出
+	GeometryFormatWKB = "wkb"
+)
+
+const (
+	wkbPoint              = 1
+	wkbLineString         = 2
+	wkbPolygon            = 3
+	wkbMultiPoint         = 4
+	wkbMultiLineString    = 5
+	wkbMultiPolygon       = 6
+	wkbGeometryCollection = 7
+)
+
+var errInvalidWKB = errors.New("invalid wkb")
+
+// geometry 解析后的几何对象，coordinates 与 GeoJSON 结构一致
+type geometry struct {
+	kind        uint32
+	coordinates interface{}
+	geometries  []*geometry
+}
+
+// decodeGeometry MySQL 空间字段为 4 字节 SRID + WKB
+func decodeGeometry(data []byte) (uint32, *geometry, error) {
+	if len(data) < 4 {
+		return 0, nil, errInvalidWKB
+	}
+	srid := binary.LittleEndian.Uint32(data)
+	r := &wkbReader{data: data[4:]}
+	g, err := r.readGeometry()
+	if err != nil {
+		return 0, nil, err
+	}
+	return srid, g, nil
+}
+
+func (g *geometry) GeoJSON() map[string]interface{} {
+	if g.kind == wkbGeometryCollection {
+		geometries := make([]interface{}, len(g.geometries))
+		for i := range g.geometries {
+			geometries[i] = g.geometries[i].GeoJSON()
+		}
+		return map[string]interface{}{
+			"type":       geometryName(g.kind),
+			"geometries": geometries,
+		}
+	}
+	return map[string]interface{}{
+		"type":        geometryName(g.kind),
+		"coordinates": g.coordinates,
+	}
+}
+
+// EWKT SRID 不为 0 时按 PostGIS 的格式加上前缀，如 SRID=4326;POINT(1 2)
+func EWKT(srid uint32, wkt string) string {
+	if srid == 0 {
+		return wkt
+	}
+	return "SRID=" + strconv.FormatUint(uint64(srid), 10) + ";" + wkt
+}
+
+// ParseEWKT 返回 EWKT 中的 SRID 与 WKT，没有前缀时 SRID 为 0
+func ParseEWKT(ewkt string) (uint32, string) {
+	if !strings.HasPrefix(ewkt, "SRID=") {
+		return 0, ewkt
+	}
+	i := strings.Index(ewkt, ";")
+	if i < 0 {
+		return 0, ewkt
+	}
+	srid, err := strconv.ParseUint(ewkt[len("SRID="):i], 10, 32)
+	if err != nil {
+		return 0, ewkt
+	}
+	return uint32(srid), ewkt[i+1:]
+}
+
+// geoJSONCRS SRID 不为 0 时在 GeoJSON 中使用命名的 crs 标记坐标系
+func geoJSONCRS(srid uint32) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "name",
+		"properties": map[string]interface{}{"name": "EPSG:" + strconv.FormatUint(uint64(srid), 10)},
+	}
+}
+
+func (g *geometry) WKT() string {
+	name := strings.ToUpper(geometryName(g.kind))
+	if g.kind == wkbGeometryCollection {
+		parts := make([]string, len(g.geometries))
+		for i := range g.geometries {
+			parts[i] = g.geometries[i].WKT()
+		}
+		return name + "(" + strings.Join(parts, ",") + ")"
+	}
+	return name + wktCoordinates(g.coordinates)
+}
+
+func wktCoordinates(coordinates interface{}) string {
+	switch v := coordinates.(type) {
+	case []float64:
+		return "(" + formatPoint(v) + ")"
+	case [][]float64:
+		points := make([]string, len(v))
+		for i := range v {
+			points[i] = formatPoint(v[i])
+		}
+		return "(" + strings.Join(points, ",") + ")"
+	case [][][]float64:
+		parts := make([]string, len(v))
+		for i := range v {
+			parts[i] = wktCoordinates(v[i])
+		}
+		return "(" + strings.Join(parts, ",") + ")"
+	case [][][][]float64:
+		parts := make([]string, len(v))
+		for i := range v {
+			parts[i] = wktCoordinates(v[i])
+		}
+		return "(" + strings.Join(parts, ",") + ")"
+	}
+	return "()"
+}
+
+func formatPoint(point []float64) string {
+	coordinates := make([]string, len(point))
+	for i := range point {
+		coordinates[i] = strconv.FormatFloat(point[i], 'f', -1, 64)
+	}
+	return strings.Join(coordinates, " ")
+}
+
+func geometryName(kind uint32) string {
+	switch kind {
+	case wkbPoint:
+		return "Point"
+	case wkbLineString:
+		return "LineString"
+	case wkbPolygon:
+		return "Polygon"
+	case wkbMultiPoint:
+		return "MultiPoint"
+	case wkbMultiLineString:
+		return "MultiLineString"
+	case wkbMultiPolygon:
+		return "MultiPolygon"
+	case wkbGeometryCollection:
+		return "GeometryCollection"
+	}
+	return "Unknown"
+}
+
+type wkbReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func (r *wkbReader) readGeometry() (*geometry, error) {
+	if len(r.data) < 5 {
+		return nil, errInvalidWKB
+	}
+	if r.data[0] == 0 {
+		r.order = binary.BigEndian
+	} else {
+		r.order = binary.LittleEndian
+	}
+	r.data = r.data[1:]
+	kind, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+
+	g := &geometry{kind: kind}
+	switch kind {
+	case wkbPoint:
+		g.coordinates, err = r.readPoint()
+	case wkbLineString:
+		g.coordinates, err = r.readPoints()
+	case wkbPolygon:
+		g.coordinates, err = r.readRings()
+	case wkbMultiPoint:
+		points := make([][]float64, 0)
+		err = r.readChildren(func(child *geometry) {
+			points = append(points, child.coordinates.([]float64))
+		}, wkbPoint)
+		g.coordinates = points
+	case wkbMultiLineString:
+		lines := make([][][]float64, 0)
+		err = r.readChildren(func(child *geometry) {
+			lines = append(lines, child.coordinates.([][]float64))
+		}, wkbLineString)
+		g.coordinates = lines
+	case wkbMultiPolygon:
+		polygons := make([][][][]float64, 0)
+		err = r.readChildren(func(child *geometry) {
+			polygons = append(polygons, child.coordinates.([][][]float64))
+		}, wkbPolygon)
+		g.coordinates = polygons
+	case wkbGeometryCollection:
+		err = r.readChildren(func(child *geometry) {
+			g.geometries = append(g.geometries, child)
+		}, 0)
+	default:
+		err = fmt.Errorf("unsupported wkb geometry type %d", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// readChildren 读取 Multi* 与 GeometryCollection 的子对象，kind 为 0 时不校验类型
+func (r *wkbReader) readChildren(add func(child *geometry), kind uint32) error {
+	count, err := r.readUint32()
+	if err != nil {
+		return err
+	}
+	// 每个子对象至少包含字节序及类型共 5 字节
+	if uint64(len(r.data)) < uint64(count)*5 {
+		return errInvalidWKB
+	}
+	for i := uint32(0); i < count; i++ {
+		child, err := r.readGeometry()
+		if err != nil {
+			return err
+		}
+		if kind != 0 && child.kind != kind {
+			return errInvalidWKB
+		}
+		add(child)
+	}
+	return nil
+}
+
+func (r *wkbReader) readRings() ([][][]float64, error) {
+	count, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	// 每个环至少包含 4 字节的点数
+	if uint64(len(r.data)) < uint64(count)*4 {
+		return nil, errInvalidWKB
+	}
+	rings := make([][][]float64, 0, count)
+	for i := uint32(0); i < count; i++ {
+		ring, err := r.readPoints()
+		if err != nil {
+			return nil, err
+		}
+		rings = append(rings, ring)
+	}
+	return rings, nil
+}
+
+func (r *wkbReader) readPoints() ([][]float64, error) {
+	count, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(r.data)) < uint64(count)*16 {
+		return nil, errInvalidWKB
+	}
+	points := make([][]float64, 0, count)
+	for i := uint32(0); i < count; i++ {
+		point, err := r.readPoint()
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+func (r *wkbReader) readPoint() ([]float64, error) {
+	if len(r.data) < 16 {
+		return nil, errInvalidWKB
+	}
+	x := math.Float64frombits(r.order.Uint64(r.data))
+	y := math.Float64frombits(r.order.Uint64(r.data[8:]))
+	r.data = r.data[16:]
+	return []float64{x, y}, nil
+}
+
+func (r *wkbReader) readUint32() (uint32, error) {
+	if len(r.data) < 4 {
+		return 0, errInvalidWKB
+	}
+	v := r.order.Uint32(r.data)
+	r.data = r.data[4:]
+	return v, nil
+}
@@ -0,0 +1,179 @@
+package encoder
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// wkb 按小端序拼接 MySQL 空间字段，SRID 为 4326
+type wkb []byte
+
+func newWKB() wkb {
+	return wkb{0xe6, 0x10, 0, 0}
+}
+
+func (w wkb) header(kind uint32) wkb {
+	return w.byte(1).uint32(kind)
+}
+
+func (w wkb) byte(b byte) wkb {
+	return append(w, b)
+}
+
+func (w wkb) uint32(v uint32) wkb {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return append(w, b...)
+}
+
+func (w wkb) point(x, y float64) wkb {
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint64(b, math.Float64bits(x))
+	binary.LittleEndian.PutUint64(b[8:], math.Float64bits(y))
+	return append(w, b...)
+}
+
+func TestDecodeGeometry(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		wkt  string
+		json map[string]interface{}
+	}{
+		{
+			name: "point",
+			data: newWKB().header(wkbPoint).point(1, 2),
+			wkt:  "POINT(1 2)",
+			json: map[string]interface{}{"type": "Point", "coordinates": []float64{1, 2}},
+		},
+		{
+			name: "linestring",
+			data: newWKB().header(wkbLineString).uint32(2).point(0, 0).point(1.5, 1),
+			wkt:  "LINESTRING(0 0,1.5 1)",
+			json: map[string]interface{}{"type": "LineString", "coordinates": [][]float64{{0, 0}, {1.5, 1}}},
+		},
+		{
+			name: "polygon",
+			data: newWKB().header(wkbPolygon).uint32(1).uint32(4).point(0, 0).point(1, 0).point(1, 1).point(0, 0),
+			wkt:  "POLYGON((0 0,1 0,1 1,0 0))",
+			json: map[string]interface{}{"type": "Polygon", "coordinates": [][][]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}},
+		},
+		{
+			name: "multipoint",
+			data: newWKB().header(wkbMultiPoint).uint32(2).header(wkbPoint).point(1, 2).header(wkbPoint).point(3, 4),
+			wkt:  "MULTIPOINT(1 2,3 4)",
+			json: map[string]interface{}{"type": "MultiPoint", "coordinates": [][]float64{{1, 2}, {3, 4}}},
+		},
+		{
+			name: "geometrycollection",
+			data: newWKB().header(wkbGeometryCollection).uint32(1).header(wkbPoint).point(1, 2),
+			wkt:  "GEOMETRYCOLLECTION(POINT(1 2))",
+			json: map[string]interface{}{"type": "GeometryCollection", "geometries": []interface{}{
+				map[string]interface{}{"type": "Point", "coordinates": []float64{1, 2}},
+			}},
+		},
+	}
+	for _, c := range cases {
+		srid, g, err := decodeGeometry(c.data)
+		if err != nil {
+			t.Errorf("%s: decodeGeometry err:%s", c.name, err.Error())
+			continue
+		}
+		if srid != 4326 {
+			t.Errorf("%s: srid = %d, want 4326", c.name, srid)
+		}
+		if wkt := g.WKT(); wkt != c.wkt {
+			t.Errorf("%s: WKT = %s, want %s", c.name, wkt, c.wkt)
+		}
+		if json := g.GeoJSON(); !reflect.DeepEqual(json, c.json) {
+			t.Errorf("%s: GeoJSON = %+v, want %+v", c.name, json, c.json)
+		}
+	}
+}
+
+func TestDecodeGeometryBigEndian(t *testing.T) {
+	data := []byte{0, 0, 0, 0, 0, 0, 0, 0, wkbPoint}
+	x := make([]byte, 16)
+	binary.BigEndian.PutUint64(x, math.Float64bits(3))
+	binary.BigEndian.PutUint64(x[8:], math.Float64bits(4))
+	_, g, err := decodeGeometry(append(data, x...))
+	if err != nil {
+		t.Fatalf("decodeGeometry err:%s", err.Error())
+	}
+	if wkt := g.WKT(); wkt != "POINT(3 4)" {
+		t.Errorf("WKT = %s, want POINT(3 4)", wkt)
+	}
+}
+
+func TestDecodeGeometryInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{name: "short srid", data: []byte{0, 0}},
+		{name: "short point", data: newWKB().header(wkbPoint).point(1, 2)[:20]},
+		{name: "unknown type", data: newWKB().header(99)},
+		// 数量远超剩余字节时直接报错，不按数量预分配
+		{name: "huge point count", data: newWKB().header(wkbLineString).uint32(math.MaxUint32)},
+		{name: "huge ring count", data: newWKB().header(wkbPolygon).uint32(math.MaxUint32)},
+		{name: "huge child count", data: newWKB().header(wkbMultiPolygon).uint32(math.MaxUint32)},
+		{name: "truncated ring", data: newWKB().header(wkbPolygon).uint32(2).uint32(1).point(0, 0)},
+		{name: "mismatched child", data: newWKB().header(wkbMultiPoint).uint32(1).header(wkbLineString).uint32(0)},
+	}
+	for _, c := range cases {
+		if _, _, err := decodeGeometry(c.data); err == nil {
+			t.Errorf("%s: decodeGeometry should fail", c.name)
+		}
+	}
+}
+
+func TestEncodeGeometry(t *testing.T) {
+	point := newWKB().header(wkbPoint).point(1, 2)
+	noSRID := append(wkb{0, 0, 0, 0}, point[4:]...)
+	crs := map[string]interface{}{"type": "name", "properties": map[string]interface{}{"name": "EPSG:4326"}}
+	cases := []struct {
+		name   string
+		data   []byte
+		format string
+		want   interface{}
+	}{
+		{name: "wkt", data: point, format: GeometryFormatWKT, want: "SRID=4326;POINT(1 2)"},
+		{name: "wkt without srid", data: noSRID, format: GeometryFormatWKT, want: "POINT(1 2)"},
+		{
+			name:   "geojson",
+			data:   point,
+			format: GeometryFormatGeoJSON,
+			want:   map[string]interface{}{"type": "Point", "coordinates": []float64{1, 2}, "crs": crs},
+		},
+		{
+			name:   "geojson without srid",
+			data:   noSRID,
+			format: GeometryFormatGeoJSON,
+			want:   map[string]interface{}{"type": "Point", "coordinates": []float64{1, 2}},
+		},
+	}
+	for _, c := range cases {
+		if got := encodeGeometry([]byte(c.data), c.format); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: encodeGeometry = %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseEWKT(t *testing.T) {
+	cases := []struct {
+		ewkt string
+		srid uint32
+		wkt  string
+	}{
+		{ewkt: "SRID=4326;POINT(1 2)", srid: 4326, wkt: "POINT(1 2)"},
+		{ewkt: "POINT(1 2)", wkt: "POINT(1 2)"},
+		{ewkt: "SRID=x;POINT(1 2)", wkt: "SRID=x;POINT(1 2)"},
+	}
+	for _, c := range cases {
+		if srid, wkt := ParseEWKT(c.ewkt); srid != c.srid || wkt != c.wkt {
+			t.Errorf("ParseEWKT(%s) = %d %s, want %d %s", c.ewkt, srid, wkt, c.srid, c.wkt)
+		}
+	}
+}
@@ -2,6 +2,30 @@ package global
 
 import (
 	"github.com/JieWaZi/transfer-mysql/canal"
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/httpserver"
+	"github.com/JieWaZi/transfer-mysql/pull"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink/amqp"
+	"github.com/JieWaZi/transfer-mysql/sink/applier"
+	"github.com/JieWaZi/transfer-mysql/sink/archive"
+	"github.com/JieWaZi/transfer-mysql/sink/assembler"
+	"github.com/JieWaZi/transfer-mysql/sink/clickhouse"
+	"github.com/JieWaZi/transfer-mysql/sink/elasticsearch"
+	"github.com/JieWaZi/transfer-mysql/sink/feed"
+	"github.com/JieWaZi/transfer-mysql/sink/file"
+	"github.com/JieWaZi/transfer-mysql/sink/invalidator"
+	"github.com/JieWaZi/transfer-mysql/sink/kafka"
+	"github.com/JieWaZi/transfer-mysql/sink/live"
+	"github.com/JieWaZi/transfer-mysql/sink/mongodb"
+	"github.com/JieWaZi/transfer-mysql/sink/nats"
+	"github.com/JieWaZi/transfer-mysql/sink/parquet"
+	"github.com/JieWaZi/transfer-mysql/sink/postgres"
+	"github.com/JieWaZi/transfer-mysql/sink/redis"
+	"github.com/JieWaZi/transfer-mysql/sink/search"
+	"github.com/JieWaZi/transfer-mysql/sink/sqlite"
+	"github.com/JieWaZi/transfer-mysql/sink/webhook"
+	"github.com/JieWaZi/transfer-mysql/source"
 	"github.com/JieWaZi/transfer-mysql/storage"
 	"github.com/JieWaZi/transfer-mysql/utils"
 )
@@ -16,7 +40,32 @@ var Config = Configuration{
 		Tables:    []string{"user", "user1"},
 	},
 	BoltStorage:             &storage.BoltStorage{},
+	Source:                  &source.Source{},
+	Encoder:                 &encoder.Encoder{},
+	HTTP:                    &httpserver.Server{},
+	Kafka:                   &kafka.Kafka{},
+	Redis:                   &redis.Redis{},
+	Invalidator:             &invalidator.Invalidator{},
+	Elasticsearch:           &elasticsearch.Elasticsearch{},
+	Assembler:               &assembler.Assembler{},
+	Webhook:                 &webhook.Webhook{MaxRetries: -1},
+	AMQP:                    &amqp.AMQP{},
+	NATS:                    &nats.NATS{},
+	Applier:                 &applier.Applier{},
+	Postgres:                &postgres.Postgres{},
+	ClickHouse:              &clickhouse.ClickHouse{},
+	MongoDB:                 &mongodb.MongoDB{},
+	File:                    &file.File{},
+	Parquet:                 &parquet.Parquet{},
+	Archive:                 &archive.Archive{},
+	Feed:                    &feed.Feed{},
+	Live:                    &live.Live{},
+	Pull:                    &pull.API{},
+	Search:                  &search.Search{},
+	SQLite:                  &sqlite.SQLite{},
 	HandlerRowEventPoolSize: 20,
+	ConsumerBatchSize:       100,
+	ConsumerMaxAttempts:     10,
 }
 
 type Configuration struct {
@@ -29,7 +78,82 @@ type Configuration struct {
 	/*---------BoltStorage配置----------*/
 	BoltStorage *storage.BoltStorage
 
+	/*---------回源查询配置----------*/
+	Source *source.Source
+
+	/*---------字段编码配置----------*/
+	Encoder *encoder.Encoder
+
+	/*---------同步规则配置----------*/
+	Rules rule.Rules `env:""`
+
+	/*---------HTTP服务配置----------*/
+	HTTP *httpserver.Server
+
+	/*---------Kafka配置----------*/
+	Kafka *kafka.Kafka
+
+	/*---------Redis配置----------*/
+	Redis *redis.Redis
+
+	/*---------缓存失效配置----------*/
+	Invalidator *invalidator.Invalidator
+
+	/*---------Elasticsearch配置----------*/
+	Elasticsearch *elasticsearch.Elasticsearch
+
+	/*---------关联文档组装配置----------*/
+	Assembler *assembler.Assembler
+
+	/*---------Webhook配置----------*/
+	Webhook *webhook.Webhook
+
+	/*---------AMQP配置----------*/
+	AMQP *amqp.AMQP
+
+	/*---------NATS配置----------*/
+	NATS *nats.NATS
+
+	/*---------MySQL回放配置----------*/
+	Applier *applier.Applier
+
+	/*---------Postgres配置----------*/
+	Postgres *postgres.Postgres
+
+	/*---------ClickHouse配置----------*/
+	ClickHouse *clickhouse.ClickHouse
+
+	/*---------MongoDB配置----------*/
+	MongoDB *mongodb.MongoDB
+
+	/*---------滚动文件配置----------*/
+	File *file.File
+
+	/*---------Parquet配置----------*/
+	Parquet *parquet.Parquet
+
+	/*---------S3归档配置----------*/
+	Archive *archive.Archive
+
+	/*---------gRPC订阅配置----------*/
+	Feed *feed.Feed
+
+	/*---------实时推送配置----------*/
+	Live *live.Live
+
+	/*---------拉取消费配置----------*/
+	Pull *pull.API
+
+	/*---------全文索引配置----------*/
+	Search *search.Search
+
+	/*---------SQLite镜像配置----------*/
+	SQLite *sqlite.SQLite
+
 	HandlerRowEventPoolSize uint32 `env:""`
+	ConsumerBatchSize       uint32 `env:""`
+	// sink 写入失败的最大尝试次数，超过后逐条写入，仍失败的记录转入死信队列，0 表示一直重试
+	ConsumerMaxAttempts uint32 `env:""`
 }
 
 func init() {
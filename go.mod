@@ -1,19 +1,39 @@
 module github.com/JieWaZi/transfer-mysql
 
-go 1.13
+go 1.15
 
 require (
 	git.querycap.com/tools/conflogger/v2 v2.4.3 // indirect
+	github.com/Shopify/sarama v1.27.2
+	github.com/alicebob/miniredis/v2 v2.14.1
+	github.com/blevesearch/bleve v1.0.14
+	github.com/bradfitz/gomemcache v0.0.0-20190913173617-a41fca850d0b
 	github.com/go-courier/envconf v1.3.0
 	github.com/go-courier/metax v1.2.1
 	github.com/go-courier/reflectx v1.3.4
+	github.com/go-redis/redis/v8 v8.3.4
+	github.com/golang/protobuf v1.4.2
 	github.com/google/uuid v1.1.2
+	github.com/gorilla/websocket v1.4.2
+	github.com/jackc/pgx/v4 v4.10.1
+	github.com/klauspost/compress v1.11.12
+	github.com/mattn/go-sqlite3 v1.14.5
+	github.com/nats-io/nats-server/v2 v2.2.6
+	github.com/nats-io/nats.go v1.11.0
+	github.com/pingcap/parser v0.0.0-20190506092653-e336082eb825
 	github.com/siddontang/go-mysql v1.1.0
 	github.com/sirupsen/logrus v1.7.0
 	github.com/spf13/cobra v1.1.1
+	github.com/streadway/amqp v1.0.0
+	github.com/xitongsys/parquet-go v1.5.4
+	github.com/xitongsys/parquet-go-source v0.0.0-20201108113611-f372b7d813be
 	go.etcd.io/bbolt v1.3.5
+	go.mongodb.org/mongo-driver v1.4.4
 	go.opentelemetry.io/otel v0.13.0
 	go.opentelemetry.io/otel/sdk v0.13.0
-	go.uber.org/atomic v1.4.0
+	go.uber.org/atomic v1.6.0
+	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e
+	google.golang.org/grpc v1.33.2
+	google.golang.org/protobuf v1.25.0
 	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,71 @@
+package httpserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// mux 各模块在 Init 时注册路由，与 Server 的初始化顺序无关
+var mux = http.NewServeMux()
+
+func Handle(pattern string, handler http.Handler) {
+	mux.Handle(pattern, handler)
+}
+
+func HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	mux.HandleFunc(pattern, handler)
+}
+
+// Server 进程内的 HTTP 服务，实时推送、拉取消费、搜索等接口共用
+type Server struct {
+	Enabled bool   `env:""`
+	Addr    string `env:""`
+	// 只限制读取请求头，推送类接口为长连接不设置写超时
+	ReadHeaderTimeoutMs int `env:""`
+
+	server *http.Server
+}
+
+func (s *Server) SetDefaults() {
+	if s.Addr == "" {
+		s.Addr = ":8080"
+	}
+	if s.ReadHeaderTimeoutMs == 0 {
+		s.ReadHeaderTimeoutMs = 10000
+	}
+}
+
+func (s *Server) Init() error {
+	if !s.Enabled || s.server != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		logrus.Errorf("http server listen %s err:%s", s.Addr, err.Error())
+		return err
+	}
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: time.Duration(s.ReadHeaderTimeoutMs) * time.Millisecond,
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("http server serve err:%s", err.Error())
+		}
+	}()
+	logrus.Infof("http server listen on %s", s.Addr)
+	return nil
+}
+
+func (s *Server) Close() {
+	if s.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	s.server.Shutdown(ctx)
+}
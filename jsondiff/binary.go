@@ -0,0 +1,385 @@
+package jsondiff
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// MySQL 二进制 JSON 类型
+const (
+	typeSmallObject = 0x00
+	typeLargeObject = 0x01
+	typeSmallArray  = 0x02
+	typeLargeArray  = 0x03
+	typeLiteral     = 0x04
+	typeInt16       = 0x05
+	typeUint16      = 0x06
+	typeInt32       = 0x07
+	typeUint32      = 0x08
+	typeInt64       = 0x09
+	typeUint64      = 0x0a
+	typeDouble      = 0x0b
+	typeString      = 0x0c
+	typeOpaque      = 0x0f
+
+	literalNull  = 0x00
+	literalTrue  = 0x01
+	literalFalse = 0x02
+)
+
+// opaque 值中的 MySQL 字段类型
+const (
+	mysqlTypeTimestamp  = 7
+	mysqlTypeDate       = 10
+	mysqlTypeTime       = 11
+	mysqlTypeDatetime   = 12
+	mysqlTypeNewDecimal = 246
+)
+
+var errCorrupted = errors.New("corrupted json binary")
+
+// DecodeBinary 将 MySQL 二进制 JSON 解析为 interface{}
+func DecodeBinary(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return decodeValue(data[0], data[1:])
+}
+
+func decodeValue(tp byte, data []byte) (interface{}, error) {
+	switch tp {
+	case typeSmallObject:
+		return decodeObjectOrArray(data, false, true)
+	case typeLargeObject:
+		return decodeObjectOrArray(data, true, true)
+	case typeSmallArray:
+		return decodeObjectOrArray(data, false, false)
+	case typeLargeArray:
+		return decodeObjectOrArray(data, true, false)
+	case typeLiteral:
+		if len(data) < 1 {
+			return nil, errCorrupted
+		}
+		return decodeLiteral(data[0])
+	case typeInt16:
+		if len(data) < 2 {
+			return nil, errCorrupted
+		}
+		return int64(int16(binary.LittleEndian.Uint16(data))), nil
+	case typeUint16:
+		if len(data) < 2 {
+			return nil, errCorrupted
+		}
+		return uint64(binary.LittleEndian.Uint16(data)), nil
+	case typeInt32:
+		if len(data) < 4 {
+			return nil, errCorrupted
+		}
+		return int64(int32(binary.LittleEndian.Uint32(data))), nil
+	case typeUint32:
+		if len(data) < 4 {
+			return nil, errCorrupted
+		}
+		return uint64(binary.LittleEndian.Uint32(data)), nil
+	case typeInt64:
+		if len(data) < 8 {
+			return nil, errCorrupted
+		}
+		return int64(binary.LittleEndian.Uint64(data)), nil
+	case typeUint64:
+		if len(data) < 8 {
+			return nil, errCorrupted
+		}
+		return binary.LittleEndian.Uint64(data), nil
+	case typeDouble:
+		if len(data) < 8 {
+			return nil, errCorrupted
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), nil
+	case typeString:
+		length, n, err := decodeVariableLength(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < n+length {
+			return nil, errCorrupted
+		}
+		return string(data[n : n+length]), nil
+	case typeOpaque:
+		return decodeOpaque(data)
+	}
+	return nil, fmt.Errorf("unknown json binary type %d", tp)
+}
+
+func decodeLiteral(b byte) (interface{}, error) {
+	switch b {
+	case literalNull:
+		return nil, nil
+	case literalTrue:
+		return true, nil
+	case literalFalse:
+		return false, nil
+	}
+	return nil, fmt.Errorf("unknown json literal %d", b)
+}
+
+func decodeObjectOrArray(data []byte, large bool, isObject bool) (interface{}, error) {
+	offsetSize := 2
+	if large {
+		offsetSize = 4
+	}
+	if len(data) < 2*offsetSize {
+		return nil, errCorrupted
+	}
+	count := readOffset(data, large)
+	size := readOffset(data[offsetSize:], large)
+	if len(data) < size {
+		return nil, errCorrupted
+	}
+
+	keyEntrySize := offsetSize + 2
+	valueEntrySize := 1 + offsetSize
+	headerSize := 2 * offsetSize
+	if isObject {
+		headerSize += count * keyEntrySize
+	}
+	headerSize += count * valueEntrySize
+	if headerSize > size {
+		return nil, errCorrupted
+	}
+
+	keys := make([]string, count)
+	if isObject {
+		for i := 0; i < count; i++ {
+			entry := 2*offsetSize + i*keyEntrySize
+			keyOffset := readOffset(data[entry:], large)
+			keyLength := int(binary.LittleEndian.Uint16(data[entry+offsetSize:]))
+			if keyOffset+keyLength > size {
+				return nil, errCorrupted
+			}
+			keys[i] = string(data[keyOffset : keyOffset+keyLength])
+		}
+	}
+
+	values := make([]interface{}, count)
+	for i := 0; i < count; i++ {
+		entry := 2 * offsetSize
+		if isObject {
+			entry += count * keyEntrySize
+		}
+		entry += i * valueEntrySize
+		tp := data[entry]
+		if isInlineValue(tp, large) {
+			value, err := decodeValue(tp, data[entry+1:entry+1+offsetSize])
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+			continue
+		}
+		valueOffset := readOffset(data[entry+1:], large)
+		if valueOffset >= size {
+			return nil, errCorrupted
+		}
+		value, err := decodeValue(tp, data[valueOffset:size])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+
+	if !isObject {
+		return values, nil
+	}
+	object := make(map[string]interface{}, count)
+	for i := range keys {
+		object[keys[i]] = values[i]
+	}
+	return object, nil
+}
+
+func isInlineValue(tp byte, large bool) bool {
+	switch tp {
+	case typeLiteral, typeInt16, typeUint16:
+		return true
+	case typeInt32, typeUint32:
+		return large
+	}
+	return false
+}
+
+func readOffset(data []byte, large bool) int {
+	if large {
+		return int(binary.LittleEndian.Uint32(data))
+	}
+	return int(binary.LittleEndian.Uint16(data))
+}
+
+func decodeVariableLength(data []byte) (int, int, error) {
+	length := 0
+	for i := 0; i < 5 && i < len(data); i++ {
+		b := data[i]
+		length |= int(b&0x7f) << uint(7*i)
+		if b&0x80 == 0 {
+			return length, i + 1, nil
+		}
+	}
+	return 0, 0, errCorrupted
+}
+
+func decodeOpaque(data []byte) (interface{}, error) {
+	if len(data) < 1 {
+		return nil, errCorrupted
+	}
+	fieldType := data[0]
+	length, n, err := decodeVariableLength(data[1:])
+	if err != nil {
+		return nil, err
+	}
+	data = data[1+n:]
+	if len(data) < length {
+		return nil, errCorrupted
+	}
+	data = data[:length]
+
+	switch fieldType {
+	case mysqlTypeNewDecimal:
+		return decodeDecimal(data)
+	case mysqlTypeDate, mysqlTypeDatetime, mysqlTypeTimestamp:
+		if len(data) < 8 {
+			return nil, errCorrupted
+		}
+		return formatPackedDatetime(int64(binary.LittleEndian.Uint64(data)), fieldType == mysqlTypeDate), nil
+	case mysqlTypeTime:
+		if len(data) < 8 {
+			return nil, errCorrupted
+		}
+		return formatPackedTime(int64(binary.LittleEndian.Uint64(data))), nil
+	}
+	return "base64:type" + fmt.Sprint(fieldType) + ":" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func formatPackedDatetime(v int64, dateOnly bool) string {
+	if v < 0 {
+		v = -v
+	}
+	frac := v % (1 << 24)
+	ymdhms := v >> 24
+	ymd := ymdhms >> 17
+	ym := ymd >> 5
+	hms := ymdhms % (1 << 17)
+
+	day := ymd % (1 << 5)
+	month := ym % 13
+	year := ym / 13
+	second := hms % (1 << 6)
+	minute := (hms >> 6) % (1 << 6)
+	hour := hms >> 12
+
+	if dateOnly {
+		return fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+	}
+	if frac != 0 {
+		return fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d.%06d", year, month, day, hour, minute, second, frac)
+	}
+	return fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d", year, month, day, hour, minute, second)
+}
+
+func formatPackedTime(v int64) string {
+	sign := ""
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	frac := v % (1 << 24)
+	hms := v >> 24
+	hour := (hms >> 12) % (1 << 10)
+	minute := (hms >> 6) % (1 << 6)
+	second := hms % (1 << 6)
+	if frac != 0 {
+		return fmt.Sprintf("%s%02d:%02d:%02d.%06d", sign, hour, minute, second, frac)
+	}
+	return fmt.Sprintf("%s%02d:%02d:%02d", sign, hour, minute, second)
+}
+
+var compressedBytes = []int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+
+// decodeDecimal opaque 中的 decimal 以 precision、scale 开头，之后为 MySQL decimal 二进制格式
+func decodeDecimal(data []byte) (string, error) {
+	if len(data) < 2 {
+		return "", errCorrupted
+	}
+	precision := int(data[0])
+	scale := int(data[1])
+	data = data[2:]
+
+	integral := precision - scale
+	uncompIntegral := integral / 9
+	uncompFractional := scale / 9
+	compIntegral := integral - uncompIntegral*9
+	compFractional := scale - uncompFractional*9
+	size := uncompIntegral*4 + compressedBytes[compIntegral] + uncompFractional*4 + compressedBytes[compFractional]
+	if len(data) < size {
+		return "", errCorrupted
+	}
+
+	buf := make([]byte, size)
+	copy(buf, data[:size])
+	positive := buf[0]&0x80 == 0x80
+	buf[0] ^= 0x80
+	if !positive {
+		for i := range buf {
+			buf[i] ^= 0xff
+		}
+	}
+
+	var res strings.Builder
+	if !positive {
+		res.WriteString("-")
+	}
+	pos := 0
+	readInt := func(n int) uint32 {
+		var v uint32
+		for i := 0; i < n; i++ {
+			v = v<<8 | uint32(buf[pos+i])
+		}
+		pos += n
+		return v
+	}
+
+	started := false
+	if n := compressedBytes[compIntegral]; n > 0 {
+		v := readInt(n)
+		if v > 0 {
+			res.WriteString(fmt.Sprint(v))
+			started = true
+		}
+	}
+	for i := 0; i < uncompIntegral; i++ {
+		v := readInt(4)
+		if started {
+			res.WriteString(fmt.Sprintf("%09d", v))
+		} else if v > 0 {
+			res.WriteString(fmt.Sprint(v))
+			started = true
+		}
+	}
+	if !started {
+		res.WriteString("0")
+	}
+
+	if scale > 0 {
+		res.WriteString(".")
+		for i := 0; i < uncompFractional; i++ {
+			res.WriteString(fmt.Sprintf("%09d", readInt(4)))
+		}
+		if n := compressedBytes[compFractional]; n > 0 {
+			res.WriteString(fmt.Sprintf("%0*d", compFractional, readInt(n)))
+		}
+	}
+	return res.String(), nil
+}
@@ -0,0 +1,325 @@
+package jsondiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Operation 与 MySQL enum_json_diff_operation 一致
+type Operation byte
+
+const (
+	OperationReplace Operation = 0
+	OperationInsert  Operation = 1
+	OperationRemove  Operation = 2
+)
+
+func (o Operation) String() string {
+	switch o {
+	case OperationReplace:
+		return "replace"
+	case OperationInsert:
+		return "insert"
+	case OperationRemove:
+		return "remove"
+	}
+	return "unknown"
+}
+
+// Diff binlog_row_value_options=PARTIAL_JSON 时，一次 JSON 字段局部修改
+type Diff struct {
+	Operation Operation
+	Path      string
+	Value     interface{}
+}
+
+// PatchOperation RFC 6902 JSON Patch
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Decode 解析 PARTIAL_UPDATE_ROWS_EVENT 中 JSON 字段的 diff 数据
+func Decode(data []byte) ([]Diff, error) {
+	if len(data) < 4 {
+		return nil, errCorrupted
+	}
+	length := int(binary.LittleEndian.Uint32(data))
+	data = data[4:]
+	if len(data) < length {
+		return nil, errCorrupted
+	}
+	data = data[:length]
+
+	diffs := make([]Diff, 0)
+	for len(data) > 0 {
+		operation := Operation(data[0])
+		if operation > OperationRemove {
+			return nil, fmt.Errorf("unknown json diff operation %d", operation)
+		}
+		data = data[1:]
+
+		pathLength, n, err := readLengthEncodedInt(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+		if uint64(len(data)) < pathLength {
+			return nil, errCorrupted
+		}
+		diff := Diff{
+			Operation: operation,
+			Path:      string(data[:pathLength]),
+		}
+		data = data[pathLength:]
+
+		if operation != OperationRemove {
+			valueLength, n, err := readLengthEncodedInt(data)
+			if err != nil {
+				return nil, err
+			}
+			data = data[n:]
+			if uint64(len(data)) < valueLength {
+				return nil, errCorrupted
+			}
+			diff.Value, err = DecodeBinary(data[:valueLength])
+			if err != nil {
+				return nil, err
+			}
+			data = data[valueLength:]
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// Apply 将 diff 应用到修改前的 JSON 文档上，得到完整的修改后文档
+func Apply(before interface{}, diffs []Diff) (interface{}, error) {
+	doc := before
+	for _, diff := range diffs {
+		legs, err := parsePath(diff.Path)
+		if err != nil {
+			return nil, err
+		}
+		doc, err = applyDiff(doc, legs, diff)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// ApplyJSON 同 Apply，输入输出为 JSON 文本
+func ApplyJSON(before []byte, diffs []Diff) ([]byte, error) {
+	var doc interface{}
+	if len(before) > 0 {
+		// 保留数字原文，避免大整数经 float64 丢失精度
+		decoder := json.NewDecoder(bytes.NewReader(before))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, err
+		}
+	}
+	after, err := Apply(doc, diffs)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(after)
+}
+
+// Patch 将 diff 转换为 JSON Patch
+func Patch(diffs []Diff) ([]PatchOperation, error) {
+	operations := make([]PatchOperation, 0, len(diffs))
+	for _, diff := range diffs {
+		legs, err := parsePath(diff.Path)
+		if err != nil {
+			return nil, err
+		}
+		pointer := make([]string, len(legs))
+		for i, leg := range legs {
+			if leg.isIndex {
+				pointer[i] = strconv.Itoa(leg.index)
+				continue
+			}
+			pointer[i] = strings.Replace(strings.Replace(leg.key, "~", "~0", -1), "/", "~1", -1)
+		}
+		operation := PatchOperation{
+			Path:  "/" + strings.Join(pointer, "/"),
+			Value: diff.Value,
+		}
+		if len(legs) == 0 {
+			operation.Path = ""
+		}
+		switch diff.Operation {
+		case OperationReplace:
+			operation.Op = "replace"
+		case OperationInsert:
+			operation.Op = "add"
+		case OperationRemove:
+			operation.Op = "remove"
+			operation.Value = nil
+		}
+		operations = append(operations, operation)
+	}
+	return operations, nil
+}
+
+type pathLeg struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parsePath 解析 MySQL JSON path，如 $.a."b c"[1]
+func parsePath(path string) ([]pathLeg, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid json path %s", path)
+	}
+	legs := make([]pathLeg, 0)
+	rest := path[1:]
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if strings.HasPrefix(rest, "\"") {
+				end := 1
+				for end < len(rest) && rest[end] != '"' {
+					if rest[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(rest) {
+					return nil, fmt.Errorf("invalid json path %s", path)
+				}
+				key, err := strconv.Unquote(rest[:end+1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid json path %s", path)
+				}
+				legs = append(legs, pathLeg{key: key})
+				rest = rest[end+1:]
+				continue
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid json path %s", path)
+			}
+			legs = append(legs, pathLeg{key: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid json path %s", path)
+			}
+			index, err := strconv.Atoi(strings.TrimSpace(rest[1:end]))
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid json path %s", path)
+			}
+			legs = append(legs, pathLeg{index: index, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid json path %s", path)
+		}
+	}
+	return legs, nil
+}
+
+func applyDiff(doc interface{}, legs []pathLeg, diff Diff) (interface{}, error) {
+	if len(legs) == 0 {
+		if diff.Operation == OperationRemove {
+			return nil, errors.New("can not remove json document root")
+		}
+		return diff.Value, nil
+	}
+
+	leg := legs[0]
+	last := len(legs) == 1
+	if leg.isIndex {
+		array, ok := doc.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("json path %s: not an array", diff.Path)
+		}
+		if last {
+			switch diff.Operation {
+			case OperationInsert:
+				if leg.index >= len(array) {
+					return append(array, diff.Value), nil
+				}
+				array = append(array, nil)
+				copy(array[leg.index+1:], array[leg.index:])
+				array[leg.index] = diff.Value
+				return array, nil
+			case OperationRemove:
+				if leg.index >= len(array) {
+					return nil, fmt.Errorf("json path %s: index out of range", diff.Path)
+				}
+				return append(array[:leg.index], array[leg.index+1:]...), nil
+			}
+		}
+		if leg.index >= len(array) {
+			return nil, fmt.Errorf("json path %s: index out of range", diff.Path)
+		}
+		value, err := applyDiff(array[leg.index], legs[1:], diff)
+		if err != nil {
+			return nil, err
+		}
+		array[leg.index] = value
+		return array, nil
+	}
+
+	object, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("json path %s: not an object", diff.Path)
+	}
+	if last && diff.Operation == OperationRemove {
+		delete(object, leg.key)
+		return object, nil
+	}
+	if last && diff.Operation == OperationInsert {
+		object[leg.key] = diff.Value
+		return object, nil
+	}
+	child, ok := object[leg.key]
+	if !ok {
+		return nil, fmt.Errorf("json path %s: member %s not found", diff.Path, leg.key)
+	}
+	value, err := applyDiff(child, legs[1:], diff)
+	if err != nil {
+		return nil, err
+	}
+	object[leg.key] = value
+	return object, nil
+}
+
+func readLengthEncodedInt(data []byte) (uint64, int, error) {
+	if len(data) == 0 {
+		return 0, 0, errCorrupted
+	}
+	switch data[0] {
+	case 0xfc:
+		if len(data) < 3 {
+			return 0, 0, errCorrupted
+		}
+		return uint64(binary.LittleEndian.Uint16(data[1:])), 3, nil
+	case 0xfd:
+		if len(data) < 4 {
+			return 0, 0, errCorrupted
+		}
+		return uint64(data[1]) | uint64(data[2])<<8 | uint64(data[3])<<16, 4, nil
+	case 0xfe:
+		if len(data) < 9 {
+			return 0, 0, errCorrupted
+		}
+		return binary.LittleEndian.Uint64(data[1:]), 9, nil
+	}
+	return uint64(data[0]), 1, nil
+}
@@ -0,0 +1,135 @@
+package jsondiff
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	cases := []struct {
+		path  string
+		legs  []pathLeg
+		valid bool
+	}{
+		{path: "$", legs: []pathLeg{}, valid: true},
+		{path: "$.a", legs: []pathLeg{{key: "a"}}, valid: true},
+		{path: `$."b c"[1]`, legs: []pathLeg{{key: "b c"}, {index: 1, isIndex: true}}, valid: true},
+		{path: "$.a[0].b", legs: []pathLeg{{key: "a"}, {index: 0, isIndex: true}, {key: "b"}}, valid: true},
+		{path: "$.a[-1]"},
+		{path: "$[-2]"},
+		{path: "$.a[x]"},
+		{path: "$.a[1"},
+		{path: "$."},
+		{path: "a"},
+	}
+	for _, c := range cases {
+		legs, err := parsePath(c.path)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("parsePath(%q) should fail, got %+v", c.path, legs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePath(%q) err:%s", c.path, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(legs, c.legs) {
+			t.Errorf("parsePath(%q) = %+v, want %+v", c.path, legs, c.legs)
+		}
+	}
+}
+
+func TestApplyJSON(t *testing.T) {
+	cases := []struct {
+		before string
+		diffs  []Diff
+		after  string
+	}{
+		{
+			before: `{"a":1,"b":[1,2]}`,
+			diffs:  []Diff{{Operation: OperationReplace, Path: "$.a", Value: int64(2)}},
+			after:  `{"a":2,"b":[1,2]}`,
+		},
+		{
+			before: `{"a":1,"b":[1,2]}`,
+			diffs:  []Diff{{Operation: OperationInsert, Path: "$.b[1]", Value: "x"}},
+			after:  `{"a":1,"b":[1,"x",2]}`,
+		},
+		{
+			before: `{"a":1,"b":[1,2]}`,
+			diffs: []Diff{
+				{Operation: OperationRemove, Path: "$.b[0]"},
+				{Operation: OperationInsert, Path: "$.c", Value: true},
+			},
+			after: `{"a":1,"b":[2],"c":true}`,
+		},
+		{
+			// 大整数不经 float64 转换
+			before: `{"id":9007199254740993}`,
+			diffs:  []Diff{{Operation: OperationInsert, Path: "$.n", Value: nil}},
+			after:  `{"id":9007199254740993,"n":null}`,
+		},
+	}
+	for _, c := range cases {
+		after, err := ApplyJSON([]byte(c.before), c.diffs)
+		if err != nil {
+			t.Errorf("ApplyJSON(%s) err:%s", c.before, err.Error())
+			continue
+		}
+		if string(after) != c.after {
+			t.Errorf("ApplyJSON(%s) = %s, want %s", c.before, after, c.after)
+		}
+	}
+
+	_, err := ApplyJSON([]byte(`[1]`), []Diff{{Operation: OperationReplace, Path: "$[3]", Value: int64(1)}})
+	if err == nil {
+		t.Errorf("ApplyJSON with index out of range should fail")
+	}
+}
+
+func TestPatch(t *testing.T) {
+	operations, err := Patch([]Diff{
+		{Operation: OperationReplace, Path: `$."a/b"[2]`, Value: int64(1)},
+		{Operation: OperationInsert, Path: "$.c", Value: "x"},
+		{Operation: OperationRemove, Path: "$.d"},
+	})
+	if err != nil {
+		t.Fatalf("Patch err:%s", err.Error())
+	}
+	data, _ := json.Marshal(operations)
+	want := `[{"op":"replace","path":"/a~1b/2","value":1},{"op":"add","path":"/c","value":"x"},{"op":"remove","path":"/d"}]`
+	if string(data) != want {
+		t.Errorf("Patch = %s, want %s", data, want)
+	}
+
+	if _, err := Patch([]Diff{{Operation: OperationRemove, Path: "$.a[-1]"}}); err == nil {
+		t.Errorf("Patch with negative index should fail")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	body := []byte{
+		// replace $.a 5
+		byte(OperationReplace), 3, '$', '.', 'a', 3, typeInt16, 5, 0,
+		// remove $.b
+		byte(OperationRemove), 3, '$', '.', 'b',
+	}
+	data := append([]byte{byte(len(body)), 0, 0, 0}, body...)
+	diffs, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode err:%s", err.Error())
+	}
+	want := []Diff{
+		{Operation: OperationReplace, Path: "$.a", Value: int64(5)},
+		{Operation: OperationRemove, Path: "$.b"},
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Errorf("Decode = %+v, want %+v", diffs, want)
+	}
+
+	if _, err := Decode(data[:len(data)-1]); err == nil {
+		t.Errorf("Decode truncated data should fail")
+	}
+}
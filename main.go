@@ -14,7 +14,30 @@ import (
 func main() {
 	task, err := service.NewTask(
 		service.WithCanal(global.Config.Canal),
-		service.WithBoltDB(global.Config.BoltStorage))
+		service.WithBoltDB(global.Config.BoltStorage),
+		service.WithPullAPI(global.Config.Pull),
+		service.WithSource(global.Config.Source),
+		service.WithEncoder(global.Config.Encoder),
+		service.WithRules(global.Config.Rules),
+		service.WithSink(global.Config.Kafka),
+		service.WithSink(global.Config.Redis),
+		service.WithSink(global.Config.Invalidator),
+		service.WithSink(global.Config.Elasticsearch),
+		service.WithSink(global.Config.Webhook),
+		service.WithSink(global.Config.AMQP),
+		service.WithSink(global.Config.NATS),
+		service.WithSink(global.Config.Applier),
+		service.WithSink(global.Config.Postgres),
+		service.WithSink(global.Config.ClickHouse),
+		service.WithSink(global.Config.MongoDB),
+		service.WithSink(global.Config.File),
+		service.WithSink(global.Config.Parquet),
+		service.WithSink(global.Config.Archive),
+		service.WithFeed(global.Config.Feed),
+		service.WithSink(global.Config.Live),
+		service.WithSink(global.Config.Search),
+		service.WithSink(global.Config.SQLite),
+		service.WithAssembler(global.Config.Assembler))
 	if err != nil {
 		panic(err)
 	}
@@ -40,5 +63,6 @@ func setupSignalHandler(services []service.Service) {
 	for _, service := range services {
 		service.Stop()
 	}
+	global.Config.HTTP.Close()
 	os.Exit(0)
 }
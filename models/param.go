@@ -1,16 +1,111 @@
 package models
 
+import (
+	"strconv"
+	"strings"
+
+	"github.com/JieWaZi/transfer-mysql/jsondiff"
+)
+
 type RowRequest struct {
-	Schema    string
-	Name      string
-	Action    string
-	OldRows   []interface{}
-	NewRows   []interface{}
-	Timestamp uint32
+	Schema      string
+	Name        string
+	Action      string
+	Columns     []Column
+	PrimaryKeys []string
+	OldRows     []interface{}
+	NewRows     []interface{}
+	// 通过回源查询补全的字段
+	EnrichedColumns []string `json:",omitempty"`
+	// binlog 行镜像中缺少且未能补全的字段，值为 nil 但并非 NULL
+	MissingColumns []string `json:",omitempty"`
+	// PARTIAL_UPDATE_ROWS_EVENT 中无法还原完整文档的 JSON 字段，以 JSON Patch 表示修改
+	JSONPatches map[string][]jsondiff.PatchOperation `json:",omitempty"`
+	// update 时发生变化的字段
+	ChangedColumns []string `json:",omitempty"`
+	Timestamp      uint32
+	// 所在 binlog 文件、事件结束位置及在事件中的行序号，三者唯一确定一行变更
+	LogName  string `json:",omitempty"`
+	LogPos   uint32 `json:",omitempty"`
+	RowIndex int    `json:",omitempty"`
+	// 所在事务的起始位置，同一事务内的行相同
+	TxnPos uint32 `json:",omitempty"`
+	// 在 RowRequest 队列中的序号，由 consumer 读取时设置，不写入队列
+	Seq uint64 `json:"-"`
+}
+
+func (r *RowRequest) ColumnIndex(name string) int {
+	for i := range r.Columns {
+		if r.Columns[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Row delete 时返回修改前的行，否则返回修改后的行
+func (r *RowRequest) Row() []interface{} {
+	if r.NewRows != nil {
+		return r.NewRows
+	}
+	return r.OldRows
+}
+
+func (r *RowRequest) Value(column string) interface{} {
+	return valueAt(r.Row(), r.ColumnIndex(column))
+}
+
+func (r *RowRequest) OldValue(column string) interface{} {
+	return valueAt(r.OldRows, r.ColumnIndex(column))
+}
+
+func (r *RowRequest) NewValue(column string) interface{} {
+	return valueAt(r.NewRows, r.ColumnIndex(column))
+}
+
+func (r *RowRequest) PrimaryKeyValues() []interface{} {
+	values := make([]interface{}, len(r.PrimaryKeys))
+	for i := range r.PrimaryKeys {
+		values[i] = r.Value(r.PrimaryKeys[i])
+	}
+	return values
+}
+
+// Position 返回 binlog 位置标识，格式为 file:pos:index，可用于下游去重
+func (r *RowRequest) Position() string {
+	return r.LogName + ":" + strconv.FormatUint(uint64(r.LogPos), 10) + ":" + strconv.Itoa(r.RowIndex)
+}
+
+// Transaction 返回所在事务的标识，格式为 file:pos
+func (r *RowRequest) Transaction() string {
+	return r.LogName + ":" + strconv.FormatUint(uint64(r.TxnPos), 10)
+}
+
+// Version 由 binlog 文件序号、位置与行序号组成的单调递增版本号，
+// 文件序号占高 20 位，位置占中间 32 位，行序号占低 12 位
+func (r *RowRequest) Version() uint64 {
+	var seq uint64
+	if i := strings.LastIndex(r.LogName, "."); i >= 0 {
+		seq, _ = strconv.ParseUint(r.LogName[i+1:], 10, 64)
+	}
+	return seq<<44 | uint64(r.LogPos)<<12 | uint64(r.RowIndex&0xfff)
+}
+
+func valueAt(row []interface{}, index int) interface{} {
+	if index < 0 || index >= len(row) {
+		return nil
+	}
+	return row[index]
+}
+
+type Column struct {
+	Name    string
+	Type    string
+	RawType string
 }
 
 type PosRequest struct {
 	Name  string
 	Pos   uint32
 	Force bool
-}
\ No newline at end of file
+}
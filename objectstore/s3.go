@@ -0,0 +1,359 @@
+package objectstore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	amzDateFormat   = "20060102T150405Z"
+	minPartSize     = 5 << 20
+	defaultPartSize = 16 << 20
+)
+
+// S3 兼容 S3 协议的对象存储客户端，使用 SigV4 签名，支持 AWS S3、MinIO 等
+type S3 struct {
+	// 如 https://s3.us-east-1.amazonaws.com、http://127.0.0.1:9000
+	Endpoint     string `env:""`
+	Region       string `env:""`
+	Bucket       string `env:""`
+	AccessKey    string `env:""`
+	SecretKey    string `env:""`
+	SessionToken string `env:""`
+	// 为 true 时使用 bucket.endpoint 形式的地址，否则使用 endpoint/bucket
+	VirtualHost bool `env:""`
+	// 超过该大小的文件使用分片上传，单位 MB
+	PartSizeMB int `env:""`
+	TimeoutMs  int `env:""`
+
+	client *http.Client
+}
+
+type Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type errorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (s *S3) SetDefaults() {
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.PartSizeMB == 0 {
+		s.PartSizeMB = defaultPartSize >> 20
+	}
+	if s.TimeoutMs == 0 {
+		s.TimeoutMs = 300000
+	}
+}
+
+func (s *S3) Init() error {
+	if s.client != nil {
+		return nil
+	}
+	if s.Endpoint == "" || s.Bucket == "" {
+		return errors.New("s3 endpoint and bucket are required")
+	}
+	if int64(s.PartSizeMB)<<20 < minPartSize {
+		return fmt.Errorf("s3 part size must be at least %dMB", minPartSize>>20)
+	}
+	s.client = &http.Client{Timeout: time.Duration(s.TimeoutMs) * time.Millisecond}
+	return nil
+}
+
+// PutObject 单次上传，headers 可包含 Content-Type、x-amz-server-side-encryption 等
+func (s *S3) PutObject(key string, body []byte, headers http.Header) error {
+	resp, err := s.do(http.MethodPut, key, nil, headers, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// UploadFile 上传本地文件，见 Upload
+func (s *S3) UploadFile(key, path string, headers http.Header) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return s.Upload(key, f, info.Size(), headers)
+}
+
+// Upload 大于分片大小时使用分片上传，失败时中止上传
+func (s *S3) Upload(key string, r io.Reader, size int64, headers http.Header) error {
+	partSize := int64(s.PartSizeMB) << 20
+	if size <= partSize {
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return s.PutObject(key, body, headers)
+	}
+
+	uploadID, err := s.CreateMultipartUpload(key, headers)
+	if err != nil {
+		return err
+	}
+	parts := make([]Part, 0, size/partSize+1)
+	buf := make([]byte, partSize)
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			s.AbortMultipartUpload(key, uploadID)
+			return err
+		}
+		etag, err := s.UploadPart(key, uploadID, number, buf[:n])
+		if err != nil {
+			s.AbortMultipartUpload(key, uploadID)
+			return err
+		}
+		parts = append(parts, Part{PartNumber: number, ETag: etag})
+	}
+	if err := s.CompleteMultipartUpload(key, uploadID, parts); err != nil {
+		s.AbortMultipartUpload(key, uploadID)
+		return err
+	}
+	return nil
+}
+
+func (s *S3) CreateMultipartUpload(key string, headers http.Header) (string, error) {
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploads": {""}}, headers, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.UploadID, nil
+}
+
+func (s *S3) UploadPart(key, uploadID string, number int, body []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	resp, err := s.do(http.MethodPut, key, query, nil, body)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+func (s *S3) CompleteMultipartUpload(key, uploadID string, parts []Part) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []Part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// 完成分片上传时可能返回 200 但响应体为错误
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		var e errorResponse
+		xml.Unmarshal(data, &e)
+		return fmt.Errorf("s3 complete multipart upload %s: %s %s", key, e.Code, e.Message)
+	}
+	return nil
+}
+
+func (s *S3) AbortMultipartUpload(key, uploadID string) error {
+	resp, err := s.do(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do 发送签名请求，非 2xx 时返回错误
+func (s *S3) do(method, key string, query url.Values, headers http.Header, body []byte) (*http.Response, error) {
+	req, err := s.newRequest(method, key, query, headers, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		var e errorResponse
+		xml.Unmarshal(data, &e)
+		return nil, fmt.Errorf("s3 %s %s status %d: %s %s", method, key, resp.StatusCode, e.Code, e.Message)
+	}
+	return resp, nil
+}
+
+func (s *S3) newRequest(method, key string, query url.Values, headers http.Header, body []byte) (*http.Request, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u := *endpoint
+	path := "/" + strings.TrimPrefix(key, "/")
+	if s.VirtualHost {
+		u.Host = s.Bucket + "." + endpoint.Host
+	} else {
+		path = "/" + s.Bucket + path
+	}
+	u.Path = path
+	u.RawPath = encodePath(path)
+	u.RawQuery = encodeQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range headers {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	s.sign(req, body, time.Now())
+	return req, nil
+}
+
+// sign AWS Signature Version 4，签名请求中已设置的全部请求头
+func (s *S3) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	date := amzDate[:8]
+	payloadHash := hashHex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	names := []string{"host"}
+	canonical := map[string]string{"host": req.URL.Host}
+	for k, values := range req.Header {
+		name := strings.ToLower(k)
+		if name == "authorization" {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i := range values {
+			trimmed[i] = strings.Join(strings.Fields(values[i]), " ")
+		}
+		names = append(names, name)
+		canonical[name] = strings.Join(trimmed, ",")
+	}
+	sort.Strings(names)
+	var headerLines strings.Builder
+	for _, name := range names {
+		headerLines.WriteString(name + ":" + canonical[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headerLines.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// encodePath 按 SigV4 规则编码路径，保留 /
+func encodePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i := range segments {
+		segments[i] = uriEncode(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+func encodeQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,265 @@
+package pull
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/JieWaZi/transfer-mysql/httpserver"
+	"github.com/JieWaZi/transfer-mysql/storage"
+	"github.com/JieWaZi/transfer-mysql/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// API 基于 RowRequest 队列的拉取消费接口，需开启 HTTP 服务：
+//
+//	GET    {Path}                      列出消费方及游标
+//	POST   {Path}/{name}?from=latest   注册消费方，from 为 earliest(默认)/latest
+//	DELETE {Path}/{name}               注销消费方
+//	GET    {Path}/{name}/fetch         拉取游标之后的数据，可指定 after、limit
+//	POST   {Path}/{name}/commit        提交游标 {"Seq":n}，只能前进
+//	POST   {Path}/{name}/rewind        回退游标 {"Seq":n}，不能早于队列中最早的数据
+//
+// 注册后队列中的数据在该消费方提交之前不会被删除
+type API struct {
+	Enabled bool   `env:""`
+	Path    string `env:""`
+	// 单次拉取的最大条数
+	MaxBatchSize int `env:""`
+
+	boltStorage *storage.BoltStorage
+}
+
+type Consumer struct {
+	Name   string
+	Cursor uint64
+}
+
+type Event struct {
+	Seq     uint64
+	Request json.RawMessage
+}
+
+type FetchResponse struct {
+	Events []Event
+	// 下一次拉取的 after，没有新数据时与本次相同
+	Next uint64
+}
+
+type CursorRequest struct {
+	Seq uint64
+}
+
+func (a *API) SetDefaults() {
+	if a.Path == "" {
+		a.Path = "/consumers"
+	}
+	if a.MaxBatchSize == 0 {
+		a.MaxBatchSize = 1000
+	}
+}
+
+func (a *API) IsEnabled() bool {
+	return a != nil && a.Enabled
+}
+
+// Bind 绑定任务使用的队列并注册路由
+func (a *API) Bind(boltStorage *storage.BoltStorage) {
+	a.boltStorage = boltStorage
+	path := strings.TrimSuffix(a.Path, "/")
+	httpserver.HandleFunc(path, a.list)
+	httpserver.HandleFunc(path+"/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, path), "/"), "/")
+		name := parts[0]
+		if name == "" || strings.HasPrefix(name, "_") {
+			writeError(w, http.StatusBadRequest, "invalid consumer name")
+			return
+		}
+		action := ""
+		if len(parts) > 1 {
+			action = parts[1]
+		}
+		switch {
+		case action == "" && r.Method == http.MethodPost:
+			a.register(w, r, name)
+		case action == "" && r.Method == http.MethodDelete:
+			a.unregister(w, name)
+		case action == "fetch" && r.Method == http.MethodGet:
+			a.fetch(w, r, name)
+		case action == "commit" && r.Method == http.MethodPost:
+			a.move(w, r, name, false)
+		case action == "rewind" && r.Method == http.MethodPost:
+			a.move(w, r, name, true)
+		default:
+			writeError(w, http.StatusNotFound, "not found")
+		}
+	})
+}
+
+func (a *API) list(w http.ResponseWriter, r *http.Request) {
+	cursors, err := a.boltStorage.ListCursors()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	consumers := make([]Consumer, 0, len(cursors))
+	for name, cursor := range cursors {
+		if !strings.HasPrefix(name, "_") {
+			consumers = append(consumers, Consumer{Name: name, Cursor: cursor})
+		}
+	}
+	sort.Slice(consumers, func(i, j int) bool { return consumers[i].Name < consumers[j].Name })
+	writeJSON(w, http.StatusOK, consumers)
+}
+
+// register 已注册时返回当前游标
+func (a *API) register(w http.ResponseWriter, r *http.Request, name string) {
+	cursor, ok, err := a.boltStorage.GetCursor(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if ok {
+		writeJSON(w, http.StatusOK, Consumer{Name: name, Cursor: cursor})
+		return
+	}
+	switch r.URL.Query().Get("from") {
+	case "", "earliest":
+		first, err := a.boltStorage.FirstRowRequestSeq()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if first > 0 {
+			cursor = first - 1
+		} else {
+			// 队列为空时从下一条开始
+			cursor, err = a.boltStorage.RowRequestSequence()
+		}
+	case "latest":
+		cursor, err = a.boltStorage.RowRequestSequence()
+	default:
+		writeError(w, http.StatusBadRequest, "from must be earliest or latest")
+		return
+	}
+	if err == nil {
+		err = a.boltStorage.SetCursor(name, cursor)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logrus.Infof("pull consumer %s registered cursor:%d", name, cursor)
+	writeJSON(w, http.StatusCreated, Consumer{Name: name, Cursor: cursor})
+}
+
+func (a *API) unregister(w http.ResponseWriter, name string) {
+	if err := a.boltStorage.DeleteCursor(name); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	logrus.Infof("pull consumer %s unregistered", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// fetch 不移动游标，默认从已提交的游标之后开始，可通过 after 在提交前继续拉取
+func (a *API) fetch(w http.ResponseWriter, r *http.Request, name string) {
+	cursor, ok, err := a.boltStorage.GetCursor(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "consumer not registered")
+		return
+	}
+	query := r.URL.Query()
+	after := cursor
+	if v := query.Get("after"); v != "" {
+		if after, err = strconv.ParseUint(v, 10, 64); err != nil || after < cursor {
+			writeError(w, http.StatusBadRequest, "after must not be less than the committed cursor")
+			return
+		}
+	}
+	limit := a.MaxBatchSize
+	if v := query.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n < limit {
+			limit = n
+		}
+	}
+
+	keys, values, err := a.boltStorage.ListRowRequest(utils.Uint64ToBytes(after), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp := FetchResponse{Events: make([]Event, 0, len(keys)), Next: after}
+	for i := range keys {
+		seq := utils.BytesToUint64(keys[i])
+		resp.Events = append(resp.Events, Event{Seq: seq, Request: values[i]})
+		resp.Next = seq
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// move 校验与保存游标在同一个事务中完成，避免并发提交时游标回退
+func (a *API) move(w http.ResponseWriter, r *http.Request, name string, rewind bool) {
+	var req CursorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var conflict error
+	err := a.boltStorage.MoveCursor(name, req.Seq, func(cursor, first, last uint64) error {
+		conflict = check(cursor, first, last, req.Seq, rewind)
+		return conflict
+	})
+	switch {
+	case err == storage.ErrCursorNotFound:
+		writeError(w, http.StatusNotFound, "consumer not registered")
+	case err != nil && err == conflict:
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, Consumer{Name: name, Cursor: req.Seq})
+	}
+}
+
+// check 提交不能超过队列最后的序号，回退不能早于队列中最早的数据
+func check(cursor, first, last, seq uint64, rewind bool) error {
+	if !rewind {
+		if seq < cursor {
+			return errors.New("commit can not move cursor backwards, use rewind")
+		}
+		if seq > last {
+			return errors.New("commit beyond the last sequence")
+		}
+		return nil
+	}
+	if seq == cursor {
+		return nil
+	}
+	if seq > cursor {
+		return errors.New("rewind can not move cursor forwards, use commit")
+	}
+	if first == 0 || seq+1 < first {
+		return errors.New("rewind before the earliest retained sequence")
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Warnf("pull write response err:%s", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,94 @@
+package pull
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/JieWaZi/transfer-mysql/storage"
+)
+
+func newTestAPI(t *testing.T, events int) *API {
+	b := &storage.BoltStorage{BoltStoragePath: t.TempDir()}
+	b.SetDefaults()
+	if err := b.Init(); err != nil {
+		t.Fatalf("bolt init err:%s", err.Error())
+	}
+	t.Cleanup(func() { b.GetBoltStorage().Close() })
+	if err := b.CreateBucketIfNotExists([]byte(b.RowRequestBucket), []byte(b.CursorBucket)); err != nil {
+		t.Fatalf("create bucket err:%s", err.Error())
+	}
+	list := make([][]byte, events)
+	for i := range list {
+		list[i] = []byte("{}")
+	}
+	if err := b.BatchAddRowRequest(list); err != nil {
+		t.Fatalf("add RowRequest err:%s", err.Error())
+	}
+	a := &API{Enabled: true}
+	a.SetDefaults()
+	a.boltStorage = b
+	return a
+}
+
+func post(a *API, name, body string, rewind bool) int {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	a.move(w, r, name, rewind)
+	return w.Code
+}
+
+func TestMove(t *testing.T) {
+	a := newTestAPI(t, 5)
+	// 另一个消费方保留序号 1 之后的数据
+	if err := a.boltStorage.SetCursor("other", 1); err != nil {
+		t.Fatalf("set cursor err:%s", err.Error())
+	}
+	if err := a.boltStorage.SetCursor("app", 2); err != nil {
+		t.Fatalf("set cursor err:%s", err.Error())
+	}
+	tests := []struct {
+		name   string
+		seq    string
+		rewind bool
+		want   int
+	}{
+		{name: "commit", seq: "4", want: http.StatusOK},
+		{name: "commit backwards", seq: "3", want: http.StatusConflict},
+		{name: "commit beyond last", seq: "6", want: http.StatusConflict},
+		{name: "rewind forwards", seq: "5", rewind: true, want: http.StatusConflict},
+		{name: "rewind before earliest", seq: "0", rewind: true, want: http.StatusConflict},
+		{name: "rewind", seq: "1", rewind: true, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := post(a, "app", `{"Seq":`+tt.seq+`}`, tt.rewind); code != tt.want {
+			t.Errorf("%s status = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+	if code := post(a, "unknown", `{"Seq":1}`, false); code != http.StatusNotFound {
+		t.Errorf("unknown consumer status = %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+// TestConcurrentCommit 并发提交时游标不会回退
+func TestConcurrentCommit(t *testing.T) {
+	a := newTestAPI(t, 100)
+	if err := a.boltStorage.SetCursor("app", 0); err != nil {
+		t.Fatalf("set cursor err:%s", err.Error())
+	}
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(seq int) {
+			defer wg.Done()
+			post(a, "app", `{"Seq":`+strconv.Itoa(seq)+`}`, false)
+		}(i)
+	}
+	wg.Wait()
+	if cursor, _, _ := a.boltStorage.GetCursor("app"); cursor != 100 {
+		t.Errorf("cursor = %d, want 100", cursor)
+	}
+}
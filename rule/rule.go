@@ -0,0 +1,209 @@
+package rule
+
+import (
+	"strings"
+)
+
+// Rule 按库表配置的同步规则，Schema/Table 支持 * 通配
+type Rule struct {
+	Schema string `env:""`
+	Table  string `env:""`
+
+	// 空间字段输出格式，格式为 column:format，format 可选 geojson/wkt/wkb
+	GeometryColumns []string `env:""`
+
+	// update 只输出变化的字段及主键，仅对按事件输出的 sink 生效，
+	// 即 kafka/webhook/amqp/nats/file/archive/parquet/feed 及 redis list/stream 模式
+	OnlyChangedColumns bool `env:""`
+	// update 仅这些字段变化时丢弃，如 updated_at
+	IgnoreColumns []string `env:""`
+
+	// kafka topic 模板，如 {{schema}}.{{table}}
+	KafkaTopic string `env:""`
+
+	// redis 写入方式 hash/string/zset/list/stream 及 key 模板
+	RedisMode        string `env:""`
+	RedisKey         string `env:""`
+	RedisScoreColumn string `env:""`
+	RedisTTLSeconds  int    `env:""`
+
+	// 需要失效的缓存 key 模板，如 user:{{id}}、user:email:{{before.email}}
+	InvalidateKeys []string `env:""`
+
+	// elasticsearch 索引与文档 ID 模板，文档 ID 默认取主键
+	ESIndex      string `env:""`
+	ESIDTemplate string `env:""`
+	// 字段映射，格式为 column:field，field 为 - 时不写入
+	ESFieldMappings []string `env:""`
+	// 创建索引时使用的 mappings JSON
+	ESMapping string `env:""`
+
+	// webhook 回调地址
+	WebhookURLs []string `env:""`
+
+	// amqp exchange 与 routing key 模板
+	AMQPExchange   string `env:""`
+	AMQPRoutingKey string `env:""`
+
+	// nats subject 模板，如 cdc.{{schema}}.{{table}}.{{action}}
+	NATSSubject string `env:""`
+
+	// 回放到目标 MySQL 的表名模板，如 archive.{{table}}
+	ApplyTable string `env:""`
+	// 字段重命名，格式为 column:target，target 为 - 时不写入
+	ApplyColumnMappings []string `env:""`
+
+	// postgres 目标表模板，如 public.{{table}}
+	PGTable string `env:""`
+
+	// clickhouse 目标表模板，如 analytics.{{table}}
+	ClickHouseTable string `env:""`
+
+	// mongodb 库与集合模板，_id 模板默认取主键
+	MongoDatabase   string `env:""`
+	MongoCollection string `env:""`
+	MongoIDTemplate string `env:""`
+	// 字段映射，格式为 column:path，path 可用 . 表示嵌套字段，为 - 时不写入
+	MongoFieldMappings []string `env:""`
+	// 子表嵌入父文档数组，格式为 collection:parentKeys:field[:table]，如 orders:order_id=id:items，
+	// parentKeys 对应父表主键，父文档 _id 按父表 table(默认与集合同名)的规则生成
+	MongoEmbed string `env:""`
+
+	// 本地全文索引的字段映射，格式为 column:field，field 为 - 时不写入
+	SearchFieldMappings []string `env:""`
+	// 字段类型，格式为 column:type，type 可选 text/keyword/numeric/datetime/boolean，默认按字段类型推断
+	SearchFieldTypes []string `env:""`
+	// 文本字段的分词器，格式为 column:analyzer，如 title:en，默认 standard
+	SearchAnalyzers []string `env:""`
+	// 需要存储原文以在搜索结果中返回的字段，为空时全部存储
+	SearchStoreColumns []string `env:""`
+}
+
+func (r *Rule) Match(schema, table string) bool {
+	return matchName(r.Schema, schema) && matchName(r.Table, table)
+}
+
+func (r *Rule) GeometryFormat(column string) string {
+	if r == nil {
+		return ""
+	}
+	for _, item := range r.GeometryColumns {
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) == 2 && kv[0] == column {
+			return strings.ToLower(strings.TrimSpace(kv[1]))
+		}
+	}
+	return ""
+}
+
+func (r *Rule) ESField(column string) string {
+	if r == nil {
+		return column
+	}
+	return mapping(r.ESFieldMappings, column)
+}
+
+func (r *Rule) MongoField(column string) string {
+	if r == nil {
+		return column
+	}
+	return mapping(r.MongoFieldMappings, column)
+}
+
+func (r *Rule) SearchField(column string) string {
+	if r == nil {
+		return column
+	}
+	return mapping(r.SearchFieldMappings, column)
+}
+
+func (r *Rule) SearchFieldType(column string) string {
+	if r == nil {
+		return ""
+	}
+	return strings.ToLower(lookup(r.SearchFieldTypes, column))
+}
+
+func (r *Rule) SearchAnalyzer(column string) string {
+	if r == nil {
+		return ""
+	}
+	return lookup(r.SearchAnalyzers, column)
+}
+
+func (r *Rule) SearchStore(column string) bool {
+	return r == nil || len(r.SearchStoreColumns) == 0 || contains(r.SearchStoreColumns, column)
+}
+
+func (r *Rule) ApplyColumn(column string) string {
+	if r == nil {
+		return column
+	}
+	return mapping(r.ApplyColumnMappings, column)
+}
+
+// IsIgnoredUpdate 变化的字段全部在 IgnoreColumns 中时返回 true
+func (r *Rule) IsIgnoredUpdate(changedColumns []string) bool {
+	if r == nil || len(r.IgnoreColumns) == 0 {
+		return false
+	}
+	for _, column := range changedColumns {
+		if !contains(r.IgnoreColumns, column) {
+			return false
+		}
+	}
+	return true
+}
+
+type Rules []Rule
+
+// Find 返回第一条匹配的规则，没有匹配时返回 nil
+func (rs Rules) Find(schema, table string) *Rule {
+	for i := range rs {
+		if rs[i].Match(schema, table) {
+			return &rs[i]
+		}
+	}
+	return nil
+}
+
+func matchName(pattern, name string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(name, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == name
+}
+
+// mapping 按 column:target 格式查找映射，未配置时返回原字段名
+func mapping(list []string, column string) string {
+	for _, item := range list {
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) == 2 && kv[0] == column {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return column
+}
+
+// lookup 与 mapping 相同，未配置时返回空字符串
+func lookup(list []string, column string) string {
+	for _, item := range list {
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) == 2 && kv[0] == column {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/JieWaZi/transfer-mysql/storage"
+	"github.com/JieWaZi/transfer-mysql/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// PushCursor consumer 在游标中使用的名称前缀，每个 sink 使用独立的游标，拉取方不能使用 _ 开头的名称
+const PushCursor = "_push"
+
+// consumer 从 RowRequest 队列读取数据写入各个 sink，每个 sink 独立消费并提交游标，
+// 所有 sink 及拉取方都提交之后记录才会被删除
+type consumer struct {
+	boltStorage *storage.BoltStorage
+	sinks       []sink.Sink
+	rules       rule.Rules
+	ctx         context.Context
+	batchSize   int
+	// 单个 sink 写入一批数据的最大尝试次数，0 表示一直重试
+	maxAttempts int
+}
+
+// deadLetter 多次写入失败后跳过的记录
+type deadLetter struct {
+	Sink      string
+	Seq       uint64
+	Error     string
+	Timestamp int64
+	Request   *models.RowRequest
+}
+
+func (c *consumer) start() {
+	if err := c.deleteStaleCursors(); err != nil {
+		logrus.Errorf("delete stale push cursors err:%s", err.Error())
+		return
+	}
+	workers := make([]*sinkConsumer, 0, len(c.sinks))
+	for _, s := range c.sinks {
+		w := &sinkConsumer{consumer: c, sink: s, cursor: pushCursor(s)}
+		if err := w.init(); err != nil {
+			logrus.Errorf("init %s cursor err:%s", w.cursor, err.Error())
+			return
+		}
+		workers = append(workers, w)
+	}
+	for _, w := range workers {
+		go w.run()
+	}
+}
+
+func pushCursor(s sink.Sink) string {
+	return PushCursor + "_" + s.String()
+}
+
+// deleteStaleCursors 删除已不再启用的 sink 的游标，否则其游标之后的数据永远不会被删除
+func (c *consumer) deleteStaleCursors() error {
+	enabled := make(map[string]bool, len(c.sinks))
+	for _, s := range c.sinks {
+		enabled[pushCursor(s)] = true
+	}
+	cursors, err := c.boltStorage.ListCursors()
+	if err != nil {
+		return err
+	}
+	for name := range cursors {
+		if !strings.HasPrefix(name, PushCursor+"_") || enabled[name] {
+			continue
+		}
+		if err := c.boltStorage.DeleteCursor(name); err != nil {
+			return err
+		}
+		logrus.Infof("delete push cursor %s of disabled sink", name)
+	}
+	return nil
+}
+
+// sinkConsumer 单个 sink 的消费进度，写入失败不影响其他 sink
+type sinkConsumer struct {
+	*consumer
+	sink   sink.Sink
+	cursor string
+	// 已写入 sink 的最后一个 key
+	after []byte
+}
+
+// init 读取游标，没有游标时从队列中最早的数据开始并立即注册游标，避免数据被其他消费方提交后删除
+func (w *sinkConsumer) init() error {
+	seq, ok, err := w.boltStorage.GetCursor(w.cursor)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		first, err := w.boltStorage.FirstRowRequestSeq()
+		if err != nil {
+			return err
+		}
+		if first > 0 {
+			seq = first - 1
+		} else if seq, err = w.boltStorage.RowRequestSequence(); err != nil {
+			return err
+		}
+		if err := w.boltStorage.SetCursor(w.cursor, seq); err != nil {
+			return err
+		}
+	}
+	if seq > 0 {
+		w.after = utils.Uint64ToBytes(seq)
+	}
+	return nil
+}
+
+func (w *sinkConsumer) run() {
+	ticker := time.NewTicker(time.Millisecond * 100)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for w.consume() {
+			}
+		case <-w.ctx.Done():
+			logrus.Infof("consumer %s ctx cancel", w.sink.String())
+			return
+		}
+	}
+}
+
+// consume 处理一批数据，队列中可能还有数据时返回 true
+func (w *sinkConsumer) consume() bool {
+	keys, values, err := w.boltStorage.ListRowRequest(w.after, w.batchSize)
+	if err != nil {
+		logrus.Errorf("list RowRequest err:%s", err.Error())
+		return false
+	}
+	if len(keys) == 0 {
+		return false
+	}
+
+	requests := make([]*models.RowRequest, 0, len(values))
+	indexes := make([]int, 0, len(values))
+	for i := range values {
+		req, err := decodeRowRequest(values[i])
+		if err != nil {
+			logrus.Errorf("unmarshal RowRequest err:%s", err.Error())
+			continue
+		}
+		req.Seq = utils.BytesToUint64(keys[i])
+		requests = append(requests, req)
+		indexes = append(indexes, i)
+	}
+
+	// 批次已满时最后一个事务可能不完整，留到下一批处理，除非整批只有一个事务
+	more := len(keys) >= w.batchSize
+	if more && len(requests) > 0 {
+		last := requests[len(requests)-1].Transaction()
+		cut := len(requests)
+		for cut > 0 && requests[cut-1].Transaction() == last {
+			cut--
+		}
+		if cut > 0 {
+			keys = keys[:indexes[cut]]
+			requests = requests[:cut]
+		}
+	}
+
+	requests = sink.OnlyChangedColumns(w.sink, w.rules, requests)
+	if len(requests) > 0 && !w.writeWithRetry(requests) && !w.writeEach(requests) {
+		return false
+	}
+
+	last := keys[len(keys)-1]
+	if err := w.boltStorage.SetCursor(w.cursor, utils.BytesToUint64(last)); err != nil {
+		logrus.Errorf("commit %s cursor err:%s", w.cursor, err.Error())
+		return false
+	}
+	w.after = last
+	return more
+}
+
+// writeWithRetry 超过最大尝试次数、返回 PermanentError 或 ctx 取消时返回 false
+func (w *sinkConsumer) writeWithRetry(requests []*models.RowRequest) bool {
+	backoff := time.Millisecond * 100
+	for attempt := 1; ; attempt++ {
+		err := w.sink.Write(w.rules, requests)
+		if err == nil {
+			return true
+		}
+		logrus.Errorf("sink %s write err:%s, attempt:%d", w.sink.String(), err.Error(), attempt)
+		if sink.IsPermanent(err) || (w.maxAttempts > 0 && attempt >= w.maxAttempts) {
+			return false
+		}
+		select {
+		case <-time.After(backoff):
+		case <-w.ctx.Done():
+			return false
+		}
+		if backoff < time.Second*30 {
+			backoff *= 2
+		}
+	}
+}
+
+// writeEach 整批写入失败后逐条写入，仍失败的记录转入死信队列后跳过，ctx 取消时返回 false
+func (w *sinkConsumer) writeEach(requests []*models.RowRequest) bool {
+	for _, req := range requests {
+		if w.ctx.Err() != nil {
+			return false
+		}
+		err := w.sink.Write(w.rules, []*models.RowRequest{req})
+		if err == nil {
+			continue
+		}
+		logrus.Errorf("sink %s skip RowRequest seq:%d err:%s", w.sink.String(), req.Seq, err.Error())
+		data, err := json.Marshal(deadLetter{
+			Sink:      w.sink.String(),
+			Seq:       req.Seq,
+			Error:     err.Error(),
+			Timestamp: time.Now().Unix(),
+			Request:   req,
+		})
+		if err == nil {
+			err = w.boltStorage.AddDeadLetter(data)
+		}
+		if err != nil {
+			logrus.Errorf("add dead letter err:%s", err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+func decodeRowRequest(data []byte) (*models.RowRequest, error) {
+	var req models.RowRequest
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// 避免大整数被解析为 float64 丢失精度
+	decoder.UseNumber()
+	if err := decoder.Decode(&req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
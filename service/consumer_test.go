@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/JieWaZi/transfer-mysql/storage"
+)
+
+type testSink struct {
+	name string
+}
+
+func (s *testSink) String() string  { return s.name }
+func (s *testSink) IsEnabled() bool { return true }
+func (s *testSink) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	return nil
+}
+func (s *testSink) Close() {}
+
+// TestDeleteStaleCursors 停用的 sink 的游标在启动时删除，之前的数据随之清理
+func TestDeleteStaleCursors(t *testing.T) {
+	b := &storage.BoltStorage{BoltStoragePath: t.TempDir()}
+	b.SetDefaults()
+	if err := b.Init(); err != nil {
+		t.Fatalf("bolt init err:%s", err.Error())
+	}
+	t.Cleanup(func() { b.GetBoltStorage().Close() })
+	if err := b.CreateBucketIfNotExists([]byte(b.RowRequestBucket), []byte(b.CursorBucket)); err != nil {
+		t.Fatalf("create bucket err:%s", err.Error())
+	}
+	if err := b.BatchAddRowRequest([][]byte{[]byte("{}"), []byte("{}"), []byte("{}")}); err != nil {
+		t.Fatalf("add RowRequest err:%s", err.Error())
+	}
+	// 按序号从小到大设置，避免设置时提前清理数据
+	cursors := []struct {
+		name string
+		seq  uint64
+	}{{"_push_webhook", 0}, {"_feed", 1}, {"_push_kafka", 2}, {"app", 2}}
+	for _, cursor := range cursors {
+		if err := b.SetCursor(cursor.name, cursor.seq); err != nil {
+			t.Fatalf("set cursor err:%s", err.Error())
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := &consumer{boltStorage: b, sinks: []sink.Sink{&testSink{name: "kafka"}}, ctx: ctx, batchSize: 10}
+	c.start()
+
+	got, err := b.ListCursors()
+	if err != nil {
+		t.Fatalf("list cursors err:%s", err.Error())
+	}
+	if want := map[string]uint64{"_push_kafka": 2, "_feed": 1, "app": 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cursors = %v, want %v", got, want)
+	}
+	if first, _ := b.FirstRowRequestSeq(); first != 2 {
+		t.Errorf("first seq = %d, want 2", first)
+	}
+}
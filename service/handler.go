@@ -3,12 +3,17 @@ package service
 import (
 	"context"
 	"encoding/json"
+	transfercanal "github.com/JieWaZi/transfer-mysql/canal"
+	"github.com/JieWaZi/transfer-mysql/encoder"
 	"github.com/JieWaZi/transfer-mysql/global"
 	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/source"
 	"github.com/JieWaZi/transfer-mysql/storage"
 	"github.com/siddontang/go-mysql/canal"
 	"github.com/siddontang/go-mysql/mysql"
 	"github.com/siddontang/go-mysql/replication"
+	"github.com/siddontang/go-mysql/schema"
 	"github.com/sirupsen/logrus"
 	"go.uber.org/atomic"
 	"time"
@@ -16,6 +21,9 @@ import (
 
 type handler struct {
 	boltStorage *storage.BoltStorage
+	source      *source.Source
+	encoder     *encoder.Encoder
+	rules       rule.Rules
 	ctx         context.Context
 	cancelFunc  context.CancelFunc
 	requestChan chan interface{}
@@ -45,6 +53,9 @@ func (h *handler) OnDDL(nextPos mysql.Position, queryEvent *replication.QueryEve
 	return nil
 }
 func (h *handler) OnRow(rowEvent *canal.RowsEvent) error {
+	return h.OnRowsEvent(&transfercanal.RowsEvent{RowsEvent: rowEvent})
+}
+func (h *handler) OnRowsEvent(rowEvent *transfercanal.RowsEvent) error {
 	logrus.Infof("[OnRow] table:%s,action:%s,rows:%+v,logPos:%d", rowEvent.Table.Name, rowEvent.Action, rowEvent.Rows, rowEvent.Header.LogPos)
 	h.requestChan <- rowEvent
 	return nil
@@ -79,7 +90,7 @@ func (h *handler) startQueueListener() {
 		if pos != nil && h.useBoltStoragePosition {
 			position = *pos
 		}
-		rowPool := make([]canal.RowsEvent, 0, global.Config.HandlerRowEventPoolSize)
+		rowPool := make([]pooledRowEvent, 0, global.Config.HandlerRowEventPoolSize)
 		for {
 			needFlushRowEvent := false
 			needSavePos := false
@@ -98,8 +109,9 @@ func (h *handler) startQueueListener() {
 					}
 					position.Name = v.Name
 					position.Pos = v.Pos
-				case canal.RowsEvent:
-					rowPool = append(rowPool, v)
+				case *transfercanal.RowsEvent:
+					// 事务提交后才会同步位置，因此当前位置即所在事务的起始位置
+					rowPool = append(rowPool, pooledRowEvent{event: v, txn: position})
 					needFlushRowEvent = uint32(len(rowPool)) >= global.Config.HandlerRowEventPoolSize
 				}
 			case <-ticker.C:
@@ -110,14 +122,12 @@ func (h *handler) startQueueListener() {
 				return
 			}
 
-			if needFlushRowEvent {
-				for i := range rowPool {
-					data, err := json.Marshal(rowPool[i])
-					if err != nil {
-						return
-					}
-					h.boltStorage.AddRowRequest(data)
+			if needFlushRowEvent && len(rowPool) > 0 {
+				err := h.flushRowEvents(rowPool)
+				if err != nil {
+					return
 				}
+				rowPool = rowPool[:0]
 			}
 			if needSavePos {
 				err := h.savePos(position)
@@ -128,6 +138,49 @@ func (h *handler) startQueueListener() {
 		}
 	}()
 }
+
+type pooledRowEvent struct {
+	event *transfercanal.RowsEvent
+	txn   mysql.Position
+}
+
+func (h *handler) flushRowEvents(rowEvents []pooledRowEvent) error {
+	tables := make(map[string]*schema.Table)
+	requests := make([]*models.RowRequest, 0, len(rowEvents))
+	for _, pooled := range rowEvents {
+		rowEvent := pooled.event
+		tables[rowEvent.Table.Schema+"."+rowEvent.Table.Name] = rowEvent.Table
+		requests = append(requests, buildRowRequests(rowEvent, pooled.txn, h.encoder)...)
+	}
+
+	err := enrichRowRequests(h.source, tables, requests)
+	if err != nil {
+		logrus.Infof("enrich RowRequest err:%s", err.Error())
+		h.cancelFunc()
+		return err
+	}
+	encodeRowRequests(h.encoder, h.rules, tables, requests)
+	requests = diffRowRequests(h.rules, requests)
+
+	list := make([][]byte, 0, len(requests))
+	for i := range requests {
+		data, err := json.Marshal(requests[i])
+		if err != nil {
+			logrus.Infof("marshal RowRequest err:%s", err.Error())
+			h.cancelFunc()
+			return err
+		}
+		list = append(list, data)
+	}
+	err = h.boltStorage.BatchAddRowRequest(list)
+	if err != nil {
+		logrus.Infof("add RowRequest err:%s", err.Error())
+		h.cancelFunc()
+		return err
+	}
+	return nil
+}
+
 func (h *handler) savePos(v mysql.Position) error {
 	data, err := json.Marshal(v)
 	if err != nil {
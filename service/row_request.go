@@ -0,0 +1,367 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	transfercanal "github.com/JieWaZi/transfer-mysql/canal"
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/jsondiff"
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/source"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/schema"
+	"github.com/sirupsen/logrus"
+)
+
+// pendingRow 待回源补全的行
+type pendingRow struct {
+	request  *models.RowRequest
+	missing  []int
+	pkValues []interface{}
+}
+
+func buildRowRequests(rowEvent *transfercanal.RowsEvent, txn mysql.Position, enc *encoder.Encoder) []*models.RowRequest {
+	table := rowEvent.Table
+	columns := enc.Columns(table)
+	primaryKeys := make([]string, len(table.PKColumns))
+	for i, idx := range table.PKColumns {
+		primaryKeys[i] = table.Columns[idx].Name
+	}
+	var timestamp, logPos uint32
+	if rowEvent.Header != nil {
+		timestamp = rowEvent.Header.Timestamp
+		logPos = rowEvent.Header.LogPos
+	}
+
+	requests := make([]*models.RowRequest, 0, len(rowEvent.Rows))
+	newRequest := func() *models.RowRequest {
+		return &models.RowRequest{
+			Schema:      table.Schema,
+			Name:        table.Name,
+			Action:      rowEvent.Action,
+			Columns:     columns,
+			PrimaryKeys: primaryKeys,
+			Timestamp:   timestamp,
+			LogName:     txn.Name,
+			LogPos:      logPos,
+			TxnPos:      txn.Pos,
+			RowIndex:    len(requests),
+		}
+	}
+
+	switch rowEvent.Action {
+	case canal.UpdateAction:
+		for i := 0; i+1 < len(rowEvent.Rows); i += 2 {
+			req := newRequest()
+			req.OldRows = rowEvent.Rows[i]
+			req.NewRows = rowEvent.Rows[i+1]
+			req.MissingColumns = missingColumnNames(rowEvent, i+1)
+			if diffs, ok := rowEvent.JSONDiffs[i+1]; ok {
+				applyJSONDiffs(req, table, diffs)
+			}
+			requests = append(requests, req)
+		}
+	case canal.DeleteAction:
+		for i := range rowEvent.Rows {
+			req := newRequest()
+			req.OldRows = rowEvent.Rows[i]
+			req.MissingColumns = missingColumnNames(rowEvent, i)
+			requests = append(requests, req)
+		}
+	default:
+		for i := range rowEvent.Rows {
+			req := newRequest()
+			req.NewRows = rowEvent.Rows[i]
+			req.MissingColumns = missingColumnNames(rowEvent, i)
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
+
+// applyJSONDiffs PARTIAL_UPDATE_ROWS_EVENT 中修改后镜像的 JSON 字段为修改前的值，在其上应用 diff 得到完整文档；
+// 修改前镜像中没有该字段时无法还原，改为输出 JSON Patch 并标记为缺失字段
+func applyJSONDiffs(req *models.RowRequest, table *schema.Table, diffs map[int][]jsondiff.Diff) {
+	for idx, columnDiffs := range diffs {
+		if idx >= len(req.NewRows) || idx >= len(table.Columns) {
+			continue
+		}
+		name := table.Columns[idx].Name
+		var before []byte
+		switch v := req.NewRows[idx].(type) {
+		case []byte:
+			before = v
+		case string:
+			before = []byte(v)
+		}
+		if before != nil {
+			after, err := jsondiff.ApplyJSON(before, columnDiffs)
+			if err == nil {
+				if _, ok := req.NewRows[idx].(string); ok {
+					req.NewRows[idx] = string(after)
+				} else {
+					req.NewRows[idx] = after
+				}
+				continue
+			}
+			logrus.Warnf("apply json diff to %s.%s.%s err:%s", req.Schema, req.Name, name, err.Error())
+		}
+		patch, err := jsondiff.Patch(columnDiffs)
+		if err != nil {
+			logrus.Warnf("convert json diff of %s.%s.%s err:%s", req.Schema, req.Name, name, err.Error())
+		} else {
+			if req.JSONPatches == nil {
+				req.JSONPatches = map[string][]jsondiff.PatchOperation{}
+			}
+			req.JSONPatches[name] = patch
+		}
+		req.NewRows[idx] = nil
+		if !containsString(req.MissingColumns, name) {
+			req.MissingColumns = append(req.MissingColumns, name)
+		}
+	}
+}
+
+// enrichRowRequests binlog_row_image 为 MINIMAL/NOBLOB 时，按主键回源查询补全缺失字段
+func enrichRowRequests(src *source.Source, tables map[string]*schema.Table, requests []*models.RowRequest) error {
+	if !src.IsEnabled() || src.RowImage == source.RowImageFull {
+		return nil
+	}
+
+	pending := map[string][]*pendingRow{}
+	for _, req := range requests {
+		key := req.Schema + "." + req.Name
+		table := tables[key]
+		if table == nil || len(table.PKColumns) == 0 || req.NewRows == nil {
+			continue
+		}
+		missing := missingColumns(table, req.MissingColumns)
+		if len(missing) == 0 {
+			continue
+		}
+		pkValues, ok := primaryKeyValues(table, req)
+		if !ok {
+			continue
+		}
+		pending[key] = append(pending[key], &pendingRow{
+			request:  req,
+			missing:  missing,
+			pkValues: pkValues,
+		})
+	}
+
+	for key, rows := range pending {
+		table := tables[key]
+		for start := 0; start < len(rows); start += src.BatchSize {
+			end := start + src.BatchSize
+			if end > len(rows) {
+				end = len(rows)
+			}
+			if err := fetchFullRows(src, table, rows[start:end]); err != nil {
+				logrus.Errorf("fetch full rows from %s err:%s", key, err.Error())
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func fetchFullRows(src *source.Source, table *schema.Table, rows []*pendingRow) error {
+	columns := make([]string, len(table.Columns))
+	for i := range table.Columns {
+		columns[i] = quoteName(table.Columns[i].Name)
+	}
+	pkColumns := make([]string, len(table.PKColumns))
+	for i, idx := range table.PKColumns {
+		pkColumns[i] = quoteName(table.Columns[idx].Name)
+	}
+
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?,", len(pkColumns)), ",") + ")"
+	placeholders := make([]string, 0, len(rows))
+	args := make([]interface{}, 0, len(rows)*len(pkColumns))
+	for _, row := range rows {
+		placeholders = append(placeholders, placeholder)
+		args = append(args, row.pkValues...)
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s.%s WHERE (%s) IN (%s)",
+		strings.Join(columns, ","),
+		quoteName(table.Schema), quoteName(table.Name),
+		strings.Join(pkColumns, ","),
+		strings.Join(placeholders, ","))
+
+	res, err := src.Execute(sql, args...)
+	if err != nil {
+		return err
+	}
+
+	fullRows := make(map[string][]interface{}, len(res.Values))
+	for _, values := range res.Values {
+		row := make([]interface{}, len(values))
+		for i := range values {
+			row[i] = encoder.NormalizeValue(&table.Columns[i], values[i].Value())
+		}
+		pkValues := make([]interface{}, len(table.PKColumns))
+		for i, idx := range table.PKColumns {
+			pkValues[i] = row[idx]
+		}
+		fullRows[primaryKeyString(pkValues)] = row
+	}
+
+	for _, row := range rows {
+		fullRow, ok := fullRows[primaryKeyString(row.pkValues)]
+		if !ok {
+			// 行已被删除，保持原样
+			continue
+		}
+		newRows := make([]interface{}, len(row.request.NewRows))
+		copy(newRows, row.request.NewRows)
+		for _, idx := range row.missing {
+			newRows[idx] = fullRow[idx]
+			row.request.EnrichedColumns = append(row.request.EnrichedColumns, table.Columns[idx].Name)
+		}
+		row.request.NewRows = newRows
+		row.request.MissingColumns = removeStrings(row.request.MissingColumns, row.request.EnrichedColumns)
+	}
+	return nil
+}
+
+// encodeRowRequests 回源补全之后再按字段类型编码
+func encodeRowRequests(enc *encoder.Encoder, rules rule.Rules, tables map[string]*schema.Table, requests []*models.RowRequest) {
+	for _, req := range requests {
+		table := tables[req.Schema+"."+req.Name]
+		if table == nil {
+			continue
+		}
+		r := rules.Find(req.Schema, req.Name)
+		req.OldRows = enc.EncodeRow(table, r, req.OldRows)
+		req.NewRows = enc.EncodeRow(table, r, req.NewRows)
+	}
+}
+
+// diffRowRequests 计算 update 变化的字段，并按规则过滤，OnlyChangedColumns 在写入各 sink 时裁剪
+func diffRowRequests(rules rule.Rules, requests []*models.RowRequest) []*models.RowRequest {
+	result := requests[:0]
+	for _, req := range requests {
+		if req.Action != canal.UpdateAction {
+			result = append(result, req)
+			continue
+		}
+		req.ChangedColumns = changedColumns(req)
+		r := rules.Find(req.Schema, req.Name)
+		if r.IsIgnoredUpdate(req.ChangedColumns) {
+			continue
+		}
+		result = append(result, req)
+	}
+	return result
+}
+
+func changedColumns(req *models.RowRequest) []string {
+	changed := make([]string, 0)
+	for i := range req.Columns {
+		name := req.Columns[i].Name
+		// 回源补全的字段不在 binlog 修改后镜像中，视为未变化
+		if containsString(req.EnrichedColumns, name) {
+			continue
+		}
+		var oldValue, newValue interface{}
+		if i < len(req.OldRows) {
+			oldValue = req.OldRows[i]
+		}
+		if i < len(req.NewRows) {
+			newValue = req.NewRows[i]
+		}
+		if !valueEqual(oldValue, newValue) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+func valueEqual(a, b interface{}) bool {
+	if ra, ok := a.(json.RawMessage); ok {
+		if rb, ok := b.(json.RawMessage); ok {
+			return bytes.Equal(ra, rb)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func containsString(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
+
+// missingColumnNames 按 binlog 字段位图返回第 row 行镜像中缺少的字段，值为 NULL 的字段不在其中
+func missingColumnNames(rowEvent *transfercanal.RowsEvent, row int) []string {
+	var missing []string
+	for i := range rowEvent.Table.Columns {
+		if !rowEvent.IsPresent(row, i) {
+			missing = append(missing, rowEvent.Table.Columns[i].Name)
+		}
+	}
+	return missing
+}
+
+func missingColumns(table *schema.Table, names []string) []int {
+	var missing []int
+	for i := range table.Columns {
+		if containsString(names, table.Columns[i].Name) {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+func removeStrings(list []string, remove []string) []string {
+	result := list[:0]
+	for _, s := range list {
+		if !containsString(remove, s) {
+			result = append(result, s)
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func primaryKeyValues(table *schema.Table, req *models.RowRequest) ([]interface{}, bool) {
+	values := make([]interface{}, len(table.PKColumns))
+	for i, idx := range table.PKColumns {
+		if idx < len(req.NewRows) && req.NewRows[idx] != nil {
+			values[i] = req.NewRows[idx]
+		} else if idx < len(req.OldRows) && req.OldRows[idx] != nil {
+			values[i] = req.OldRows[idx]
+		} else {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+func primaryKeyString(values []interface{}) string {
+	keys := make([]string, len(values))
+	for i := range values {
+		if b, ok := values[i].([]byte); ok {
+			keys[i] = string(b)
+			continue
+		}
+		keys[i] = fmt.Sprintf("%v", values[i])
+	}
+	return strings.Join(keys, "\x00")
+}
+
+func quoteName(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	transfercanal "github.com/JieWaZi/transfer-mysql/canal"
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/jsondiff"
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/schema"
+)
+
+// TestPartialJSONUpdate PARTIAL_UPDATE_ROWS_EVENT 解析后修改后镜像中的 JSON 字段为修改前的值，
+// 生成的 RowRequest 中应为应用 diff 之后的文档
+func TestPartialJSONUpdate(t *testing.T) {
+	table := &schema.Table{
+		Schema: "db",
+		Name:   "user",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER, RawType: "int"},
+			{Name: "profile", Type: schema.TYPE_JSON, RawType: "json"},
+			{Name: "tags", Type: schema.TYPE_JSON, RawType: "json"},
+		},
+		PKColumns: []int{0},
+	}
+	rowEvent := &transfercanal.RowsEvent{
+		RowsEvent: &canal.RowsEvent{
+			Table:  table,
+			Action: canal.UpdateAction,
+			Rows: [][]interface{}{
+				{int64(1), []byte(`{"name":"a","age":10}`), []byte(`["x"]`)},
+				{int64(1), []byte(`{"name":"a","age":10}`), nil},
+			},
+		},
+		// 修改前镜像中没有 tags，无法还原完整文档
+		Present: [][]bool{{true, true, false}, {true, true, true}},
+		JSONDiffs: map[int]map[int][]jsondiff.Diff{
+			1: {
+				1: {{Operation: jsondiff.OperationReplace, Path: "$.age", Value: json.Number("11")}},
+				2: {{Operation: jsondiff.OperationInsert, Path: "$[1]", Value: "y"}},
+			},
+		},
+	}
+	enc := &encoder.Encoder{}
+	enc.SetDefaults()
+	if err := enc.Init(); err != nil {
+		t.Fatalf("encoder init err:%s", err.Error())
+	}
+
+	requests := buildRowRequests(rowEvent, mysql.Position{Name: "mysql-bin.000001", Pos: 4}, enc)
+	tables := map[string]*schema.Table{"db.user": table}
+	encodeRowRequests(enc, nil, tables, requests)
+	requests = diffRowRequests(nil, requests)
+	if len(requests) != 1 {
+		t.Fatalf("requests = %d, want 1", len(requests))
+	}
+	req := requests[0]
+
+	var profile map[string]interface{}
+	if err := json.Unmarshal(req.NewRows[1].(json.RawMessage), &profile); err != nil {
+		t.Fatalf("unmarshal profile err:%s", err.Error())
+	}
+	if want := map[string]interface{}{"name": "a", "age": float64(11)}; !reflect.DeepEqual(profile, want) {
+		t.Errorf("profile = %v, want %v", profile, want)
+	}
+	if !reflect.DeepEqual(req.ChangedColumns, []string{"profile", "tags"}) {
+		t.Errorf("ChangedColumns = %v, want [profile tags]", req.ChangedColumns)
+	}
+	if req.NewRows[2] != nil || !reflect.DeepEqual(req.MissingColumns, []string{"tags"}) {
+		t.Errorf("tags = %v missing %v, want nil missing [tags]", req.NewRows[2], req.MissingColumns)
+	}
+	want := []jsondiff.PatchOperation{{Op: "add", Path: "/1", Value: "y"}}
+	if !reflect.DeepEqual(req.JSONPatches["tags"], want) {
+		t.Errorf("JSONPatches = %+v, want %+v", req.JSONPatches["tags"], want)
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal err:%s", err.Error())
+	}
+	var decoded models.RowRequest
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal err:%s", err.Error())
+	}
+	if got := decoded.NewValue("profile"); !reflect.DeepEqual(got, map[string]interface{}{"name": "a", "age": float64(11)}) {
+		t.Errorf("stored profile = %v, want the patched document", got)
+	}
+}
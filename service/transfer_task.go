@@ -5,9 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"github.com/JieWaZi/transfer-mysql/canal"
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/global"
+	"github.com/JieWaZi/transfer-mysql/pull"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/JieWaZi/transfer-mysql/sink/assembler"
+	"github.com/JieWaZi/transfer-mysql/sink/feed"
+	"github.com/JieWaZi/transfer-mysql/source"
 	"github.com/JieWaZi/transfer-mysql/storage"
 	"github.com/google/uuid"
 	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/schema"
 	"github.com/sirupsen/logrus"
 	"go.uber.org/atomic"
 )
@@ -21,6 +30,12 @@ type Task struct {
 	handler *handler
 	boltDB  *storage.BoltStorage
 	canal   *canal.Canal
+	source  *source.Source
+	encoder *encoder.Encoder
+	rules   rule.Rules
+
+	consumer *consumer
+	sinks    []sink.Sink
 
 	running atomic.Bool
 }
@@ -40,6 +55,9 @@ func NewTask(options ...TaskOption) (*Task, error) {
 	ctx, cancel := context.WithCancel(t.ctx)
 	t.handler = &handler{
 		boltStorage:            t.boltDB,
+		source:                 t.source,
+		encoder:                t.encoder,
+		rules:                  t.rules,
 		ctx:                    ctx,
 		cancelFunc:             cancel,
 		requestChan:            make(chan interface{}, 4096),
@@ -48,7 +66,18 @@ func NewTask(options ...TaskOption) (*Task, error) {
 	if t.canal == nil {
 		return nil, errors.New("Canal is null, please init canal first ")
 	}
+	if t.encoder == nil {
+		return nil, errors.New("Encoder is null, please init encoder first ")
+	}
 	t.canal.SetEventHandler(t.handler)
+	t.consumer = &consumer{
+		boltStorage: t.boltDB,
+		sinks:       t.sinks,
+		rules:       t.rules,
+		ctx:         ctx,
+		batchSize:   int(global.Config.ConsumerBatchSize),
+		maxAttempts: int(global.Config.ConsumerMaxAttempts),
+	}
 	return t, nil
 }
 
@@ -74,11 +103,20 @@ func WithBoltDB(boltDB *storage.BoltStorage) TaskOption {
 				return err
 			}
 		}
+		// 任务 ID 保存在 TaskBucket 中，重启后继续使用同一个队列及位置
+		taskID, err := boltDB.TaskID(func() string { return t.taskID })
+		if err != nil {
+			return err
+		}
+		t.taskID = taskID
 		boltDB.RowRequestBucket = fmt.Sprintf("%s_%s", t.taskID, boltDB.RowRequestBucket)
 		boltDB.PositionBucket = fmt.Sprintf("%s_%s", t.taskID, boltDB.PositionBucket)
-		err := boltDB.CreateBucketIfNotExists(
+		// 游标使用固定的 bucket，消费方注册后不随任务变化
+		err = boltDB.CreateBucketIfNotExists(
 			[]byte(boltDB.RowRequestBucket),
 			[]byte(boltDB.PositionBucket),
+			[]byte(boltDB.CursorBucket),
+			[]byte(boltDB.DeadLetterBucket),
 			[]byte(boltDB.TaskBucket),
 		)
 		if err != nil {
@@ -89,8 +127,109 @@ func WithBoltDB(boltDB *storage.BoltStorage) TaskOption {
 	}
 }
 
+func WithSource(source *source.Source) TaskOption {
+	return func(t *Task) error {
+		if err := source.Init(); err != nil {
+			return err
+		}
+		t.source = source
+		return nil
+	}
+}
+
+func WithEncoder(encoder *encoder.Encoder) TaskOption {
+	return func(t *Task) error {
+		if err := encoder.Init(); err != nil {
+			return err
+		}
+		t.encoder = encoder
+		return nil
+	}
+}
+
+func WithRules(rules rule.Rules) TaskOption {
+	return func(t *Task) error {
+		t.rules = rules
+		return nil
+	}
+}
+
+// WithSink 未启用的 sink 会被忽略
+func WithSink(s sink.Sink) TaskOption {
+	return func(t *Task) error {
+		if s.IsEnabled() {
+			t.sinks = append(t.sinks, s)
+		}
+		return nil
+	}
+}
+
+// WithPullAPI 需在 WithBoltDB 之后调用
+func WithPullAPI(api *pull.API) TaskOption {
+	return func(t *Task) error {
+		if !api.IsEnabled() {
+			return nil
+		}
+		if t.boltDB == nil {
+			return errors.New("BoltDB is null, please init boltDB first ")
+		}
+		api.Bind(t.boltDB)
+		return nil
+	}
+}
+
+// WithFeed 需在 WithBoltDB、WithRules 之后调用，feed 直接读取 RowRequest 队列
+func WithFeed(f *feed.Feed) TaskOption {
+	return func(t *Task) error {
+		if !f.IsEnabled() {
+			return nil
+		}
+		if t.boltDB == nil {
+			return errors.New("BoltDB is null, please init boltDB first ")
+		}
+		if err := f.Bind(t.boltDB, t.rules); err != nil {
+			return err
+		}
+		t.sinks = append(t.sinks, f)
+		return nil
+	}
+}
+
+// WithAssembler 需在 WithCanal、WithSource、WithEncoder 及目标 sink 之后调用，目标 sink 改为只接收组装后的文档
+func WithAssembler(assembler *assembler.Assembler) TaskOption {
+	return func(t *Task) error {
+		if !assembler.IsEnabled() {
+			return nil
+		}
+		sinks := make([]sink.Sink, 0, len(t.sinks))
+		var target sink.Sink
+		for _, s := range t.sinks {
+			if s.String() == assembler.Target {
+				target = s
+				continue
+			}
+			sinks = append(sinks, s)
+		}
+		if target == nil {
+			return fmt.Errorf("assembler target sink %s is not enabled", assembler.Target)
+		}
+		if t.canal == nil || t.encoder == nil {
+			return errors.New("Canal or Encoder is null, please init them before assembler ")
+		}
+		tables := func(schemaName, tableName string) (*schema.Table, error) {
+			return t.canal.GetCanal().GetTable(schemaName, tableName)
+		}
+		if err := assembler.Bind(t.source, t.encoder, tables, target); err != nil {
+			return err
+		}
+		t.sinks = append(sinks, assembler)
+		return nil
+	}
+}
+
 func (t *Task) Run() (err error) {
 	t.handler.startQueueListener()
+	t.consumer.start()
 	var position = &mysql.Position{
 		Name: t.canal.BinlogFileName,
 		Pos:  t.canal.BinlogPosition,
@@ -121,12 +260,19 @@ func (t *Task) Stop() {
 	if t.canal != nil {
 		t.canal.Close()
 	}
+	if t.source != nil {
+		t.source.Close()
+	}
+	for _, s := range t.sinks {
+		s.Close()
+	}
 	t.running.Store(false)
 }
 
 func (t *Task) ReStart() (err error) {
 	if !t.running.Load() {
 		t.handler.startQueueListener()
+		t.consumer.start()
 		var position = &mysql.Position{
 			Name: t.canal.BinlogFileName,
 			Pos:  t.canal.BinlogPosition,
@@ -0,0 +1,395 @@
+package applier
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/siddontang/go-mysql/client"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ModeReplace = "replace"
+	ModeUpsert  = "upsert"
+
+	timeFormat = "2006-01-02 15:04:05.999999"
+)
+
+// Applier 将变更按主键幂等地回放到另一个 MySQL，每个源事务对应一个目标事务，
+// 并在同一事务中更新目标库的 checkpoint 表，重放时跳过已应用的行
+type Applier struct {
+	Enabled  bool   `env:""`
+	Host     string `env:""`
+	Port     int    `env:""`
+	User     string `env:""`
+	Password string `env:""`
+	// replace: insert 使用 REPLACE；upsert: insert 使用 INSERT ... ON DUPLICATE KEY UPDATE
+	// update 始终使用 upsert，避免只包含变化字段时覆盖其他字段
+	Mode string `env:""`
+	// 默认目标表模板，可在规则中通过 ApplyTable 覆盖
+	Table string `env:""`
+	// checkpoint 表所在库与表名，Name 区分写入同一目标库的多个实例
+	CheckpointSchema string `env:""`
+	CheckpointTable  string `env:""`
+	Name             string `env:""`
+
+	mu         sync.Mutex
+	conn       *client.Conn
+	checkpoint *position
+}
+
+type position struct {
+	logName  string
+	logPos   uint32
+	rowIndex int
+}
+
+func (a *Applier) SetDefaults() {
+	if a.Host == "" {
+		a.Host = "127.0.0.1"
+	}
+	if a.Port == 0 {
+		a.Port = 3306
+	}
+	if a.User == "" {
+		a.User = "root"
+	}
+	if a.Mode == "" {
+		a.Mode = ModeReplace
+	}
+	if a.Table == "" {
+		a.Table = "{{schema}}.{{table}}"
+	}
+	if a.CheckpointSchema == "" {
+		a.CheckpointSchema = "transfer"
+	}
+	if a.CheckpointTable == "" {
+		a.CheckpointTable = "transfer_checkpoint"
+	}
+	if a.Name == "" {
+		a.Name = "default"
+	}
+}
+
+func (a *Applier) Init() error {
+	if !a.Enabled {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.connect()
+}
+
+// connect 建立连接并读取 checkpoint，调用方需持有锁
+func (a *Applier) connect() error {
+	if a.conn != nil {
+		return nil
+	}
+	conn, err := client.Connect(fmt.Sprintf("%s:%d", a.Host, a.Port), a.User, a.Password, "")
+	if err != nil {
+		logrus.Errorf("applier connect err:%s", err.Error())
+		return err
+	}
+	// TIMESTAMP 统一按 UTC 写入
+	if _, err := conn.Execute("SET time_zone = '+00:00'"); err != nil {
+		conn.Close()
+		return err
+	}
+	a.conn = conn
+	if err := a.loadCheckpoint(); err != nil {
+		logrus.Errorf("applier load checkpoint err:%s", err.Error())
+		a.reset()
+		return err
+	}
+	return nil
+}
+
+func (a *Applier) loadCheckpoint() error {
+	table := quoteName(a.CheckpointSchema) + "." + quoteName(a.CheckpointTable)
+	if _, err := a.conn.Execute("CREATE DATABASE IF NOT EXISTS " + quoteName(a.CheckpointSchema)); err != nil {
+		return err
+	}
+	_, err := a.conn.Execute("CREATE TABLE IF NOT EXISTS " + table + ` (
+		name VARCHAR(64) NOT NULL PRIMARY KEY,
+		log_name VARCHAR(255) NOT NULL,
+		log_pos INT UNSIGNED NOT NULL,
+		row_index INT NOT NULL,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	res, err := a.conn.Execute("SELECT log_name, log_pos, row_index FROM "+table+" WHERE name = ?", a.Name)
+	if err != nil {
+		return err
+	}
+	a.checkpoint = nil
+	if res.Resultset == nil || res.RowNumber() == 0 {
+		return nil
+	}
+	logName, _ := res.GetString(0, 0)
+	logPos, _ := res.GetUint(0, 1)
+	rowIndex, _ := res.GetInt(0, 2)
+	a.checkpoint = &position{logName: logName, logPos: uint32(logPos), rowIndex: int(rowIndex)}
+	logrus.Infof("applier checkpoint %s:%d:%d", logName, logPos, rowIndex)
+	return nil
+}
+
+func (a *Applier) reset() {
+	if a.conn != nil {
+		a.conn.Close()
+		a.conn = nil
+	}
+}
+
+func (a *Applier) String() string { return "applier" }
+
+func (a *Applier) IsEnabled() bool {
+	return a != nil && a.Enabled
+}
+
+func (a *Applier) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.connect(); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(requests); {
+		end := start + 1
+		for end < len(requests) && requests[end].Transaction() == requests[start].Transaction() {
+			end++
+		}
+		if err := a.apply(rules, requests[start:end]); err != nil {
+			a.reset()
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+// apply 在一个目标事务中回放同一源事务的行，并更新 checkpoint
+func (a *Applier) apply(rules rule.Rules, requests []*models.RowRequest) error {
+	pending := make([]*models.RowRequest, 0, len(requests))
+	for _, req := range requests {
+		if !a.applied(req) {
+			pending = append(pending, req)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := a.conn.Begin(); err != nil {
+		return err
+	}
+	for _, req := range pending {
+		if err := a.execute(rules.Find(req.Schema, req.Name), req); err != nil {
+			logrus.Errorf("applier %s.%s %s err:%s", req.Schema, req.Name, req.Action, err.Error())
+			a.conn.Rollback()
+			return err
+		}
+	}
+	last := pending[len(pending)-1]
+	_, err := a.conn.Execute("REPLACE INTO "+quoteName(a.CheckpointSchema)+"."+quoteName(a.CheckpointTable)+
+		" (name, log_name, log_pos, row_index) VALUES (?, ?, ?, ?)", a.Name, last.LogName, last.LogPos, last.RowIndex)
+	if err != nil {
+		a.conn.Rollback()
+		return err
+	}
+	if err := a.conn.Commit(); err != nil {
+		return err
+	}
+	a.checkpoint = &position{logName: last.LogName, logPos: last.LogPos, rowIndex: last.RowIndex}
+	return nil
+}
+
+// applied 行位置不晚于 checkpoint 时已回放过
+func (a *Applier) applied(req *models.RowRequest) bool {
+	c := a.checkpoint
+	if c == nil || req.LogName == "" {
+		return false
+	}
+	if req.LogName != c.logName {
+		return req.LogName < c.logName
+	}
+	if req.LogPos != c.logPos {
+		return req.LogPos < c.logPos
+	}
+	return req.RowIndex <= c.rowIndex
+}
+
+func (a *Applier) execute(r *rule.Rule, req *models.RowRequest) error {
+	table := a.Table
+	if r != nil && r.ApplyTable != "" {
+		table = r.ApplyTable
+	}
+	table = quoteTable(sink.Render(table, req))
+
+	switch req.Action {
+	case canal.DeleteAction:
+		return a.delete(r, table, req, req.OldRows)
+	case canal.UpdateAction:
+		// 主键变化时先删除旧行
+		if primaryKeyChanged(req) {
+			if err := a.delete(r, table, req, req.OldRows); err != nil {
+				return err
+			}
+		}
+		return a.upsert(r, table, req, false)
+	default:
+		return a.upsert(r, table, req, a.Mode == ModeReplace)
+	}
+}
+
+func (a *Applier) upsert(r *rule.Rule, table string, req *models.RowRequest, replace bool) error {
+	sql, args := upsertSQL(r, table, req, replace)
+	if sql == "" {
+		return nil
+	}
+	_, err := a.conn.Execute(sql, args...)
+	return err
+}
+
+// upsertSQL 没有需要写入的字段时返回空字符串
+func upsertSQL(r *rule.Rule, table string, req *models.RowRequest, replace bool) (string, []interface{}) {
+	columns := make([]string, 0, len(req.Columns))
+	placeholders := make([]string, 0, len(req.Columns))
+	updates := make([]string, 0, len(req.Columns))
+	args := make([]interface{}, 0, len(req.Columns))
+	for i, column := range req.Columns {
+		name := r.ApplyColumn(column.Name)
+		if name == "-" || i >= len(req.NewRows) {
+			continue
+		}
+		placeholder, arg := bindValue(column, req.NewRows[i])
+		columns = append(columns, quoteName(name))
+		placeholders = append(placeholders, placeholder)
+		updates = append(updates, quoteName(name)+" = VALUES("+quoteName(name)+")")
+		args = append(args, arg)
+	}
+	if len(columns) == 0 {
+		return "", nil
+	}
+
+	verb := "INSERT"
+	if replace {
+		verb = "REPLACE"
+	}
+	sql := fmt.Sprintf("%s INTO %s (%s) VALUES (%s)", verb, table,
+		strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	if !replace {
+		sql += " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+	}
+	return sql, args
+}
+
+func (a *Applier) delete(r *rule.Rule, table string, req *models.RowRequest, row []interface{}) error {
+	if len(req.PrimaryKeys) == 0 {
+		logrus.Warnf("applier skip delete %s.%s without primary key", req.Schema, req.Name)
+		return nil
+	}
+	sql, args, err := deleteSQL(r, table, req, row)
+	if err != nil {
+		return err
+	}
+	_, err = a.conn.Execute(sql, args...)
+	return err
+}
+
+func deleteSQL(r *rule.Rule, table string, req *models.RowRequest, row []interface{}) (string, []interface{}, error) {
+	conditions := make([]string, 0, len(req.PrimaryKeys))
+	args := make([]interface{}, 0, len(req.PrimaryKeys))
+	for _, pk := range req.PrimaryKeys {
+		index := req.ColumnIndex(pk)
+		if index < 0 || index >= len(row) {
+			return "", nil, fmt.Errorf("primary key %s of %s.%s not found", pk, req.Schema, req.Name)
+		}
+		placeholder, arg := bindValue(req.Columns[index], row[index])
+		conditions = append(conditions, quoteName(r.ApplyColumn(pk))+" = "+placeholder)
+		args = append(args, arg)
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE %s", table, strings.Join(conditions, " AND ")), args, nil
+}
+
+// bindValue 返回占位符表达式与参数，空间字段按输出格式使用对应的构造函数
+func bindValue(column models.Column, value interface{}) (string, interface{}) {
+	value = sink.SQLValue(column, value)
+	switch v := value.(type) {
+	case nil:
+		return "?", nil
+	case time.Time:
+		if column.Type == encoder.ColumnTypeTimestamp {
+			return "?", v.UTC().Format(timeFormat)
+		}
+		return "?", v.Format(timeFormat)
+	case string:
+		if column.Type != encoder.ColumnTypeGeometry {
+			return "?", v
+		}
+		switch {
+		case strings.HasPrefix(v, "{"):
+			// SRID 由 GeoJSON 中的 crs 指定
+			return "ST_GeomFromGeoJSON(?)", v
+		case strings.ContainsAny(v, "( "):
+			srid, wkt := encoder.ParseEWKT(v)
+			return geometryFunc("ST_GeomFromText", srid), wkt
+		}
+		// 输出为 MySQL 内部格式，去掉前 4 字节的 SRID
+		if data, err := base64.StdEncoding.DecodeString(v); err == nil && len(data) > 4 {
+			return geometryFunc("ST_GeomFromWKB", binary.LittleEndian.Uint32(data)), data[4:]
+		}
+	case bool:
+		if v {
+			return "?", 1
+		}
+		return "?", 0
+	}
+	return "?", value
+}
+
+// geometryFunc 输出的坐标 x 为经度，地理坐标系默认按纬度在前解析，需要指定轴顺序
+func geometryFunc(name string, srid uint32) string {
+	if srid == 0 {
+		return name + "(?)"
+	}
+	return fmt.Sprintf("%s(?, %d, 'axis-order=long-lat')", name, srid)
+}
+
+func primaryKeyChanged(req *models.RowRequest) bool {
+	for _, pk := range req.PrimaryKeys {
+		if sink.StringValue(req.OldValue(pk)) != sink.StringValue(req.NewValue(pk)) {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteTable 支持 schema.table 形式
+func quoteTable(name string) string {
+	parts := strings.SplitN(name, ".", 2)
+	for i := range parts {
+		parts[i] = quoteName(parts[i])
+	}
+	return strings.Join(parts, ".")
+}
+
+func quoteName(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func (a *Applier) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reset()
+}
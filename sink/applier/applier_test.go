@@ -0,0 +1,182 @@
+package applier
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/siddontang/go-mysql/canal"
+)
+
+func TestApplied(t *testing.T) {
+	a := &Applier{checkpoint: &position{logName: "mysql-bin.000002", logPos: 1100, rowIndex: 1}}
+	tests := []struct {
+		name string
+		req  *models.RowRequest
+		want bool
+	}{
+		{name: "earlier file", req: &models.RowRequest{LogName: "mysql-bin.000001", LogPos: 9000}, want: true},
+		{name: "earlier position", req: &models.RowRequest{LogName: "mysql-bin.000002", LogPos: 900, RowIndex: 5}, want: true},
+		{name: "same row", req: &models.RowRequest{LogName: "mysql-bin.000002", LogPos: 1100, RowIndex: 1}, want: true},
+		{name: "next row", req: &models.RowRequest{LogName: "mysql-bin.000002", LogPos: 1100, RowIndex: 2}},
+		{name: "later position", req: &models.RowRequest{LogName: "mysql-bin.000002", LogPos: 1200}},
+		{name: "later file", req: &models.RowRequest{LogName: "mysql-bin.000003", LogPos: 4}},
+		{name: "without position", req: &models.RowRequest{}},
+	}
+	for _, tt := range tests {
+		if got := a.applied(tt.req); got != tt.want {
+			t.Errorf("%s applied = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if (&Applier{}).applied(tests[0].req) {
+		t.Errorf("applied without checkpoint = true, want false")
+	}
+}
+
+func TestBindValue(t *testing.T) {
+	column := func(typ string) models.Column { return models.Column{Name: "c", Type: typ} }
+	tests := []struct {
+		name        string
+		column      models.Column
+		value       interface{}
+		placeholder string
+		want        interface{}
+	}{
+		{name: "nil", column: column(encoder.ColumnTypeString), value: nil, placeholder: "?", want: nil},
+		{name: "string", column: column(encoder.ColumnTypeString), value: "abc", placeholder: "?", want: "abc"},
+		{name: "number", column: column(encoder.ColumnTypeNumber), value: json.Number("12"), placeholder: "?", want: "12"},
+		{name: "binary", column: column(encoder.ColumnTypeBinary), value: "AQI=", placeholder: "?", want: []byte{1, 2}},
+		{name: "bit", column: column(encoder.ColumnTypeBit), value: "101", placeholder: "?", want: uint64(5)},
+		{name: "json string", column: column(encoder.ColumnTypeJSON), value: "abc", placeholder: "?", want: `"abc"`},
+		{name: "json bool", column: column(encoder.ColumnTypeJSON), value: true, placeholder: "?", want: "true"},
+		{name: "json number", column: column(encoder.ColumnTypeJSON), value: json.Number("1.50"), placeholder: "?", want: "1.50"},
+		{
+			name:        "json object",
+			column:      column(encoder.ColumnTypeJSON),
+			value:       map[string]interface{}{"a": []interface{}{json.Number("1")}},
+			placeholder: "?",
+			want:        `{"a":[1]}`,
+		},
+		{
+			name:        "datetime",
+			column:      column(encoder.ColumnTypeDatetime),
+			value:       "2020-01-02T03:04:05.5+08:00",
+			placeholder: "?",
+			want:        "2020-01-02 03:04:05.5",
+		},
+		{
+			name:        "timestamp",
+			column:      column(encoder.ColumnTypeTimestamp),
+			value:       "2020-01-02T03:04:05+08:00",
+			placeholder: "?",
+			want:        "2020-01-01 19:04:05",
+		},
+		{
+			name:        "geojson",
+			column:      column(encoder.ColumnTypeGeometry),
+			value:       `{"type":"Point","coordinates":[1,2]}`,
+			placeholder: "ST_GeomFromGeoJSON(?)",
+			want:        `{"type":"Point","coordinates":[1,2]}`,
+		},
+		{name: "wkt", column: column(encoder.ColumnTypeGeometry), value: "POINT(1 2)", placeholder: "ST_GeomFromText(?)", want: "POINT(1 2)"},
+		{
+			name:        "ewkt",
+			column:      column(encoder.ColumnTypeGeometry),
+			value:       "SRID=4326;POINT(1 2)",
+			placeholder: "ST_GeomFromText(?, 4326, 'axis-order=long-lat')",
+			want:        "POINT(1 2)",
+		},
+		{
+			name:        "wkb",
+			column:      column(encoder.ColumnTypeGeometry),
+			value:       "AAAAAAEC",
+			placeholder: "ST_GeomFromWKB(?)",
+			want:        []byte{1, 2},
+		},
+		{
+			name:        "wkb with srid",
+			column:      column(encoder.ColumnTypeGeometry),
+			value:       "5hAAAAEC",
+			placeholder: "ST_GeomFromWKB(?, 4326, 'axis-order=long-lat')",
+			want:        []byte{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		placeholder, got := bindValue(tt.column, tt.value)
+		if placeholder != tt.placeholder || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s bindValue = %s %#v, want %s %#v", tt.name, placeholder, got, tt.placeholder, tt.want)
+		}
+	}
+}
+
+func newUserRequest(action string) *models.RowRequest {
+	return &models.RowRequest{
+		Schema: "db",
+		Name:   "user",
+		Action: action,
+		Columns: []models.Column{
+			{Name: "id", Type: encoder.ColumnTypeNumber},
+			{Name: "name", Type: encoder.ColumnTypeString},
+			{Name: "secret", Type: encoder.ColumnTypeString},
+		},
+		PrimaryKeys: []string{"id"},
+		OldRows:     []interface{}{json.Number("1"), "a", "x"},
+		NewRows:     []interface{}{json.Number("2"), "b", "y"},
+	}
+}
+
+func TestUpsertSQL(t *testing.T) {
+	r := &rule.Rule{ApplyColumnMappings: []string{"name:nick", "secret:-"}}
+	tests := []struct {
+		name    string
+		r       *rule.Rule
+		replace bool
+		want    string
+		args    []interface{}
+	}{
+		{
+			name:    "replace",
+			replace: true,
+			want:    "REPLACE INTO `db`.`user` (`id`, `name`, `secret`) VALUES (?, ?, ?)",
+			args:    []interface{}{"2", "b", "y"},
+		},
+		{
+			name: "upsert with mappings",
+			r:    r,
+			want: "INSERT INTO `db`.`user` (`id`, `nick`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `id` = VALUES(`id`), `nick` = VALUES(`nick`)",
+			args: []interface{}{"2", "b"},
+		},
+	}
+	for _, tt := range tests {
+		sql, args := upsertSQL(tt.r, quoteTable("db.user"), newUserRequest(canal.InsertAction), tt.replace)
+		if sql != tt.want || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s upsertSQL = %s %v, want %s %v", tt.name, sql, args, tt.want, tt.args)
+		}
+	}
+
+	req := newUserRequest(canal.InsertAction)
+	req.NewRows = nil
+	if sql, _ := upsertSQL(nil, "`t`", req, false); sql != "" {
+		t.Errorf("upsertSQL without values = %s, want empty", sql)
+	}
+}
+
+func TestDeleteSQL(t *testing.T) {
+	req := newUserRequest(canal.DeleteAction)
+	r := &rule.Rule{ApplyColumnMappings: []string{"id:user_id"}}
+	sql, args, err := deleteSQL(r, quoteTable("db.user"), req, req.OldRows)
+	if err != nil {
+		t.Fatalf("deleteSQL err:%s", err.Error())
+	}
+	if want := "DELETE FROM `db`.`user` WHERE `user_id` = ?"; sql != want || !reflect.DeepEqual(args, []interface{}{"1"}) {
+		t.Errorf("deleteSQL = %s %v, want %s [1]", sql, args, want)
+	}
+
+	req.PrimaryKeys = []string{"id", "tenant_id"}
+	if _, _, err := deleteSQL(nil, "`t`", req, req.OldRows); err == nil {
+		t.Errorf("deleteSQL with missing primary key, want error")
+	}
+}
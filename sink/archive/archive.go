@@ -0,0 +1,189 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/objectstore"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+
+	SSENone = ""
+	SSES3   = "AES256"
+	SSEKMS  = "aws:kms"
+)
+
+// Archive 将每批事件按表压缩为 JSONL 分段上传到 S3 兼容存储，上传成功后才返回，
+// 队列中的记录随后才会被删除。分段 key 由首尾事件版本号组成，重试时覆盖同一对象
+type Archive struct {
+	Enabled bool `env:""`
+	// 对象 key 模板，除 {{schema}}、{{table}} 外支持 {{date}}、{{hour}}、{{first}}、{{last}}、{{ext}}
+	Key string `env:""`
+	// none/gzip/zstd
+	Compression string `env:""`
+	// 服务端加密，AES256/aws:kms，为空时不加密
+	SSE         string         `env:""`
+	SSEKMSKeyID string         `env:""`
+	S3          objectstore.S3 `env:""`
+
+	headers http.Header
+}
+
+func (a *Archive) SetDefaults() {
+	if a.Key == "" {
+		a.Key = "{{schema}}/{{table}}/dt={{date}}/hour={{hour}}/{{first}}-{{last}}{{ext}}"
+	}
+	if a.Compression == "" {
+		a.Compression = CompressionGzip
+	}
+	a.S3.SetDefaults()
+}
+
+func (a *Archive) Init() error {
+	if !a.Enabled || a.headers != nil {
+		return nil
+	}
+	if a.Compression != CompressionNone && a.Compression != CompressionGzip && a.Compression != CompressionZstd {
+		return fmt.Errorf("unsupported archive compression %s", a.Compression)
+	}
+	headers := http.Header{"Content-Type": {a.contentType()}}
+	switch a.SSE {
+	case SSENone:
+	case SSES3:
+		headers.Set("x-amz-server-side-encryption", a.SSE)
+	case SSEKMS:
+		headers.Set("x-amz-server-side-encryption", a.SSE)
+		if a.SSEKMSKeyID != "" {
+			headers.Set("x-amz-server-side-encryption-aws-kms-key-id", a.SSEKMSKeyID)
+		}
+	default:
+		return fmt.Errorf("unsupported archive sse %s", a.SSE)
+	}
+	if err := a.S3.Init(); err != nil {
+		logrus.Errorf("archive init s3 err:%s", err.Error())
+		return err
+	}
+	a.headers = headers
+	return nil
+}
+
+func (a *Archive) String() string { return "archive" }
+
+func (a *Archive) IsEnabled() bool {
+	return a != nil && a.Enabled
+}
+
+func (a *Archive) ChangedColumns(r *rule.Rule) bool { return true }
+
+// Write 按表生成分段并上传，任一分段失败返回错误，整批重试
+func (a *Archive) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	tables := make([]string, 0)
+	groups := make(map[string][]*models.RowRequest)
+	for _, req := range requests {
+		table := req.Schema + "." + req.Name
+		if _, ok := groups[table]; !ok {
+			tables = append(tables, table)
+		}
+		groups[table] = append(groups[table], req)
+	}
+
+	for _, table := range tables {
+		group := groups[table]
+		body, err := a.encode(group)
+		if err != nil {
+			return err
+		}
+		key := a.key(group)
+		if err := a.S3.Upload(key, bytes.NewReader(body), int64(len(body)), a.headers); err != nil {
+			logrus.Errorf("archive upload %s err:%s", key, err.Error())
+			return err
+		}
+		logrus.Debugf("archive upload %s rows:%d bytes:%d", key, len(group), len(body))
+	}
+	return nil
+}
+
+func (a *Archive) encode(requests []*models.RowRequest) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch a.Compression {
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+	case CompressionZstd:
+		encoder, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		w = encoder
+	}
+	var out io.Writer = &buf
+	if w != nil {
+		out = w
+	}
+	for _, req := range requests {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := out.Write(append(data, '\n')); err != nil {
+			return nil, err
+		}
+	}
+	if w != nil {
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// key 日期与小时取首个事件时间(UTC)，版本号补零便于按字典序排列
+func (a *Archive) key(requests []*models.RowRequest) string {
+	first, last := requests[0], requests[len(requests)-1]
+	t := time.Unix(int64(first.Timestamp), 0).UTC()
+	replacer := strings.NewReplacer(
+		"{{date}}", t.Format("2006-01-02"),
+		"{{hour}}", fmt.Sprintf("%02d", t.Hour()),
+		"{{first}}", fmt.Sprintf("%020d", first.Version()),
+		"{{last}}", fmt.Sprintf("%020d", last.Version()),
+		"{{ext}}", a.extension(),
+	)
+	return strings.TrimPrefix(sink.Render(replacer.Replace(a.Key), first), "/")
+}
+
+func (a *Archive) extension() string {
+	switch a.Compression {
+	case CompressionGzip:
+		return ".jsonl.gz"
+	case CompressionZstd:
+		return ".jsonl.zst"
+	}
+	return ".jsonl"
+}
+
+func (a *Archive) contentType() string {
+	switch a.Compression {
+	case CompressionGzip:
+		return "application/gzip"
+	case CompressionZstd:
+		return "application/zstd"
+	}
+	return "application/x-ndjson"
+}
+
+func (a *Archive) Close() {}
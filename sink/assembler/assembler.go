@@ -0,0 +1,440 @@
+package assembler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/JieWaZi/transfer-mysql/source"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/schema"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	RelationOne  = "one"
+	RelationMany = "many"
+)
+
+// Assembler 关联表任意一张发生变化时，回源查询并重新组装根表文档，整体写入目标 sink
+type Assembler struct {
+	Enabled bool `env:""`
+	// 目标 sink 名称，如 elasticsearch
+	Target    string     `env:""`
+	Documents []Document `env:""`
+
+	// 回源查询，按批量大小分批执行
+	execute   func(cmd string, args ...interface{}) (*mysql.Result, error)
+	batchSize int
+	encoder   *encoder.Encoder
+	tables    TableFunc
+	target    sink.Sink
+}
+
+// TableFunc 获取表结构，用于按字段类型编码组装后的文档
+type TableFunc func(schema, table string) (*schema.Table, error)
+
+type Document struct {
+	Schema     string `env:""`
+	Table      string `env:""`
+	PrimaryKey string `env:""`
+	// 格式为 field:table:column:rootColumn:one|many，表示 table.column = root.rootColumn 的行嵌入到 field
+	// 如 items:order_item:order_id:id:many、customer:customer:id:customer_id:one
+	Relations []string `env:""`
+}
+
+type relation struct {
+	field      string
+	schema     string
+	table      string
+	column     string
+	rootColumn string
+	many       bool
+}
+
+func (a *Assembler) SetDefaults() {
+	for i := range a.Documents {
+		if a.Documents[i].PrimaryKey == "" {
+			a.Documents[i].PrimaryKey = "id"
+		}
+	}
+}
+
+func (a *Assembler) Init() error {
+	if !a.Enabled {
+		return nil
+	}
+	for i := range a.Documents {
+		if _, err := a.Documents[i].relations(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bind 绑定回源连接、字段编码与目标 sink，目标 sink 只接收组装后的文档
+func (a *Assembler) Bind(src *source.Source, enc *encoder.Encoder, tables TableFunc, target sink.Sink) error {
+	if !src.IsEnabled() {
+		return errors.New("assembler requires source to be enabled")
+	}
+	a.execute = src.Execute
+	a.batchSize = src.BatchSize
+	a.encoder = enc
+	a.tables = tables
+	a.target = target
+	return nil
+}
+
+func (a *Assembler) String() string { return "assembler" }
+
+func (a *Assembler) IsEnabled() bool {
+	return a != nil && a.Enabled
+}
+
+// ChangedColumns 未关联的表直接写入目标 sink，与目标保持一致
+func (a *Assembler) ChangedColumns(r *rule.Rule) bool {
+	changed, ok := a.target.(sink.ChangedColumnsSink)
+	return ok && changed.ChangedColumns(r)
+}
+
+func (a *Assembler) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	passthrough := make([]*models.RowRequest, 0)
+	assembled := make([]*models.RowRequest, 0)
+
+	for i := range a.Documents {
+		doc := &a.Documents[i]
+		keys, err := a.affectedKeys(doc, requests)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		docs, err := a.assemble(rules, doc, keys)
+		if err != nil {
+			return err
+		}
+		assembled = append(assembled, docs...)
+	}
+
+	// 组装结果为查询时的最新数据，使用批次中最后一个事件的位置，目标按位置做版本控制
+	if len(requests) > 0 {
+		last := requests[len(requests)-1]
+		for _, req := range assembled {
+			req.LogName, req.LogPos, req.TxnPos, req.RowIndex = last.LogName, last.LogPos, last.TxnPos, last.RowIndex
+			req.Timestamp = last.Timestamp
+		}
+	}
+
+	for _, req := range requests {
+		if !a.related(req) {
+			passthrough = append(passthrough, req)
+		}
+	}
+	return a.target.Write(rules, append(passthrough, assembled...))
+}
+
+func (a *Assembler) related(req *models.RowRequest) bool {
+	for i := range a.Documents {
+		doc := &a.Documents[i]
+		if req.Schema == doc.Schema && req.Name == doc.Table {
+			return true
+		}
+		relations, _ := doc.relations()
+		for _, rel := range relations {
+			if req.Schema == rel.schema && req.Name == rel.table {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// affectedKeys 根据变更行的修改前后取值计算需要重建的根表主键
+func (a *Assembler) affectedKeys(doc *Document, requests []*models.RowRequest) ([]string, error) {
+	keys := newKeySet()
+	relations, err := doc.relations()
+	if err != nil {
+		return nil, err
+	}
+	lookups := make(map[string]*keySet)
+
+	for _, req := range requests {
+		if req.Schema == doc.Schema && req.Name == doc.Table {
+			keys.add(sink.StringValue(req.OldValue(doc.PrimaryKey)))
+			keys.add(sink.StringValue(req.NewValue(doc.PrimaryKey)))
+			continue
+		}
+		for _, rel := range relations {
+			if req.Schema != rel.schema || req.Name != rel.table {
+				continue
+			}
+			values := []string{
+				sink.StringValue(req.OldValue(rel.column)),
+				sink.StringValue(req.NewValue(rel.column)),
+			}
+			if rel.rootColumn == doc.PrimaryKey {
+				keys.add(values...)
+				continue
+			}
+			if lookups[rel.rootColumn] == nil {
+				lookups[rel.rootColumn] = newKeySet()
+			}
+			lookups[rel.rootColumn].add(values...)
+		}
+	}
+
+	for rootColumn, values := range lookups {
+		res, err := a.query(doc.Schema, doc.Table, []string{doc.PrimaryKey}, rootColumn, values.list)
+		if err != nil {
+			return nil, err
+		}
+		for i := range res.Values {
+			keys.add(sink.StringValue(normalize(res.Values[i][0].Value())))
+		}
+	}
+	return keys.list, nil
+}
+
+func (a *Assembler) assemble(rules rule.Rules, doc *Document, keys []string) ([]*models.RowRequest, error) {
+	res, err := a.query(doc.Schema, doc.Table, nil, doc.PrimaryKey, keys)
+	if err != nil {
+		return nil, err
+	}
+	roots := rowsToMaps(res)
+
+	relations, _ := doc.relations()
+	embedded := make([]map[string][]map[string]interface{}, len(relations))
+	for i, rel := range relations {
+		values := newKeySet()
+		for _, root := range roots {
+			values.add(sink.StringValue(root[rel.rootColumn]))
+		}
+		if len(values.list) == 0 {
+			continue
+		}
+		res, err := a.query(rel.schema, rel.table, nil, rel.column, values.list)
+		if err != nil {
+			return nil, err
+		}
+		embedded[i] = make(map[string][]map[string]interface{})
+		for _, row := range rowsToMaps(res) {
+			key := sink.StringValue(row[rel.column])
+			embedded[i][key] = append(embedded[i][key], row)
+		}
+	}
+
+	rootTable := a.table(doc.Schema, doc.Table)
+	rootRule := rules.Find(doc.Schema, doc.Table)
+	found := make(map[string]bool, len(roots))
+	requests := make([]*models.RowRequest, 0, len(keys))
+	for _, root := range roots {
+		columns := make([]models.Column, 0, len(res.Fields)+len(relations))
+		values := make([]interface{}, 0, cap(columns))
+		for _, field := range res.Fields {
+			name := string(field.Name)
+			column := models.Column{Name: name}
+			if idx := findColumn(rootTable, name); idx >= 0 {
+				column.Type = encoder.ColumnType(&rootTable.Columns[idx])
+				column.RawType = rootTable.Columns[idx].RawType
+			}
+			columns = append(columns, column)
+			values = append(values, a.encode(rootTable, rootRule, name, root[name]))
+		}
+		for i, rel := range relations {
+			children := embedded[i][sink.StringValue(root[rel.rootColumn])]
+			columns = append(columns, models.Column{Name: rel.field, Type: "object"})
+			childTable := a.table(rel.schema, rel.table)
+			childRule := rules.Find(rel.schema, rel.table)
+			encoded := make([]map[string]interface{}, 0, len(children))
+			for _, child := range children {
+				encoded = append(encoded, a.encodeRow(childTable, childRule, child))
+			}
+			if rel.many {
+				values = append(values, encoded)
+			} else if len(encoded) > 0 {
+				values = append(values, encoded[0])
+			} else {
+				values = append(values, nil)
+			}
+		}
+		key := sink.StringValue(root[doc.PrimaryKey])
+		found[key] = true
+		requests = append(requests, &models.RowRequest{
+			Schema:      doc.Schema,
+			Name:        doc.Table,
+			Action:      canal.InsertAction,
+			Columns:     columns,
+			PrimaryKeys: []string{doc.PrimaryKey},
+			NewRows:     values,
+		})
+	}
+
+	// 根表中已不存在的文档发送 delete
+	for _, key := range keys {
+		if found[key] {
+			continue
+		}
+		requests = append(requests, &models.RowRequest{
+			Schema:      doc.Schema,
+			Name:        doc.Table,
+			Action:      canal.DeleteAction,
+			Columns:     []models.Column{{Name: doc.PrimaryKey}},
+			PrimaryKeys: []string{doc.PrimaryKey},
+			OldRows:     []interface{}{key},
+		})
+	}
+	return requests, nil
+}
+
+func (a *Assembler) query(schema, table string, columns []string, column string, values []string) (*mysql.Result, error) {
+	selected := "*"
+	if len(columns) > 0 {
+		quoted := make([]string, len(columns))
+		for i := range columns {
+			quoted[i] = quoteName(columns[i])
+		}
+		selected = strings.Join(quoted, ",")
+	}
+	// 按回源批量大小分批查询后合并
+	result := &mysql.Result{Resultset: &mysql.Resultset{}}
+	for start := 0; start < len(values); start += a.batchSize {
+		end := start + a.batchSize
+		if end > len(values) {
+			end = len(values)
+		}
+		args := make([]interface{}, 0, end-start)
+		for i := start; i < end; i++ {
+			args = append(args, values[i])
+		}
+		sql := fmt.Sprintf("SELECT %s FROM %s.%s WHERE %s IN (%s)",
+			selected, quoteName(schema), quoteName(table), quoteName(column),
+			strings.TrimSuffix(strings.Repeat("?,", len(args)), ","))
+		res, err := a.execute(sql, args...)
+		if err != nil {
+			logrus.Errorf("assembler query %s.%s err:%s", schema, table, err.Error())
+			return nil, err
+		}
+		if res.Resultset == nil {
+			continue
+		}
+		result.Fields = res.Fields
+		result.Values = append(result.Values, res.Values...)
+	}
+	return result, nil
+}
+
+func (a *Assembler) Close() {
+	if a.target != nil {
+		a.target.Close()
+	}
+}
+
+func (d *Document) relations() ([]relation, error) {
+	relations := make([]relation, 0, len(d.Relations))
+	for _, item := range d.Relations {
+		parts := strings.Split(item, ":")
+		if len(parts) != 5 {
+			return nil, fmt.Errorf("invalid relation %s of %s.%s", item, d.Schema, d.Table)
+		}
+		rel := relation{
+			field:      parts[0],
+			schema:     d.Schema,
+			table:      parts[1],
+			column:     parts[2],
+			rootColumn: parts[3],
+			many:       parts[4] == RelationMany,
+		}
+		if i := strings.Index(rel.table, "."); i > 0 {
+			rel.schema, rel.table = rel.table[:i], rel.table[i+1:]
+		}
+		relations = append(relations, rel)
+	}
+	return relations, nil
+}
+
+// rowsToMaps 保留查询返回的原始值，编码前仍可按字段类型区分二进制数据
+func rowsToMaps(res *mysql.Result) []map[string]interface{} {
+	rows := make([]map[string]interface{}, 0, len(res.Values))
+	for _, values := range res.Values {
+		row := make(map[string]interface{}, len(values))
+		for i := range values {
+			row[string(res.Fields[i].Name)] = values[i].Value()
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// table 获取表结构失败时返回 nil，字段按原样输出
+func (a *Assembler) table(schemaName, tableName string) *schema.Table {
+	if a.tables == nil {
+		return nil
+	}
+	table, err := a.tables(schemaName, tableName)
+	if err != nil {
+		logrus.Warnf("assembler get table %s.%s err:%s", schemaName, tableName, err.Error())
+		return nil
+	}
+	return table
+}
+
+func (a *Assembler) encodeRow(table *schema.Table, r *rule.Rule, row map[string]interface{}) map[string]interface{} {
+	encoded := make(map[string]interface{}, len(row))
+	for name, value := range row {
+		encoded[name] = a.encode(table, r, name, value)
+	}
+	return encoded
+}
+
+// encode 与 binlog 事件使用相同的编码，保证组装文档与单表数据格式一致
+func (a *Assembler) encode(table *schema.Table, r *rule.Rule, name string, value interface{}) interface{} {
+	idx := findColumn(table, name)
+	if idx < 0 || a.encoder == nil {
+		return normalize(value)
+	}
+	column := &table.Columns[idx]
+	return a.encoder.EncodeValue(column, r, encoder.NormalizeValue(column, value))
+}
+
+func findColumn(table *schema.Table, name string) int {
+	if table == nil {
+		return -1
+	}
+	return table.FindColumn(name)
+}
+
+func normalize(value interface{}) interface{} {
+	if b, ok := value.([]byte); ok {
+		return string(b)
+	}
+	return value
+}
+
+func quoteName(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+type keySet struct {
+	seen map[string]bool
+	list []string
+}
+
+func newKeySet() *keySet {
+	return &keySet{seen: make(map[string]bool)}
+}
+
+func (s *keySet) add(keys ...string) {
+	for _, key := range keys {
+		if key == "" || s.seen[key] {
+			continue
+		}
+		s.seen[key] = true
+		s.list = append(s.list, key)
+	}
+}
@@ -0,0 +1,242 @@
+package assembler
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/schema"
+)
+
+func TestEncodeRow(t *testing.T) {
+	enc := &encoder.Encoder{Timezone: "UTC"}
+	enc.SetDefaults()
+	if err := enc.Init(); err != nil {
+		t.Fatalf("init encoder err:%s", err.Error())
+	}
+	table := &schema.Table{Schema: "db", Name: "order_item"}
+	table.AddColumn("id", "int(11)", "", "")
+	table.AddColumn("price", "decimal(10,2)", "", "")
+	table.AddColumn("status", "enum('new','paid')", "", "")
+	table.AddColumn("flags", "bit(4)", "", "")
+	table.AddColumn("attrs", "json", "", "")
+	table.AddColumn("created_at", "datetime", "", "")
+	a := &Assembler{encoder: enc}
+
+	row := a.encodeRow(table, nil, map[string]interface{}{
+		"id":         int64(1),
+		"price":      []byte("9.90"),
+		"status":     []byte("paid"),
+		"flags":      []byte{0x05},
+		"attrs":      []byte(`{"color":"red"}`),
+		"created_at": []byte("2020-01-02 03:04:05"),
+		"extra":      []byte("x"),
+	})
+	want := map[string]interface{}{
+		"id":         int64(1),
+		"price":      "9.90",
+		"status":     "paid",
+		"flags":      "0101",
+		"attrs":      json.RawMessage(`{"color":"red"}`),
+		"created_at": "2020-01-02T03:04:05Z",
+		// 表结构中没有的字段按字符串输出
+		"extra": "x",
+	}
+	if !reflect.DeepEqual(row, want) {
+		t.Errorf("encodeRow = %+v, want %+v", row, want)
+	}
+
+	// 没有表结构时只转换 []byte
+	a = &Assembler{}
+	if value := a.encode(nil, nil, "flags", []byte("1")); value != "1" {
+		t.Errorf("encode without table = %v, want 1", value)
+	}
+}
+
+type testTable struct {
+	names []string
+	rows  [][]interface{}
+}
+
+var selectPattern = regexp.MustCompile("^SELECT (.+) FROM `(\\w+)`\\.`(\\w+)` WHERE `(\\w+)` IN")
+
+// testSource 按 SELECT ... WHERE column IN (...) 过滤内存中的表，返回值与回源查询一样为 FieldValue
+type testSource struct {
+	tables  map[string]testTable
+	queries []string
+}
+
+func (s *testSource) execute(cmd string, args ...interface{}) (*mysql.Result, error) {
+	s.queries = append(s.queries, cmd)
+	match := selectPattern.FindStringSubmatch(cmd)
+	if match == nil {
+		return nil, fmt.Errorf("unexpected query %s", cmd)
+	}
+	table := s.tables[match[2]+"."+match[3]]
+	selected := make([]int, 0, len(table.names))
+	for i, name := range table.names {
+		if match[1] == "*" || match[1] == "`"+name+"`" {
+			selected = append(selected, i)
+		}
+	}
+	names := make([]string, 0, len(selected))
+	for _, i := range selected {
+		names = append(names, table.names[i])
+	}
+	column := -1
+	for i, name := range table.names {
+		if name == match[4] {
+			column = i
+		}
+	}
+
+	values := make([][]interface{}, 0)
+	for _, row := range table.rows {
+		for _, arg := range args {
+			if sink.StringValue(row[column]) != arg {
+				continue
+			}
+			projected := make([]interface{}, 0, len(selected))
+			for _, i := range selected {
+				projected = append(projected, row[i])
+			}
+			values = append(values, projected)
+		}
+	}
+	rs, err := mysql.BuildSimpleTextResultset(names, values)
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range rs.RowDatas {
+		row, err := data.ParseText(rs.Fields, nil)
+		if err != nil {
+			return nil, err
+		}
+		rs.Values = append(rs.Values, row)
+	}
+	return &mysql.Result{Resultset: rs}, nil
+}
+
+func newTestAssembler() (*Assembler, *testSource) {
+	src := &testSource{tables: map[string]testTable{
+		"shop.order": {
+			names: []string{"id", "customer_id"},
+			rows:  [][]interface{}{{int64(1), int64(7)}, {int64(2), int64(8)}, {int64(3), int64(7)}},
+		},
+		"shop.order_item": {
+			names: []string{"id", "order_id", "sku"},
+			rows:  [][]interface{}{{int64(10), int64(1), "a"}, {int64(11), int64(1), "b"}, {int64(12), int64(3), "c"}},
+		},
+		"shop.customer": {
+			names: []string{"id", "name"},
+			rows:  [][]interface{}{{int64(7), "tom"}, {int64(8), "amy"}},
+		},
+	}}
+	a := &Assembler{
+		Enabled: true,
+		Documents: []Document{{
+			Schema:    "shop",
+			Table:     "order",
+			Relations: []string{"items:order_item:order_id:id:many", "customer:customer:id:customer_id:one"},
+		}},
+		execute:   src.execute,
+		batchSize: 1,
+	}
+	a.SetDefaults()
+	return a, src
+}
+
+func TestAffectedKeys(t *testing.T) {
+	a, src := newTestAssembler()
+	requests := []*models.RowRequest{
+		// 子表的关联字段即根表主键，修改前后的订单都需要重建
+		{
+			Schema:  "shop",
+			Name:    "order_item",
+			Action:  canal.UpdateAction,
+			Columns: []models.Column{{Name: "id"}, {Name: "order_id"}},
+			OldRows: []interface{}{int64(10), int64(1)},
+			NewRows: []interface{}{int64(10), int64(2)},
+		},
+		{
+			Schema:  "shop",
+			Name:    "order",
+			Action:  canal.InsertAction,
+			Columns: []models.Column{{Name: "id"}, {Name: "customer_id"}},
+			NewRows: []interface{}{json.Number("5"), json.Number("8")},
+		},
+		// 父表通过根表的 customer_id 关联，回源查询受影响的订单
+		{
+			Schema:  "shop",
+			Name:    "customer",
+			Action:  canal.UpdateAction,
+			Columns: []models.Column{{Name: "id"}, {Name: "name"}},
+			OldRows: []interface{}{int64(7), "tom"},
+			NewRows: []interface{}{int64(7), "jerry"},
+		},
+		{Schema: "shop", Name: "coupon", Action: canal.InsertAction, Columns: []models.Column{{Name: "id"}}, NewRows: []interface{}{int64(1)}},
+	}
+	keys, err := a.affectedKeys(&a.Documents[0], requests)
+	if err != nil {
+		t.Fatalf("affectedKeys err:%s", err.Error())
+	}
+	if want := []string{"1", "2", "5", "3"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("affectedKeys = %v, want %v", keys, want)
+	}
+	want := []string{"SELECT `id` FROM `shop`.`order` WHERE `customer_id` IN (?)"}
+	if !reflect.DeepEqual(src.queries, want) {
+		t.Errorf("queries = %v, want %v", src.queries, want)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	a, src := newTestAssembler()
+	requests, err := a.assemble(nil, &a.Documents[0], []string{"1", "2", "9"})
+	if err != nil {
+		t.Fatalf("assemble err:%s", err.Error())
+	}
+	if len(requests) != 3 {
+		t.Fatalf("assemble = %d requests, want 3", len(requests))
+	}
+
+	columns := []string{"id", "customer_id", "items", "customer"}
+	rows := [][]interface{}{
+		{
+			int64(1), int64(7),
+			[]map[string]interface{}{
+				{"id": int64(10), "order_id": int64(1), "sku": "a"},
+				{"id": int64(11), "order_id": int64(1), "sku": "b"},
+			},
+			map[string]interface{}{"id": int64(7), "name": "tom"},
+		},
+		{int64(2), int64(8), []map[string]interface{}{}, map[string]interface{}{"id": int64(8), "name": "amy"}},
+	}
+	for i, want := range rows {
+		req := requests[i]
+		names := make([]string, len(req.Columns))
+		for j := range req.Columns {
+			names[j] = req.Columns[j].Name
+		}
+		if req.Action != canal.InsertAction || !reflect.DeepEqual(names, columns) {
+			t.Errorf("document %d = %s %v, want insert %v", i, req.Action, names, columns)
+		}
+		if !reflect.DeepEqual(req.NewRows, want) {
+			t.Errorf("document %d rows = %#v, want %#v", i, req.NewRows, want)
+		}
+	}
+	// 根表中已不存在的订单发送 delete
+	if req := requests[2]; req.Action != canal.DeleteAction || !reflect.DeepEqual(req.OldRows, []interface{}{"9"}) {
+		t.Errorf("document 9 = %s %v, want delete [9]", req.Action, req.OldRows)
+	}
+	// 按回源批量大小分批查询
+	if len(src.queries) != 3+2+2 {
+		t.Errorf("queries = %d, want 7", len(src.queries))
+	}
+}
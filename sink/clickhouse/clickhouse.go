@@ -0,0 +1,321 @@
+package clickhouse
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	EngineReplacing  = "ReplacingMergeTree"
+	EngineCollapsing = "CollapsingMergeTree"
+)
+
+// ClickHouse 通过 HTTP 接口以 JSONEachRow 按表批量写入，每行附带版本号与删除标记，
+// update/delete 均以追加新行的方式表达，由 ReplacingMergeTree/CollapsingMergeTree 合并
+type ClickHouse struct {
+	Enabled  bool   `env:""`
+	Addr     string `env:""`
+	User     string `env:""`
+	Password string `env:""`
+	// 默认目标表模板，可在规则中通过 ClickHouseTable 覆盖
+	Table string `env:""`
+	// ReplacingMergeTree/CollapsingMergeTree
+	Engine        string `env:""`
+	VersionColumn string `env:""`
+	DeletedColumn string `env:""`
+	// CollapsingMergeTree 使用的符号列
+	SignColumn string `env:""`
+	// 目标表不存在时按源表字段生成 DDL 并创建
+	CreateTable bool `env:""`
+	// 单次 INSERT 的最大行数
+	BatchSize int `env:""`
+	TimeoutMs int `env:""`
+
+	client *http.Client
+	mu     sync.Mutex
+	tables map[string]bool
+}
+
+func (c *ClickHouse) SetDefaults() {
+	if c.Addr == "" {
+		c.Addr = "http://127.0.0.1:8123"
+	}
+	if c.Table == "" {
+		c.Table = "{{schema}}.{{table}}"
+	}
+	if c.Engine == "" {
+		c.Engine = EngineReplacing
+	}
+	if c.VersionColumn == "" {
+		c.VersionColumn = "_version"
+	}
+	if c.DeletedColumn == "" {
+		c.DeletedColumn = "is_deleted"
+	}
+	if c.SignColumn == "" {
+		c.SignColumn = "_sign"
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = 10000
+	}
+	if c.TimeoutMs == 0 {
+		c.TimeoutMs = 60000
+	}
+}
+
+func (c *ClickHouse) Init() error {
+	if !c.Enabled || c.client != nil {
+		return nil
+	}
+	if c.Engine != EngineReplacing && c.Engine != EngineCollapsing {
+		return fmt.Errorf("unsupported clickhouse engine %s", c.Engine)
+	}
+	if c.BatchSize <= 0 {
+		return errors.New("clickhouse BatchSize must be greater than 0")
+	}
+	c.client = &http.Client{Timeout: time.Duration(c.TimeoutMs) * time.Millisecond}
+	c.tables = make(map[string]bool)
+	return nil
+}
+
+func (c *ClickHouse) String() string { return "clickhouse" }
+
+func (c *ClickHouse) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
+func (c *ClickHouse) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	tables := make([]string, 0)
+	batches := make(map[string]*batch)
+	for _, req := range requests {
+		table := c.Table
+		if r := rules.Find(req.Schema, req.Name); r != nil && r.ClickHouseTable != "" {
+			table = r.ClickHouseTable
+		}
+		table = sink.Render(table, req)
+		if c.CreateTable {
+			if err := c.ensureTable(table, req); err != nil {
+				return err
+			}
+		}
+		b, ok := batches[table]
+		if !ok {
+			b = &batch{}
+			batches[table] = b
+			tables = append(tables, table)
+		}
+		for _, row := range c.rows(req) {
+			data, err := json.Marshal(row)
+			if err != nil {
+				return err
+			}
+			b.body.Write(data)
+			b.body.WriteByte('\n')
+			b.rows++
+			// 同一张表的行按版本号合并，提前写入不影响结果
+			if b.rows >= c.BatchSize {
+				if err := c.insert(table, b); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, table := range tables {
+		if err := c.insert(table, batches[table]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type batch struct {
+	body bytes.Buffer
+	rows int
+}
+
+// insert 写入后清空 batch
+func (c *ClickHouse) insert(table string, b *batch) error {
+	if b.rows == 0 {
+		return nil
+	}
+	query := fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", quoteTable(table))
+	if err := c.exec(query, b.body.Bytes()); err != nil {
+		logrus.Errorf("clickhouse insert %s err:%s", table, err.Error())
+		return err
+	}
+	b.body.Reset()
+	b.rows = 0
+	return nil
+}
+
+// rows 将一条变更转为需要追加的行
+// ReplacingMergeTree: insert/update 写入新行，delete 写入 is_deleted=1 的旧行，主键变化时旧主键同样标记删除
+// CollapsingMergeTree: 旧行以 sign=-1 抵消，新行 sign=1
+func (c *ClickHouse) rows(req *models.RowRequest) []map[string]interface{} {
+	version := req.Version()
+	rows := make([]map[string]interface{}, 0, 2)
+	if req.OldRows != nil {
+		deleted := req.Action == canal.DeleteAction || primaryKeyChanged(req)
+		if deleted || c.Engine == EngineCollapsing {
+			row := c.row(req, req.OldRows)
+			row[c.VersionColumn] = version
+			row[c.DeletedColumn] = boolToInt(deleted)
+			if c.Engine == EngineCollapsing {
+				row[c.SignColumn] = -1
+			}
+			rows = append(rows, row)
+		}
+	}
+	if req.NewRows != nil {
+		row := c.row(req, req.NewRows)
+		row[c.VersionColumn] = version
+		row[c.DeletedColumn] = 0
+		if c.Engine == EngineCollapsing {
+			row[c.SignColumn] = 1
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func (c *ClickHouse) row(req *models.RowRequest, values []interface{}) map[string]interface{} {
+	row := make(map[string]interface{}, len(req.Columns)+3)
+	for i, column := range req.Columns {
+		if i < len(values) {
+			row[column.Name] = values[i]
+		}
+	}
+	return row
+}
+
+func (c *ClickHouse) ensureTable(table string, req *models.RowRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tables[table] {
+		return nil
+	}
+	ddl := c.DDL(table, req)
+	if err := c.exec(ddl, nil); err != nil {
+		logrus.Errorf("clickhouse create table %s err:%s", table, err.Error())
+		return err
+	}
+	logrus.Infof("clickhouse ensure table %s", table)
+	c.tables[table] = true
+	return nil
+}
+
+// DDL 按源表字段生成建表语句，主键作为排序键
+func (c *ClickHouse) DDL(table string, req *models.RowRequest) string {
+	definitions := make([]string, 0, len(req.Columns)+3)
+	for _, column := range req.Columns {
+		t := columnType(column)
+		if !containsString(req.PrimaryKeys, column.Name) {
+			t = "Nullable(" + t + ")"
+		}
+		definitions = append(definitions, quoteName(column.Name)+" "+t)
+	}
+	definitions = append(definitions,
+		quoteName(c.VersionColumn)+" UInt64",
+		quoteName(c.DeletedColumn)+" UInt8")
+
+	// 带 is_deleted 参数时，合并及 FINAL 查询会去掉最新版本为删除的行
+	engine := fmt.Sprintf("ReplacingMergeTree(%s, %s)", quoteName(c.VersionColumn), quoteName(c.DeletedColumn))
+	if c.Engine == EngineCollapsing {
+		definitions = append(definitions, quoteName(c.SignColumn)+" Int8")
+		engine = fmt.Sprintf("CollapsingMergeTree(%s)", quoteName(c.SignColumn))
+	}
+	orderBy := "tuple()"
+	if len(req.PrimaryKeys) > 0 {
+		keys := make([]string, len(req.PrimaryKeys))
+		for i := range req.PrimaryKeys {
+			keys[i] = quoteName(req.PrimaryKeys[i])
+		}
+		orderBy = "(" + strings.Join(keys, ", ") + ")"
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n) ENGINE = %s ORDER BY %s",
+		quoteTable(table), strings.Join(definitions, ",\n\t"), engine, orderBy)
+}
+
+func (c *ClickHouse) exec(query string, body []byte) error {
+	params := url.Values{}
+	params.Set("query", query)
+	// DATETIME 以 RFC3339 输出，需要宽松解析
+	params.Set("date_time_input_format", "best_effort")
+	params.Set("input_format_skip_unknown_fields", "1")
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(c.Addr, "/")+"/?"+params.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if c.User != "" {
+		req.Header.Set("X-ClickHouse-User", c.User)
+		req.Header.Set("X-ClickHouse-Key", c.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("clickhouse status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+func primaryKeyChanged(req *models.RowRequest) bool {
+	if req.Action != canal.UpdateAction {
+		return false
+	}
+	for _, pk := range req.PrimaryKeys {
+		if sink.StringValue(req.OldValue(pk)) != sink.StringValue(req.NewValue(pk)) {
+			return true
+		}
+	}
+	return false
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func containsString(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteTable 支持 database.table 形式
+func quoteTable(name string) string {
+	parts := strings.SplitN(name, ".", 2)
+	for i := range parts {
+		parts[i] = quoteName(parts[i])
+	}
+	return strings.Join(parts, ".")
+}
+
+func quoteName(name string) string {
+	return "`" + strings.Replace(name, "`", "\\`", -1) + "`"
+}
+
+func (c *ClickHouse) Close() {}
@@ -0,0 +1,68 @@
+package clickhouse
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+)
+
+func newRequest(id int) *models.RowRequest {
+	return &models.RowRequest{
+		Schema:      "db",
+		Name:        "user",
+		Action:      "insert",
+		Columns:     []models.Column{{Name: "id", RawType: "int"}, {Name: "name", RawType: "varchar(32)"}},
+		PrimaryKeys: []string{"id"},
+		NewRows:     []interface{}{id, "name"},
+	}
+}
+
+func TestDDL(t *testing.T) {
+	c := &ClickHouse{}
+	c.SetDefaults()
+	ddl := c.DDL("db.user", newRequest(1))
+	want := "ENGINE = ReplacingMergeTree(`_version`, `is_deleted`) ORDER BY (`id`)"
+	if !strings.Contains(ddl, want) {
+		t.Errorf("DDL = %s, want contains %s", ddl, want)
+	}
+	if !strings.Contains(ddl, "`is_deleted` UInt8") {
+		t.Errorf("DDL = %s, want is_deleted UInt8", ddl)
+	}
+}
+
+func TestWriteBatchSize(t *testing.T) {
+	var mu sync.Mutex
+	var inserts []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		inserts = append(inserts, strings.Count(string(data), "\n"))
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	c := &ClickHouse{Enabled: true, Addr: server.URL, BatchSize: 2}
+	c.SetDefaults()
+	if err := c.Init(); err != nil {
+		t.Fatalf("init err:%s", err.Error())
+	}
+	requests := []*models.RowRequest{newRequest(1), newRequest(2), newRequest(3), newRequest(4), newRequest(5)}
+	if err := c.Write(nil, requests); err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	want := []int{2, 2, 1}
+	if len(inserts) != len(want) {
+		t.Fatalf("inserts = %v, want %v", inserts, want)
+	}
+	for i := range want {
+		if inserts[i] != want[i] {
+			t.Errorf("inserts = %v, want %v", inserts, want)
+			break
+		}
+	}
+}
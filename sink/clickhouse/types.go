@@ -0,0 +1,58 @@
+package clickhouse
+
+import (
+	"strings"
+
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/models"
+)
+
+var integerTypes = map[string]string{
+	"tinyint":   "Int8",
+	"smallint":  "Int16",
+	"mediumint": "Int32",
+	"int":       "Int32",
+	"integer":   "Int32",
+	"bigint":    "Int64",
+}
+
+// columnType 将 MySQL 字段类型转为 ClickHouse 类型，无法识别的类型使用 String
+func columnType(column models.Column) string {
+	rawType := strings.ToLower(column.RawType)
+	base := rawType
+	args := ""
+	if i := strings.Index(rawType, "("); i >= 0 {
+		base = rawType[:i]
+		if j := strings.Index(rawType, ")"); j > i {
+			args = rawType[i : j+1]
+		}
+	} else if i := strings.Index(rawType, " "); i >= 0 {
+		base = rawType[:i]
+	}
+
+	if t, ok := integerTypes[base]; ok {
+		if strings.Contains(rawType, "unsigned") {
+			return "U" + t
+		}
+		return t
+	}
+	switch base {
+	case "float":
+		return "Float32"
+	case "double", "real":
+		return "Float64"
+	case "decimal", "numeric":
+		if args == "" {
+			args = "(10, 0)"
+		}
+		return "Decimal" + args
+	case "year":
+		return "UInt16"
+	case "date":
+		return "Date"
+	}
+	if column.Type == encoder.ColumnTypeDatetime || column.Type == encoder.ColumnTypeTimestamp {
+		return "DateTime64(6)"
+	}
+	return "String"
+}
@@ -0,0 +1,278 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/sirupsen/logrus"
+)
+
+// Elasticsearch 兼容 Elasticsearch 7.x 与 OpenSearch，通过 _bulk 接口写入
+type Elasticsearch struct {
+	Enabled  bool     `env:""`
+	Addrs    []string `env:""`
+	Username string   `env:""`
+	Password string   `env:""`
+	// 默认索引模板，可在规则中通过 ESIndex 覆盖
+	Index string `env:""`
+	// 索引不存在时按规则中的 ESMapping 创建
+	CreateIndex bool `env:""`
+	TimeoutMs   int  `env:""`
+
+	client  *http.Client
+	mu      sync.Mutex
+	indices map[string]bool
+}
+
+type bulkResponse struct {
+	Errors bool                                `json:"errors"`
+	Items  []map[string]bulkResponseItemResult `json:"items"`
+}
+
+type bulkResponseItemResult struct {
+	Index  string          `json:"_index"`
+	ID     string          `json:"_id"`
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+func (e *Elasticsearch) SetDefaults() {
+	if len(e.Addrs) == 0 {
+		e.Addrs = []string{"http://127.0.0.1:9200"}
+	}
+	if e.Index == "" {
+		e.Index = "{{schema}}_{{table}}"
+	}
+	if e.TimeoutMs == 0 {
+		e.TimeoutMs = 30000
+	}
+}
+
+func (e *Elasticsearch) Init() error {
+	if !e.Enabled || e.client != nil {
+		return nil
+	}
+	e.client = &http.Client{Timeout: time.Duration(e.TimeoutMs) * time.Millisecond}
+	e.indices = make(map[string]bool)
+	return nil
+}
+
+func (e *Elasticsearch) String() string { return "elasticsearch" }
+
+func (e *Elasticsearch) IsEnabled() bool {
+	return e != nil && e.Enabled
+}
+
+func (e *Elasticsearch) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	var body bytes.Buffer
+	count := 0
+	for _, req := range requests {
+		r := rules.Find(req.Schema, req.Name)
+		index := e.Index
+		if r != nil && r.ESIndex != "" {
+			index = r.ESIndex
+		}
+		index = strings.ToLower(sink.Render(index, req))
+		if e.CreateIndex {
+			if err := e.ensureIndex(index, r); err != nil {
+				return err
+			}
+		}
+		id := documentID(r, req)
+		if id == "" {
+			logrus.Warnf("elasticsearch skip %s.%s without primary key", req.Schema, req.Name)
+			continue
+		}
+		// 主键或文档 ID 相关字段修改后删除旧文档
+		if req.Action == canal.UpdateAction {
+			if oldID := documentID(r, sink.Before(req)); oldID != "" && oldID != id {
+				if err := writeLine(&body, versionedAction("delete", index, oldID, req)); err != nil {
+					return err
+				}
+				count++
+			}
+		}
+		if err := e.writeAction(&body, index, id, r, req); err != nil {
+			return err
+		}
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+	return e.bulk(&body)
+}
+
+func documentID(r *rule.Rule, req *models.RowRequest) string {
+	if r != nil && r.ESIDTemplate != "" {
+		return sink.Render(r.ESIDTemplate, req)
+	}
+	return sink.PrimaryKey(req)
+}
+
+// writeAction insert/update -> index，delete -> delete，均以 binlog 位置作为外部版本号，
+// 重放或乱序到达的旧事件返回版本冲突而不会覆盖新数据
+func (e *Elasticsearch) writeAction(body *bytes.Buffer, index, id string, r *rule.Rule, req *models.RowRequest) error {
+	if req.Action == canal.DeleteAction {
+		return writeLine(body, versionedAction("delete", index, id, req))
+	}
+	if err := writeLine(body, versionedAction("index", index, id, req)); err != nil {
+		return err
+	}
+	return writeLine(body, Document(r, req))
+}
+
+// versionedAction 没有 binlog 位置时不指定版本号
+func versionedAction(action, index, id string, req *models.RowRequest) map[string]interface{} {
+	meta := map[string]interface{}{
+		"_index": index,
+		"_id":    id,
+	}
+	if version := req.Version(); version > 0 {
+		meta["version"] = version
+		meta["version_type"] = "external"
+	}
+	return map[string]interface{}{action: meta}
+}
+
+// Document 按规则中的 ESFieldMappings 转换字段名，映射为 - 的字段不写入
+func Document(r *rule.Rule, req *models.RowRequest) map[string]interface{} {
+	row := req.Row()
+	doc := make(map[string]interface{}, len(req.Columns))
+	for i := range req.Columns {
+		field := r.ESField(req.Columns[i].Name)
+		if field == "-" {
+			continue
+		}
+		var value interface{}
+		if i < len(row) {
+			value = row[i]
+		}
+		doc[field] = value
+	}
+	return doc
+}
+
+func writeLine(body *bytes.Buffer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	body.Write(data)
+	body.WriteByte('\n')
+	return nil
+}
+
+func (e *Elasticsearch) bulk(body *bytes.Buffer) error {
+	resp, err := e.do(http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("elasticsearch bulk status %d: %s", resp.StatusCode, string(data))
+	}
+
+	var result bulkResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+	if !result.Errors {
+		return nil
+	}
+	for _, item := range result.Items {
+		for action, res := range item {
+			switch {
+			case res.Status/100 == 2:
+			case res.Status == http.StatusNotFound && action == "delete":
+				// 文档已不存在
+			case res.Status == http.StatusConflict:
+				// 已写入更新的版本
+				logrus.Warnf("elasticsearch %s %s/%s version conflict:%s", action, res.Index, res.ID, string(res.Error))
+			default:
+				return fmt.Errorf("elasticsearch %s %s/%s status %d: %s", action, res.Index, res.ID, res.Status, string(res.Error))
+			}
+		}
+	}
+	return nil
+}
+
+func (e *Elasticsearch) ensureIndex(index string, r *rule.Rule) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.indices[index] {
+		return nil
+	}
+
+	resp, err := e.do(http.MethodHead, "/"+index, "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		var body []byte
+		if r != nil && r.ESMapping != "" {
+			body = []byte(`{"mappings":` + r.ESMapping + `}`)
+		}
+		resp, err = e.do(http.MethodPut, "/"+index, "application/json", body)
+		if err != nil {
+			return err
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		// 其他实例可能已创建
+		if resp.StatusCode/100 != 2 && !bytes.Contains(data, []byte("resource_already_exists_exception")) {
+			return fmt.Errorf("elasticsearch create index %s status %d: %s", index, resp.StatusCode, string(data))
+		}
+		logrus.Infof("elasticsearch create index %s", index)
+	} else if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("elasticsearch check index %s status %d", index, resp.StatusCode)
+	}
+	e.indices[index] = true
+	return nil
+}
+
+// do 依次尝试各个地址，直到请求成功发出
+func (e *Elasticsearch) do(method, path, contentType string, body []byte) (*http.Response, error) {
+	var lastErr error
+	for _, addr := range e.Addrs {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, strings.TrimSuffix(addr, "/")+path, reader)
+		if err != nil {
+			return nil, err
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if e.Username != "" {
+			req.SetBasicAuth(e.Username, e.Password)
+		}
+		resp, err := e.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+func (e *Elasticsearch) Close() {}
@@ -0,0 +1,204 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/siddontang/go-mysql/canal"
+)
+
+// fakeServer 记录 _bulk 请求，按 response 返回结果
+type fakeServer struct {
+	mu       sync.Mutex
+	bulks    [][]map[string]interface{}
+	created  []string
+	indices  map[string]bool
+	response func(lines []map[string]interface{}) (int, string)
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.URL.Path == "/_bulk":
+		if r.Header.Get("Content-Type") != "application/x-ndjson" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var lines []map[string]interface{}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var line map[string]interface{}
+			decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+			decoder.UseNumber()
+			if err := decoder.Decode(&line); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			lines = append(lines, line)
+		}
+		f.bulks = append(f.bulks, lines)
+		status, body := http.StatusOK, `{"errors":false,"items":[]}`
+		if f.response != nil {
+			status, body = f.response(lines)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	case r.Method == http.MethodHead:
+		if !f.indices[strings.TrimPrefix(r.URL.Path, "/")] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		f.created = append(f.created, r.URL.Path+" "+string(body))
+		f.indices[strings.TrimPrefix(r.URL.Path, "/")] = true
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestElasticsearch(t *testing.T, f *fakeServer) *Elasticsearch {
+	f.indices = map[string]bool{}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	e := &Elasticsearch{Enabled: true, Addrs: []string{"http://127.0.0.1:1", server.URL}}
+	e.SetDefaults()
+	if err := e.Init(); err != nil {
+		t.Fatalf("init err:%s", err.Error())
+	}
+	return e
+}
+
+func newRequest(action string, pos uint32, oldRow, newRow []interface{}) *models.RowRequest {
+	return &models.RowRequest{
+		Schema:      "db",
+		Name:        "User",
+		Action:      action,
+		Columns:     []models.Column{{Name: "id"}, {Name: "name"}},
+		PrimaryKeys: []string{"id"},
+		OldRows:     oldRow,
+		NewRows:     newRow,
+		LogName:     "mysql-bin.000002",
+		LogPos:      pos,
+	}
+}
+
+func actionMeta(t *testing.T, line map[string]interface{}, action string) map[string]interface{} {
+	meta, ok := line[action].(map[string]interface{})
+	if !ok {
+		t.Fatalf("line %v is not %s action", line, action)
+	}
+	return meta
+}
+
+func TestWriteExternalVersion(t *testing.T) {
+	f := &fakeServer{}
+	e := newTestElasticsearch(t, f)
+	requests := []*models.RowRequest{
+		newRequest(canal.InsertAction, 100, nil, []interface{}{1, "a"}),
+		newRequest(canal.UpdateAction, 200, []interface{}{1, "a"}, []interface{}{1, "b"}),
+		newRequest(canal.DeleteAction, 300, []interface{}{1, "b"}, nil),
+	}
+	rules := rule.Rules{{Schema: "db", Table: "User", ESFieldMappings: []string{"name:title"}}}
+	if err := e.Write(rules, requests); err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	if len(f.bulks) != 1 || len(f.bulks[0]) != 5 {
+		t.Fatalf("bulks = %v", f.bulks)
+	}
+	lines := f.bulks[0]
+	for i, c := range []struct {
+		line   int
+		action string
+		req    *models.RowRequest
+	}{{0, "index", requests[0]}, {2, "index", requests[1]}, {4, "delete", requests[2]}} {
+		meta := actionMeta(t, lines[c.line], c.action)
+		if meta["_index"] != "db_user" || meta["_id"] != "1" || meta["version_type"] != "external" {
+			t.Errorf("action %d meta = %v", i, meta)
+		}
+		if meta["version"] != json.Number(formatUint(c.req.Version())) {
+			t.Errorf("action %d version = %v, want %d", i, meta["version"], c.req.Version())
+		}
+	}
+	if lines[3]["title"] != "b" || lines[3]["name"] != nil {
+		t.Errorf("update document = %v", lines[3])
+	}
+}
+
+func TestWriteChangedPrimaryKey(t *testing.T) {
+	f := &fakeServer{}
+	e := newTestElasticsearch(t, f)
+	req := newRequest(canal.UpdateAction, 100, []interface{}{1, "a"}, []interface{}{2, "a"})
+	if err := e.Write(nil, []*models.RowRequest{req}); err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	lines := f.bulks[0]
+	if len(lines) != 3 {
+		t.Fatalf("lines = %v", lines)
+	}
+	if meta := actionMeta(t, lines[0], "delete"); meta["_id"] != "1" {
+		t.Errorf("delete old document meta = %v", meta)
+	}
+	if meta := actionMeta(t, lines[1], "index"); meta["_id"] != "2" {
+		t.Errorf("index new document meta = %v", meta)
+	}
+}
+
+func TestWriteBulkErrors(t *testing.T) {
+	f := &fakeServer{}
+	e := newTestElasticsearch(t, f)
+	requests := []*models.RowRequest{newRequest(canal.InsertAction, 100, nil, []interface{}{1, "a"})}
+
+	// 版本冲突说明已写入更新的数据，视为成功
+	f.response = func([]map[string]interface{}) (int, string) {
+		return http.StatusOK, `{"errors":true,"items":[{"index":{"_index":"db_user","_id":"1","status":409,"error":{"type":"version_conflict_engine_exception"}}}]}`
+	}
+	if err := e.Write(nil, requests); err != nil {
+		t.Errorf("version conflict should be ignored, err:%s", err.Error())
+	}
+
+	f.response = func([]map[string]interface{}) (int, string) {
+		return http.StatusOK, `{"errors":true,"items":[{"index":{"_index":"db_user","_id":"1","status":400,"error":{"type":"mapper_parsing_exception"}}}]}`
+	}
+	if err := e.Write(nil, requests); err == nil {
+		t.Errorf("item error should be returned")
+	}
+
+	f.response = func([]map[string]interface{}) (int, string) {
+		return http.StatusTooManyRequests, `{"error":"es_rejected_execution_exception"}`
+	}
+	if err := e.Write(nil, requests); err == nil {
+		t.Errorf("bulk status error should be returned")
+	}
+}
+
+func TestEnsureIndex(t *testing.T) {
+	f := &fakeServer{}
+	e := newTestElasticsearch(t, f)
+	e.CreateIndex = true
+	rules := rule.Rules{{Schema: "db", Table: "User", ESMapping: `{"properties":{"name":{"type":"keyword"}}}`}}
+	requests := []*models.RowRequest{newRequest(canal.InsertAction, 100, nil, []interface{}{1, "a"})}
+	for i := 0; i < 2; i++ {
+		if err := e.Write(rules, requests); err != nil {
+			t.Fatalf("Write err:%s", err.Error())
+		}
+	}
+	want := `/db_user {"mappings":{"properties":{"name":{"type":"keyword"}}}}`
+	if len(f.created) != 1 || f.created[0] != want {
+		t.Errorf("created = %v, want [%s]", f.created, want)
+	}
+}
+
+func formatUint(v uint64) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,225 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/JieWaZi/transfer-mysql/storage"
+	"github.com/JieWaZi/transfer-mysql/utils"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+)
+
+// RetentionCursor feed 在游标中使用的名称，队列中至少保留该游标之后的事件
+const RetentionCursor = "_feed"
+
+var ErrCursorOutOfRange = errors.New("cursor out of range")
+
+// Feed 直接读取持久化的 RowRequest 队列，通过 gRPC 向订阅方推送，订阅方按队列序号从游标处继续消费。
+// 每个订阅独立读取，慢订阅不会阻塞写入，游标之后的事件已被删除或游标超过最后的序号时订阅被中断
+type Feed struct {
+	Enabled bool   `env:""`
+	Addr    string `env:""`
+	// 队列中至少保留的最近事件数
+	Retention int `env:""`
+	// 单个订阅未确认事件数上限，订阅请求未指定时使用
+	Window         int `env:""`
+	MaxSubscribers int `env:""`
+
+	boltStorage *storage.BoltStorage
+	rules       rule.Rules
+	mu          sync.Mutex
+	// 有新事件时关闭并替换，用于唤醒等待中的订阅
+	notify      chan struct{}
+	subscribers atomic.Int32
+	server      *grpc.Server
+}
+
+func (f *Feed) SetDefaults() {
+	if f.Addr == "" {
+		f.Addr = ":9091"
+	}
+	if f.Retention == 0 {
+		f.Retention = 10000
+	}
+	if f.Window == 0 {
+		f.Window = 256
+	}
+	if f.MaxSubscribers == 0 {
+		f.MaxSubscribers = 64
+	}
+}
+
+func (f *Feed) Init() error {
+	if !f.Enabled || f.server != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", f.Addr)
+	if err != nil {
+		logrus.Errorf("feed listen %s err:%s", f.Addr, err.Error())
+		return err
+	}
+	f.notify = make(chan struct{})
+	f.server = grpc.NewServer()
+	RegisterChangeFeedServer(f.server, f)
+	go func() {
+		if err := f.server.Serve(listener); err != nil {
+			logrus.Errorf("feed serve err:%s", err.Error())
+		}
+	}()
+	logrus.Infof("feed grpc server listen on %s", f.Addr)
+	return nil
+}
+
+// Bind 绑定任务使用的队列，没有保留游标时从队列中最早的事件开始注册
+func (f *Feed) Bind(boltStorage *storage.BoltStorage, rules rule.Rules) error {
+	_, ok, err := boltStorage.GetCursor(RetentionCursor)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		first, err := boltStorage.FirstRowRequestSeq()
+		if err != nil {
+			return err
+		}
+		cursor := uint64(0)
+		if first > 0 {
+			cursor = first - 1
+		} else if cursor, err = boltStorage.RowRequestSequence(); err != nil {
+			return err
+		}
+		if err := boltStorage.SetCursor(RetentionCursor, cursor); err != nil {
+			return err
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.boltStorage = boltStorage
+	f.rules = rules
+	return nil
+}
+
+func (f *Feed) String() string { return "feed" }
+
+func (f *Feed) IsEnabled() bool {
+	return f != nil && f.Enabled
+}
+
+func (f *Feed) ChangedColumns(r *rule.Rule) bool { return true }
+
+// Write 事件已在队列中，只需移动保留游标并唤醒订阅
+func (f *Feed) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	if len(requests) == 0 {
+		return nil
+	}
+	boltStorage := f.storage()
+	if boltStorage == nil {
+		return errors.New("feed is not bound to a queue")
+	}
+	last := requests[len(requests)-1].Seq
+	if last > uint64(f.Retention) {
+		cursor, _, err := boltStorage.GetCursor(RetentionCursor)
+		if err != nil {
+			return err
+		}
+		if retain := last - uint64(f.Retention); retain > cursor {
+			if err := boltStorage.SetCursor(RetentionCursor, retain); err != nil {
+				logrus.Errorf("feed set retention cursor err:%s", err.Error())
+				return err
+			}
+		}
+	}
+	f.mu.Lock()
+	close(f.notify)
+	f.notify = make(chan struct{})
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *Feed) storage() *storage.BoltStorage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.boltStorage
+}
+
+// after 返回游标之后最多 limit 个符合条件的事件、扫描到的位置及有新事件时关闭的 channel，
+// 游标为 0 时从队列中最早的事件开始，游标之后的事件已被删除或游标超过最后的序号时返回 ErrCursorOutOfRange
+func (f *Feed) after(cursor uint64, limit int, match func(*models.RowRequest) bool) ([]*models.RowRequest, uint64, <-chan struct{}, error) {
+	f.mu.Lock()
+	boltStorage, rules, notify := f.boltStorage, f.rules, f.notify
+	f.mu.Unlock()
+	if boltStorage == nil {
+		return nil, cursor, notify, errors.New("feed is not bound to a queue")
+	}
+
+	last, err := boltStorage.RowRequestSequence()
+	if err != nil {
+		return nil, cursor, notify, err
+	}
+	if cursor > last {
+		return nil, cursor, notify, fmt.Errorf("%w: %d is after the last sequence %d", ErrCursorOutOfRange, cursor, last)
+	}
+	if cursor == last {
+		return nil, cursor, notify, nil
+	}
+	first, err := boltStorage.FirstRowRequestSeq()
+	if err != nil {
+		return nil, cursor, notify, err
+	}
+	if cursor != 0 && (first == 0 || cursor+1 < first) {
+		return nil, cursor, notify, fmt.Errorf("%w: %d is older than the earliest retained sequence %d", ErrCursorOutOfRange, cursor, first)
+	}
+
+	var after []byte
+	if cursor > 0 {
+		after = utils.Uint64ToBytes(cursor)
+	}
+	keys, values, err := boltStorage.ListRowRequest(after, limit)
+	if err != nil {
+		return nil, cursor, notify, err
+	}
+	requests := make([]*models.RowRequest, 0, len(keys))
+	for i := range keys {
+		seq := utils.BytesToUint64(keys[i])
+		cursor = seq
+		req, err := decodeRowRequest(values[i])
+		if err != nil {
+			logrus.Errorf("feed unmarshal RowRequest seq:%d err:%s", seq, err.Error())
+			continue
+		}
+		req.Seq = seq
+		requests = append(requests, req)
+	}
+	requests = sink.OnlyChangedColumns(f, rules, requests)
+	matched := requests[:0]
+	for _, req := range requests {
+		if match == nil || match(req) {
+			matched = append(matched, req)
+		}
+	}
+	return matched, cursor, notify, nil
+}
+
+func decodeRowRequest(data []byte) (*models.RowRequest, error) {
+	var req models.RowRequest
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (f *Feed) Close() {
+	if f.server != nil {
+		f.server.Stop()
+	}
+}
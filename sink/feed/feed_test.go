@@ -0,0 +1,142 @@
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestFeed(t *testing.T, tables ...string) *Feed {
+	b := &storage.BoltStorage{BoltStoragePath: t.TempDir()}
+	b.SetDefaults()
+	if err := b.Init(); err != nil {
+		t.Fatalf("bolt init err:%s", err.Error())
+	}
+	t.Cleanup(func() { b.GetBoltStorage().Close() })
+	err := b.CreateBucketIfNotExists([]byte(b.RowRequestBucket), []byte(b.CursorBucket))
+	if err != nil {
+		t.Fatalf("create bucket err:%s", err.Error())
+	}
+	list := make([][]byte, 0, len(tables))
+	for _, table := range tables {
+		data, _ := json.Marshal(&models.RowRequest{Schema: "db", Name: table, Action: "insert"})
+		list = append(list, data)
+	}
+	if err := b.BatchAddRowRequest(list); err != nil {
+		t.Fatalf("add RowRequest err:%s", err.Error())
+	}
+
+	f := &Feed{Enabled: true, Retention: 2}
+	f.SetDefaults()
+	f.notify = make(chan struct{})
+	if err := f.Bind(b, nil); err != nil {
+		t.Fatalf("bind err:%s", err.Error())
+	}
+	return f
+}
+
+func seqs(requests []*models.RowRequest) []uint64 {
+	list := make([]uint64, len(requests))
+	for i := range requests {
+		list[i] = requests[i].Seq
+	}
+	return list
+}
+
+func TestAfter(t *testing.T) {
+	f := newTestFeed(t, "user", "order", "user", "order", "user")
+	events, next, _, err := f.after(0, 10, func(r *models.RowRequest) bool { return r.Name == "user" })
+	if err != nil {
+		t.Fatalf("after err:%s", err.Error())
+	}
+	if got := seqs(events); len(got) != 3 || got[0] != 1 || got[2] != 5 || next != 5 {
+		t.Errorf("after(0) = %v next %d, want [1 3 5] next 5", got, next)
+	}
+	events, next, _, err = f.after(1, 2, nil)
+	if err != nil {
+		t.Fatalf("after err:%s", err.Error())
+	}
+	if got := seqs(events); len(got) != 2 || got[0] != 2 || next != 3 {
+		t.Errorf("after(1) = %v next %d, want [2 3] next 3", got, next)
+	}
+	events, next, _, err = f.after(5, 10, nil)
+	if err != nil || len(events) != 0 || next != 5 {
+		t.Errorf("after(5) = %v next %d err %v, want no events", seqs(events), next, err)
+	}
+}
+
+func TestAfterOutOfRange(t *testing.T) {
+	f := newTestFeed(t, "user", "user", "user", "user", "user")
+	if _, _, _, err := f.after(6, 10, nil); !errors.Is(err, ErrCursorOutOfRange) {
+		t.Errorf("after(6) err = %v, want ErrCursorOutOfRange", err)
+	}
+
+	// 只保留最近 2 个事件，其他消费方均已提交
+	if err := f.Write(nil, []*models.RowRequest{{Seq: 5}}); err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	if cursor, _, _ := f.boltStorage.GetCursor(RetentionCursor); cursor != 3 {
+		t.Errorf("retention cursor = %d, want 3", cursor)
+	}
+	if _, _, _, err := f.after(1, 10, nil); !errors.Is(err, ErrCursorOutOfRange) {
+		t.Errorf("after(1) err = %v, want ErrCursorOutOfRange", err)
+	}
+	events, _, _, err := f.after(3, 10, nil)
+	if got := seqs(events); err != nil || len(got) != 2 || got[0] != 4 {
+		t.Errorf("after(3) = %v err %v, want [4 5]", got, err)
+	}
+	events, _, _, err = f.after(0, 10, nil)
+	if got := seqs(events); err != nil || len(got) != 2 || got[0] != 4 {
+		t.Errorf("after(0) = %v err %v, want [4 5]", got, err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	f := newTestFeed(t, "user", "order")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err:%s", err.Error())
+	}
+	f.server = grpc.NewServer()
+	RegisterChangeFeedServer(f.server, f)
+	go f.server.Serve(listener)
+	t.Cleanup(f.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("dial err:%s", err.Error())
+	}
+	defer conn.Close()
+
+	sub, err := Subscribe(ctx, conn, &SubscribeRequest{Tables: []string{"order"}})
+	if err != nil {
+		t.Fatalf("subscribe err:%s", err.Error())
+	}
+	e, err := sub.Recv()
+	if err != nil {
+		t.Fatalf("recv err:%s", err.Error())
+	}
+	if e.Seq != 2 || e.Name != "order" {
+		t.Errorf("event = %d %s, want 2 order", e.Seq, e.Name)
+	}
+	sub.Close()
+
+	sub, err = Subscribe(ctx, conn, &SubscribeRequest{Cursor: 10})
+	if err != nil {
+		t.Fatalf("subscribe err:%s", err.Error())
+	}
+	if _, err := sub.Recv(); status.Code(err) != codes.OutOfRange {
+		t.Errorf("recv err = %v, want OutOfRange", err)
+	}
+}
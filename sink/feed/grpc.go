@@ -0,0 +1,169 @@
+package feed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Subscribe 未确认事件数达到窗口后暂停发送，直到收到 Ack
+func (f *Feed) Subscribe(stream ChangeFeed_SubscribeServer) error {
+	if int(f.subscribers.Inc()) > f.MaxSubscribers {
+		f.subscribers.Dec()
+		return status.Errorf(codes.ResourceExhausted, "too many subscribers, max %d", f.MaxSubscribers)
+	}
+	defer f.subscribers.Dec()
+
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	window := int(req.Window)
+	if window <= 0 || window > f.Window {
+		window = f.Window
+	}
+	match := matcher(req)
+
+	ctx := stream.Context()
+	acks := make(chan uint64, 16)
+	go func() {
+		defer close(acks)
+		for {
+			ack, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			select {
+			case acks <- ack.Ack:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	cursor := req.Cursor
+	// 已发送未确认的序号
+	inflight := make([]uint64, 0, window)
+	for {
+		if len(inflight) >= window {
+			select {
+			case ack, ok := <-acks:
+				if !ok {
+					return nil
+				}
+				inflight = release(inflight, ack)
+			case <-ctx.Done():
+				return nil
+			}
+			continue
+		}
+
+		events, next, notify, err := f.after(cursor, window-len(inflight), match)
+		if errors.Is(err, ErrCursorOutOfRange) {
+			return status.Error(codes.OutOfRange, err.Error())
+		}
+		if err != nil {
+			logrus.Errorf("feed read cursor:%d err:%s", cursor, err.Error())
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		if next == cursor {
+			select {
+			case <-notify:
+			case ack, ok := <-acks:
+				if !ok {
+					return nil
+				}
+				inflight = release(inflight, ack)
+			case <-ctx.Done():
+				return nil
+			}
+			continue
+		}
+		for _, e := range events {
+			data, err := json.Marshal(e)
+			if err != nil {
+				logrus.Errorf("feed marshal seq:%d err:%s", e.Seq, err.Error())
+				return status.Error(codes.Internal, err.Error())
+			}
+			if err := stream.Send(&Event{Seq: e.Seq, Request: data}); err != nil {
+				logrus.Warnf("feed send err:%s", err.Error())
+				return err
+			}
+			inflight = append(inflight, e.Seq)
+		}
+		cursor = next
+	}
+}
+
+func release(inflight []uint64, ack uint64) []uint64 {
+	i := 0
+	for i < len(inflight) && inflight[i] <= ack {
+		i++
+	}
+	return append(inflight[:0], inflight[i:]...)
+}
+
+func matcher(req *SubscribeRequest) func(*models.RowRequest) bool {
+	return func(r *models.RowRequest) bool {
+		return contains(req.Schemas, r.Schema) && contains(req.Tables, r.Name) && contains(req.Actions, r.Action)
+	}
+}
+
+func contains(list []string, s string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription 客户端订阅，处理完事件后调用 Ack
+type Subscription struct {
+	stream ChangeFeed_SubscribeClient
+}
+
+// Subscribe 供 Go 客户端使用，其他语言按 feed.proto 生成客户端，Event.request 为 RowRequest 的 JSON
+func Subscribe(ctx context.Context, conn *grpc.ClientConn, req *SubscribeRequest) (*Subscription, error) {
+	stream, err := NewChangeFeedClient(conn).Subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(req); err != nil {
+		return nil, err
+	}
+	return &Subscription{stream: stream}, nil
+}
+
+func (s *Subscription) Recv() (*models.RowRequest, error) {
+	e, err := s.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	var req models.RowRequest
+	decoder := json.NewDecoder(bytes.NewReader(e.Request))
+	decoder.UseNumber()
+	if err := decoder.Decode(&req); err != nil {
+		return nil, err
+	}
+	req.Seq = e.Seq
+	return &req, nil
+}
+
+func (s *Subscription) Ack(seq uint64) error {
+	return s.stream.Send(&SubscribeRequest{Ack: seq})
+}
+
+func (s *Subscription) Close() error {
+	return s.stream.CloseSend()
+}
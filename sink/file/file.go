@@ -0,0 +1,302 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+
+	// FsyncNone 由操作系统决定落盘时机，FsyncRotate 关闭文件时 fsync，FsyncBatch 每批写入后 fsync
+	FsyncNone   = "none"
+	FsyncRotate = "rotate"
+	FsyncBatch  = "batch"
+
+	inProgressSuffix = ".inprogress"
+	manifestName     = "manifest.jsonl"
+)
+
+// File 按表写入滚动文件，文件关闭后记录到 manifest
+type File struct {
+	Enabled bool   `env:""`
+	Dir     string `env:""`
+	// jsonl/csv
+	Format string `env:""`
+	// none/gzip/zstd
+	Compression string `env:""`
+	// 文件达到大小或时长后滚动
+	MaxSizeMB     int `env:""`
+	MaxAgeSeconds int `env:""`
+	// none/rotate/batch
+	Fsync string `env:""`
+
+	mu       sync.Mutex
+	segments map[string]*segment
+	done     chan struct{}
+}
+
+// Manifest manifest.jsonl 中的一行，对应一个已关闭的文件
+type Manifest struct {
+	Table         string    `json:"table"`
+	File          string    `json:"file"`
+	Rows          int       `json:"rows"`
+	Bytes         int64     `json:"bytes"`
+	FirstPosition string    `json:"first_position,omitempty"`
+	LastPosition  string    `json:"last_position,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	ClosedAt      time.Time `json:"closed_at"`
+	// 进程异常退出后启动时关闭的文件，没有位置信息且末尾可能不完整
+	Recovered bool `json:"recovered,omitempty"`
+}
+
+func (f *File) SetDefaults() {
+	if f.Dir == "" {
+		f.Dir = "data/files"
+	}
+	if f.Format == "" {
+		f.Format = FormatJSONL
+	}
+	if f.Compression == "" {
+		f.Compression = CompressionNone
+	}
+	if f.MaxSizeMB == 0 {
+		f.MaxSizeMB = 64
+	}
+	if f.MaxAgeSeconds == 0 {
+		f.MaxAgeSeconds = 3600
+	}
+	if f.Fsync == "" {
+		f.Fsync = FsyncRotate
+	}
+}
+
+func (f *File) Init() error {
+	if !f.Enabled || f.segments != nil {
+		return nil
+	}
+	if f.Format != FormatJSONL && f.Format != FormatCSV {
+		return fmt.Errorf("unsupported file format %s", f.Format)
+	}
+	if f.Compression != CompressionNone && f.Compression != CompressionGzip && f.Compression != CompressionZstd {
+		return fmt.Errorf("unsupported file compression %s", f.Compression)
+	}
+	if err := os.MkdirAll(f.Dir, 0755); err != nil {
+		logrus.Errorf("file sink mkdir %s err:%s", f.Dir, err.Error())
+		return err
+	}
+	if err := f.recover(); err != nil {
+		logrus.Errorf("file sink recover err:%s", err.Error())
+		return err
+	}
+	f.segments = make(map[string]*segment)
+	f.done = make(chan struct{})
+	go f.rotateLoop()
+	return nil
+}
+
+// recover 关闭上次异常退出时未关闭的文件
+func (f *File) recover() error {
+	paths, err := filepath.Glob(filepath.Join(f.Dir, "*", "*"+inProgressSuffix))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		final := strings.TrimSuffix(path, inProgressSuffix)
+		if err := os.Rename(path, final); err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(f.Dir, final)
+		logrus.Warnf("file sink recovered unclosed segment %s", rel)
+		err = f.appendManifest(Manifest{
+			Table:     filepath.Base(filepath.Dir(final)),
+			File:      rel,
+			Bytes:     info.Size(),
+			CreatedAt: info.ModTime(),
+			ClosedAt:  time.Now(),
+			Recovered: true,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotateLoop 定时关闭超过 MaxAgeSeconds 的文件，没有新数据的表也能按时滚动
+func (f *File) rotateLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.mu.Lock()
+			for table, s := range f.segments {
+				if time.Since(s.created) >= time.Duration(f.MaxAgeSeconds)*time.Second {
+					if err := f.rotate(table); err != nil {
+						logrus.Errorf("file sink rotate %s err:%s", table, err.Error())
+					}
+				}
+			}
+			f.mu.Unlock()
+		case <-f.done:
+			return
+		}
+	}
+}
+
+func (f *File) String() string { return "file" }
+
+func (f *File) IsEnabled() bool {
+	return f != nil && f.Enabled
+}
+
+func (f *File) ChangedColumns(r *rule.Rule) bool { return true }
+
+func (f *File) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	touched := make(map[string]*segment)
+	for _, req := range requests {
+		table := req.Schema + "." + req.Name
+		s, err := f.segment(table, req)
+		if err != nil {
+			return err
+		}
+		if err := s.write(req); err != nil {
+			return err
+		}
+		touched[table] = s
+		if s.size() >= int64(f.MaxSizeMB)<<20 {
+			if err := f.rotate(table); err != nil {
+				return err
+			}
+			delete(touched, table)
+		}
+	}
+	// 返回前数据需写入文件，队列中的记录随后会被删除
+	for table, s := range touched {
+		if err := s.flush(f.Fsync == FsyncBatch); err != nil {
+			logrus.Errorf("file sink flush %s err:%s", table, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// segment 返回表当前的文件，CSV 字段变化时滚动到新文件
+func (f *File) segment(table string, req *models.RowRequest) (*segment, error) {
+	s, ok := f.segments[table]
+	if ok && s.csv != nil && s.header != nil && !sameHeader(s.header, csvHeader(req)) {
+		if err := f.rotate(table); err != nil {
+			return nil, err
+		}
+		ok = false
+	}
+	if ok {
+		return s, nil
+	}
+
+	dir := filepath.Join(f.Dir, table)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-%s.%s%s%s", table, time.Now().UTC().Format("20060102T150405.000000000"),
+		f.Format, f.extension(), inProgressSuffix)
+	s, err := openSegment(table, filepath.Join(dir, name), f.Format, f.Compression)
+	if err != nil {
+		logrus.Errorf("file sink open %s err:%s", name, err.Error())
+		return nil, err
+	}
+	f.segments[table] = s
+	return s, nil
+}
+
+// rotate 关闭文件、去掉 .inprogress 后缀并写入 manifest
+func (f *File) rotate(table string) error {
+	s, ok := f.segments[table]
+	if !ok {
+		return nil
+	}
+	delete(f.segments, table)
+	if err := s.close(f.Fsync != FsyncNone); err != nil {
+		return err
+	}
+	final := strings.TrimSuffix(s.path, inProgressSuffix)
+	if err := os.Rename(s.path, final); err != nil {
+		return err
+	}
+	rel, _ := filepath.Rel(f.Dir, final)
+	return f.appendManifest(Manifest{
+		Table:         table,
+		File:          rel,
+		Rows:          s.rows,
+		Bytes:         s.size(),
+		FirstPosition: s.first,
+		LastPosition:  s.last,
+		CreatedAt:     s.created,
+		ClosedAt:      time.Now(),
+	})
+}
+
+func (f *File) appendManifest(m Manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	manifest, err := os.OpenFile(filepath.Join(f.Dir, manifestName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer manifest.Close()
+	if _, err := manifest.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if f.Fsync != FsyncNone {
+		return manifest.Sync()
+	}
+	return nil
+}
+
+func (f *File) extension() string {
+	switch f.Compression {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+func (f *File) Close() {
+	if f.segments == nil {
+		return
+	}
+	close(f.done)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for table := range f.segments {
+		if err := f.rotate(table); err != nil {
+			logrus.Errorf("file sink close %s err:%s", table, err.Error())
+		}
+	}
+}
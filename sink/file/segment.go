@@ -0,0 +1,195 @@
+package file
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/klauspost/compress/zstd"
+)
+
+// segment 单张表正在写入的文件
+type segment struct {
+	table   string
+	path    string
+	file    *os.File
+	counter *countingWriter
+	// 未压缩时为 nil
+	compressor io.WriteCloser
+	writer     *bufio.Writer
+	csv        *csv.Writer
+	header     []string
+
+	first   string
+	last    string
+	rows    int
+	created time.Time
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func openSegment(table, path, format, compression string) (*segment, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &segment{
+		table:   table,
+		path:    path,
+		file:    f,
+		counter: &countingWriter{w: f},
+		created: time.Now(),
+	}
+	var w io.Writer = s.counter
+	switch compression {
+	case CompressionGzip:
+		s.compressor = gzip.NewWriter(w)
+	case CompressionZstd:
+		encoder, err := zstd.NewWriter(w)
+		if err != nil {
+			f.Close()
+			os.Remove(path)
+			return nil, err
+		}
+		s.compressor = encoder
+	}
+	if s.compressor != nil {
+		w = s.compressor
+	}
+	s.writer = bufio.NewWriter(w)
+	if format == FormatCSV {
+		s.csv = csv.NewWriter(s.writer)
+	}
+	return s, nil
+}
+
+func (s *segment) writeJSON(req *models.RowRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	s.writer.Write(data)
+	return s.writer.WriteByte('\n')
+}
+
+// writeCSV 首行为表头，依次为事件元数据与表字段，update 输出修改后的行
+func (s *segment) writeCSV(req *models.RowRequest) error {
+	if s.header == nil {
+		s.header = csvHeader(req)
+		if err := s.csv.Write(s.header); err != nil {
+			return err
+		}
+	}
+	row := req.Row()
+	record := make([]string, 0, len(s.header))
+	record = append(record, req.Action, req.Position(), time.Unix(int64(req.Timestamp), 0).UTC().Format(time.RFC3339))
+	for i := range req.Columns {
+		var value interface{}
+		if i < len(row) {
+			value = row[i]
+		}
+		record = append(record, sink.StringValue(value))
+	}
+	return s.csv.Write(record)
+}
+
+func (s *segment) write(req *models.RowRequest) error {
+	var err error
+	if s.csv != nil {
+		err = s.writeCSV(req)
+	} else {
+		err = s.writeJSON(req)
+	}
+	if err != nil {
+		return err
+	}
+	if s.first == "" {
+		s.first = req.Position()
+	}
+	s.last = req.Position()
+	s.rows++
+	return nil
+}
+
+// flush 将缓冲与压缩数据写入文件，sync 为 true 时 fsync
+func (s *segment) flush(sync bool) error {
+	if s.csv != nil {
+		s.csv.Flush()
+		if err := s.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	if flusher, ok := s.compressor.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+	if sync {
+		return s.file.Sync()
+	}
+	return nil
+}
+
+func (s *segment) close(sync bool) error {
+	if err := s.flush(false); err != nil {
+		s.file.Close()
+		return err
+	}
+	if s.compressor != nil {
+		if err := s.compressor.Close(); err != nil {
+			s.file.Close()
+			return err
+		}
+	}
+	if sync {
+		if err := s.file.Sync(); err != nil {
+			s.file.Close()
+			return err
+		}
+	}
+	return s.file.Close()
+}
+
+// size 已写入文件的字节数，压缩时为压缩后大小
+func (s *segment) size() int64 {
+	return s.counter.n
+}
+
+func csvHeader(req *models.RowRequest) []string {
+	header := make([]string, 0, len(req.Columns)+3)
+	header = append(header, "_action", "_position", "_timestamp")
+	for i := range req.Columns {
+		header = append(header, req.Columns[i].Name)
+	}
+	return header
+}
+
+func sameHeader(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,167 @@
+package invalidator
+
+import (
+	"context"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/bradfitz/gomemcache/memcache"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	BackendRedis     = "redis"
+	BackendMemcached = "memcached"
+)
+
+// Invalidator 按规则中的 key 模板删除或过期缓存，不写入数据
+type Invalidator struct {
+	Enabled bool `env:""`
+	// redis/memcached
+	Backend  string   `env:""`
+	Addrs    []string `env:""`
+	Password string   `env:""`
+	DB       int      `env:""`
+	// 为 0 时删除 key，否则设置过期时间
+	ExpireSeconds int `env:""`
+	TimeoutMs     int `env:""`
+
+	redis    goredis.UniversalClient
+	memcache *memcache.Client
+}
+
+func (i *Invalidator) SetDefaults() {
+	if i.Backend == "" {
+		i.Backend = BackendRedis
+	}
+	if len(i.Addrs) == 0 {
+		if i.Backend == BackendMemcached {
+			i.Addrs = []string{"127.0.0.1:11211"}
+		} else {
+			i.Addrs = []string{"127.0.0.1:6379"}
+		}
+	}
+	if i.TimeoutMs == 0 {
+		i.TimeoutMs = 5000
+	}
+}
+
+func (i *Invalidator) Init() error {
+	if !i.Enabled || i.redis != nil || i.memcache != nil {
+		return nil
+	}
+	if i.Backend == BackendMemcached {
+		client := memcache.New(i.Addrs...)
+		client.Timeout = time.Duration(i.TimeoutMs) * time.Millisecond
+		i.memcache = client
+		return nil
+	}
+
+	client := goredis.NewUniversalClient(&goredis.UniversalOptions{
+		Addrs:    i.Addrs,
+		Password: i.Password,
+		DB:       i.DB,
+	})
+	ctx, cancel := i.context()
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		logrus.Errorf("invalidator redis ping err:%s", err.Error())
+		return err
+	}
+	i.redis = client
+	return nil
+}
+
+func (i *Invalidator) String() string { return "invalidator" }
+
+func (i *Invalidator) IsEnabled() bool {
+	return i != nil && i.Enabled
+}
+
+func (i *Invalidator) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, req := range requests {
+		r := rules.Find(req.Schema, req.Name)
+		if r == nil {
+			continue
+		}
+		for _, key := range invalidateKeys(r.InvalidateKeys, req) {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if i.memcache != nil {
+		return i.invalidateMemcache(keys)
+	}
+	return i.invalidateRedis(keys)
+}
+
+// invalidateKeys 分别使用修改前和修改后的值渲染，保证修改了 key 相关字段时旧 key 也会失效
+func invalidateKeys(templates []string, req *models.RowRequest) []string {
+	keys := make([]string, 0, len(templates)*2)
+	for _, template := range templates {
+		if req.NewRows != nil {
+			if key, ok := sink.RenderKey(template, req); ok {
+				keys = append(keys, key)
+			}
+		}
+		if req.OldRows != nil {
+			if key, ok := sink.RenderKey(template, sink.Before(req)); ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+func (i *Invalidator) invalidateRedis(keys []string) error {
+	ctx, cancel := i.context()
+	defer cancel()
+	pipe := i.redis.Pipeline()
+	for _, key := range keys {
+		if i.ExpireSeconds > 0 {
+			pipe.Expire(ctx, key, time.Duration(i.ExpireSeconds)*time.Second)
+		} else {
+			pipe.Del(ctx, key)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	if err == goredis.Nil {
+		return nil
+	}
+	return err
+}
+
+func (i *Invalidator) invalidateMemcache(keys []string) error {
+	for _, key := range keys {
+		var err error
+		if i.ExpireSeconds > 0 {
+			err = i.memcache.Touch(key, int32(i.ExpireSeconds))
+		} else {
+			err = i.memcache.Delete(key)
+		}
+		if err != nil && err != memcache.ErrCacheMiss {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Invalidator) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Duration(i.TimeoutMs)*time.Millisecond)
+}
+
+func (i *Invalidator) Close() {
+	if i.redis != nil {
+		i.redis.Close()
+	}
+}
@@ -0,0 +1,93 @@
+package invalidator
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/siddontang/go-mysql/canal"
+)
+
+func newTestInvalidator(t *testing.T, expireSeconds int) (*Invalidator, *miniredis.Miniredis) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("run miniredis err:%s", err.Error())
+	}
+	t.Cleanup(server.Close)
+	i := &Invalidator{Enabled: true, Addrs: []string{server.Addr()}, ExpireSeconds: expireSeconds}
+	i.SetDefaults()
+	if err := i.Init(); err != nil {
+		t.Fatalf("init invalidator err:%s", err.Error())
+	}
+	t.Cleanup(i.Close)
+	for _, key := range []string{"user:1", "user:2", "user:email:a@x.com", "user:email:b@x.com", "user:phone:"} {
+		server.Set(key, "cached")
+	}
+	return i, server
+}
+
+var testRules = rule.Rules{{
+	Schema: "db",
+	Table:  "user",
+	// 表中没有 phone 字段
+	InvalidateKeys: []string{"user:{{id}}", "user:email:{{email}}", "user:phone:{{phone}}"},
+}}
+
+func newUpdateRequest() *models.RowRequest {
+	return &models.RowRequest{
+		Schema:      "db",
+		Name:        "user",
+		Action:      canal.UpdateAction,
+		Columns:     []models.Column{{Name: "id"}, {Name: "email"}},
+		PrimaryKeys: []string{"id"},
+		OldRows:     []interface{}{1, "a@x.com"},
+		NewRows:     []interface{}{1, "b@x.com"},
+	}
+}
+
+func TestInvalidateKeys(t *testing.T) {
+	keys := invalidateKeys(testRules[0].InvalidateKeys, newUpdateRequest())
+	want := []string{"user:1", "user:1", "user:email:b@x.com", "user:email:a@x.com"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("invalidateKeys = %v, want %v", keys, want)
+	}
+}
+
+func TestWriteDelete(t *testing.T) {
+	i, server := newTestInvalidator(t, 0)
+	other := &models.RowRequest{Schema: "db", Name: "order", Action: canal.DeleteAction,
+		Columns: []models.Column{{Name: "id"}}, OldRows: []interface{}{2}}
+	if err := i.Write(testRules, []*models.RowRequest{newUpdateRequest(), other}); err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	// 修改前后的 key 都删除
+	for _, key := range []string{"user:1", "user:email:a@x.com", "user:email:b@x.com"} {
+		if server.Exists(key) {
+			t.Errorf("key %s exists, want deleted", key)
+		}
+	}
+	// 模板中的字段不存在时不渲染，未配置规则的表不处理
+	for _, key := range []string{"user:2", "user:phone:"} {
+		if !server.Exists(key) {
+			t.Errorf("key %s deleted, want kept", key)
+		}
+	}
+}
+
+func TestWriteExpire(t *testing.T) {
+	i, server := newTestInvalidator(t, 60)
+	if err := i.Write(testRules, []*models.RowRequest{newUpdateRequest()}); err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	for _, key := range []string{"user:1", "user:email:a@x.com", "user:email:b@x.com"} {
+		if ttl := server.TTL(key); ttl != 60*time.Second {
+			t.Errorf("key %s ttl = %s, want 1m0s", key, ttl)
+		}
+	}
+	if ttl := server.TTL("user:2"); ttl != 0 {
+		t.Errorf("key user:2 ttl = %s, want none", ttl)
+	}
+}
@@ -0,0 +1,160 @@
+package kafka
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+type Kafka struct {
+	Enabled bool     `env:""`
+	Brokers []string `env:""`
+	Version string   `env:""`
+	// 默认 topic 模板，可在规则中通过 KafkaTopic 覆盖
+	Topic string `env:""`
+	// all/local/none
+	RequiredAcks string `env:""`
+	// none/gzip/snappy/lz4/zstd
+	Compression      string `env:""`
+	FlushMessages    int    `env:""`
+	FlushBytes       int    `env:""`
+	FlushFrequencyMs int    `env:""`
+	MaxMessageBytes  int    `env:""`
+	Idempotent       bool   `env:""`
+
+	producer sarama.SyncProducer
+}
+
+func (k *Kafka) SetDefaults() {
+	if len(k.Brokers) == 0 {
+		k.Brokers = []string{"127.0.0.1:9092"}
+	}
+	if k.Version == "" {
+		k.Version = "2.1.0"
+	}
+	if k.Topic == "" {
+		k.Topic = "{{schema}}.{{table}}"
+	}
+	if k.RequiredAcks == "" {
+		k.RequiredAcks = "all"
+	}
+	if k.Compression == "" {
+		k.Compression = "none"
+	}
+	if k.MaxMessageBytes == 0 {
+		k.MaxMessageBytes = 1000000
+	}
+}
+
+func (k *Kafka) Init() error {
+	if !k.Enabled || k.producer != nil {
+		return nil
+	}
+	cfg, err := k.config()
+	if err != nil {
+		logrus.Errorf("kafka config err:%s", err.Error())
+		return err
+	}
+	producer, err := sarama.NewSyncProducer(k.Brokers, cfg)
+	if err != nil {
+		logrus.Errorf("kafka new producer err:%s", err.Error())
+		return err
+	}
+	k.producer = producer
+	return nil
+}
+
+func (k *Kafka) config() (*sarama.Config, error) {
+	cfg := sarama.NewConfig()
+	version, err := sarama.ParseKafkaVersion(k.Version)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Version = version
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
+	// 相同主键的消息进入同一分区，保证顺序
+	cfg.Producer.Partitioner = sarama.NewHashPartitioner
+	cfg.Producer.MaxMessageBytes = k.MaxMessageBytes
+	cfg.Producer.Flush.Messages = k.FlushMessages
+	cfg.Producer.Flush.Bytes = k.FlushBytes
+	cfg.Producer.Flush.Frequency = time.Duration(k.FlushFrequencyMs) * time.Millisecond
+
+	switch strings.ToLower(k.RequiredAcks) {
+	case "none":
+		cfg.Producer.RequiredAcks = sarama.NoResponse
+	case "local":
+		cfg.Producer.RequiredAcks = sarama.WaitForLocal
+	default:
+		cfg.Producer.RequiredAcks = sarama.WaitForAll
+	}
+
+	switch strings.ToLower(k.Compression) {
+	case "gzip":
+		cfg.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		cfg.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		cfg.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		cfg.Producer.Compression = sarama.CompressionZSTD
+	default:
+		cfg.Producer.Compression = sarama.CompressionNone
+	}
+
+	if k.Idempotent {
+		cfg.Producer.Idempotent = true
+		cfg.Producer.RequiredAcks = sarama.WaitForAll
+		cfg.Net.MaxOpenRequests = 1
+		if cfg.Producer.Retry.Max < 1 {
+			cfg.Producer.Retry.Max = 1
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+func (k *Kafka) String() string { return "kafka" }
+
+func (k *Kafka) IsEnabled() bool {
+	return k != nil && k.Enabled
+}
+
+func (k *Kafka) ChangedColumns(r *rule.Rule) bool { return true }
+
+func (k *Kafka) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	messages := make([]*sarama.ProducerMessage, 0, len(requests))
+	for _, req := range requests {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		topic := k.Topic
+		if r := rules.Find(req.Schema, req.Name); r != nil && r.KafkaTopic != "" {
+			topic = r.KafkaTopic
+		}
+		message := &sarama.ProducerMessage{
+			Topic: sink.Render(topic, req),
+			Value: sarama.ByteEncoder(data),
+		}
+		if key := sink.PrimaryKey(req); key != "" {
+			message.Key = sarama.StringEncoder(req.Schema + "." + req.Name + ":" + key)
+		}
+		messages = append(messages, message)
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return k.producer.SendMessages(messages)
+}
+
+func (k *Kafka) Close() {
+	if k.producer != nil {
+		k.producer.Close()
+	}
+}
@@ -0,0 +1,162 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/siddontang/go-mysql/canal"
+)
+
+// recordProducer 记录发送的消息，用于检查 key 与 topic
+type recordProducer struct {
+	*mocks.SyncProducer
+	messages []*sarama.ProducerMessage
+}
+
+func (p *recordProducer) SendMessages(messages []*sarama.ProducerMessage) error {
+	p.messages = append(p.messages, messages...)
+	return p.SyncProducer.SendMessages(messages)
+}
+
+func newRequest(table string, id int, name string) *models.RowRequest {
+	return &models.RowRequest{
+		Schema:      "db",
+		Name:        table,
+		Action:      canal.UpdateAction,
+		Columns:     []models.Column{{Name: "id"}, {Name: "name"}},
+		PrimaryKeys: []string{"id"},
+		NewRows:     []interface{}{id, name},
+	}
+}
+
+func expectName(name string) mocks.ValueChecker {
+	return func(val []byte) error {
+		var req models.RowRequest
+		if err := json.Unmarshal(val, &req); err != nil {
+			return err
+		}
+		if got := fmt.Sprint(req.NewValue("name")); got != name {
+			return fmt.Errorf("message name %s, want %s", got, name)
+		}
+		return nil
+	}
+}
+
+func TestWrite(t *testing.T) {
+	k := &Kafka{Enabled: true}
+	k.SetDefaults()
+	cfg, err := k.config()
+	if err != nil {
+		t.Fatalf("config err:%s", err.Error())
+	}
+	producer := &recordProducer{SyncProducer: mocks.NewSyncProducer(t, cfg)}
+	defer producer.Close()
+	k.producer = producer
+
+	requests := []*models.RowRequest{
+		newRequest("user", 1, "a"),
+		newRequest("user", 2, "b"),
+		newRequest("user", 1, "c"),
+		newRequest("order", 1, "d"),
+	}
+	for _, req := range requests {
+		producer.ExpectSendMessageWithCheckerFunctionAndSucceed(expectName(fmt.Sprint(req.NewValue("name"))))
+	}
+	rules := rule.Rules{{Schema: "db", Table: "order", KafkaTopic: "orders"}}
+	if err := k.Write(rules, requests); err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+
+	wants := []struct {
+		topic string
+		key   string
+	}{
+		{topic: "db.user", key: "db.user:1"},
+		{topic: "db.user", key: "db.user:2"},
+		{topic: "db.user", key: "db.user:1"},
+		{topic: "orders", key: "db.order:1"},
+	}
+	if len(producer.messages) != len(wants) {
+		t.Fatalf("sent %d messages, want %d", len(producer.messages), len(wants))
+	}
+	for i, want := range wants {
+		message := producer.messages[i]
+		key, _ := message.Key.Encode()
+		if message.Topic != want.topic || string(key) != want.key {
+			t.Errorf("message %d topic:%s key:%s, want topic:%s key:%s", i, message.Topic, key, want.topic, want.key)
+		}
+	}
+
+	// 相同主键按 key 哈希进入同一分区，且按写入顺序发送
+	partitioner := cfg.Producer.Partitioner("db.user")
+	partition := func(message *sarama.ProducerMessage) int32 {
+		p, err := partitioner.Partition(message, 16)
+		if err != nil {
+			t.Fatalf("partition err:%s", err.Error())
+		}
+		return p
+	}
+	if partition(producer.messages[0]) != partition(producer.messages[2]) {
+		t.Errorf("messages with the same primary key should be sent to the same partition")
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	k := &Kafka{Enabled: true}
+	k.SetDefaults()
+	cfg, err := k.config()
+	if err != nil {
+		t.Fatalf("config err:%s", err.Error())
+	}
+	producer := mocks.NewSyncProducer(t, cfg)
+	defer producer.Close()
+	k.producer = producer
+
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndFail(sarama.ErrNotLeaderForPartition)
+	err = k.Write(nil, []*models.RowRequest{newRequest("user", 1, "a"), newRequest("user", 2, "b")})
+	if !errors.Is(err, sarama.ErrNotLeaderForPartition) {
+		t.Errorf("Write err = %v, want %v", err, sarama.ErrNotLeaderForPartition)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	k := &Kafka{Enabled: true, RequiredAcks: "local", Compression: "snappy", Idempotent: true}
+	k.SetDefaults()
+	cfg, err := k.config()
+	if err != nil {
+		t.Fatalf("config err:%s", err.Error())
+	}
+	// 幂等写入要求 acks=all、单连接单请求且允许重试
+	if !cfg.Producer.Idempotent {
+		t.Errorf("Idempotent should be enabled")
+	}
+	if cfg.Producer.RequiredAcks != sarama.WaitForAll {
+		t.Errorf("RequiredAcks = %d, want WaitForAll", cfg.Producer.RequiredAcks)
+	}
+	if cfg.Net.MaxOpenRequests != 1 {
+		t.Errorf("MaxOpenRequests = %d, want 1", cfg.Net.MaxOpenRequests)
+	}
+	if cfg.Producer.Retry.Max < 1 {
+		t.Errorf("Retry.Max = %d, want at least 1", cfg.Producer.Retry.Max)
+	}
+	if cfg.Producer.Compression != sarama.CompressionSnappy {
+		t.Errorf("Compression = %s, want snappy", cfg.Producer.Compression)
+	}
+
+	k = &Kafka{Enabled: true, Version: "0.10.0.0", Idempotent: true}
+	k.SetDefaults()
+	if _, err := k.config(); err == nil {
+		t.Errorf("idempotent producer requires kafka 0.11 or later")
+	}
+	k = &Kafka{Enabled: true, Version: "x"}
+	if _, err := k.config(); err == nil {
+		t.Errorf("invalid version should fail")
+	}
+}
@@ -0,0 +1,345 @@
+package live
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/httpserver"
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
+)
+
+const columnParamPrefix = "where."
+
+// Live 通过 HTTP 以 SSE 或 WebSocket 推送实时变更，需开启 HTTP 服务。
+// 查询参数 schema、table、action 可用逗号分隔多个值，where.<column>=<value> 按字段值过滤，
+// SSE 断线重连时按 Last-Event-ID 续传，WebSocket 使用查询参数 last_event_id
+type Live struct {
+	Enabled bool   `env:""`
+	Path    string `env:""`
+	// 内存中保留的事件数，决定可续传的范围
+	Retention int `env:""`
+	// 单个连接每秒推送事件数上限及突发数
+	RateLimit      float64 `env:""`
+	RateBurst      int     `env:""`
+	MaxConnections int     `env:""`
+	// 没有事件时发送心跳的间隔
+	HeartbeatSeconds int `env:""`
+	// 允许跨域访问的来源，如 https://admin.example.com，* 表示允许所有来源，为空时只允许同源
+	AllowedOrigins []string `env:""`
+
+	log         *Log
+	connections atomic.Int32
+	upgrader    websocket.Upgrader
+	done        chan struct{}
+}
+
+// sender SSE 与 WebSocket 的发送方式
+type sender interface {
+	send(seq uint64, req *models.RowRequest) error
+	notice(event, message string) error
+	heartbeat() error
+}
+
+func (l *Live) SetDefaults() {
+	if l.Path == "" {
+		l.Path = "/events"
+	}
+	if l.Retention == 0 {
+		l.Retention = 10000
+	}
+	if l.RateLimit == 0 {
+		l.RateLimit = 100
+	}
+	if l.RateBurst == 0 {
+		l.RateBurst = 100
+	}
+	if l.MaxConnections == 0 {
+		l.MaxConnections = 256
+	}
+	if l.HeartbeatSeconds == 0 {
+		l.HeartbeatSeconds = 15
+	}
+}
+
+func (l *Live) Init() error {
+	if !l.Enabled || l.log != nil {
+		return nil
+	}
+	l.log = NewLog(l.Retention)
+	l.done = make(chan struct{})
+	l.upgrader = websocket.Upgrader{CheckOrigin: l.checkOrigin}
+	httpserver.HandleFunc(l.Path, l.serve)
+	return nil
+}
+
+func (l *Live) String() string { return "live" }
+
+func (l *Live) IsEnabled() bool {
+	return l != nil && l.Enabled
+}
+
+func (l *Live) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	l.log.Append(requests)
+	return nil
+}
+
+func (l *Live) serve(w http.ResponseWriter, r *http.Request) {
+	if int(l.connections.Inc()) > l.MaxConnections {
+		l.connections.Dec()
+		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+		return
+	}
+	defer l.connections.Dec()
+
+	// 接口没有鉴权，禁止未授权的页面跨站读取数据
+	if !l.checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+
+	match := matcher(r)
+	// 未指定续传位置时只推送新事件
+	cursor := l.log.Last()
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	if lastEventID != "" {
+		seq, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		cursor = seq
+	}
+
+	var s sender
+	if websocket.IsWebSocketUpgrade(r) {
+		conn, err := l.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			logrus.Warnf("live websocket upgrade err:%s", err.Error())
+			return
+		}
+		defer conn.Close()
+		closed := make(chan struct{})
+		// 读取客户端消息以处理 close 与 pong
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		s = &wsSender{conn: conn, closed: closed}
+	} else {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		s = &sseSender{w: w, flusher: flusher}
+	}
+
+	if err := l.stream(r, s, cursor, match); err != nil {
+		logrus.Debugf("live stream closed err:%s", err.Error())
+	}
+}
+
+func (l *Live) stream(r *http.Request, s sender, cursor uint64, match func(*models.RowRequest) bool) error {
+	ctx := r.Context()
+	var closed <-chan struct{}
+	if ws, ok := s.(*wsSender); ok {
+		closed = ws.closed
+	}
+	limiter := rate.NewLimiter(rate.Limit(l.RateLimit), l.RateBurst)
+	heartbeat := time.NewTicker(time.Duration(l.HeartbeatSeconds) * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		events, next, notify, err := l.log.After(cursor, l.RateBurst, match)
+		if errors.Is(err, ErrCursorExpired) {
+			// 续传位置已超出保留范围，通知客户端后从最早的事件继续
+			if err := s.notice("reset", err.Error()); err != nil {
+				return err
+			}
+			cursor = 0
+			continue
+		}
+		if next == cursor {
+			select {
+			case <-notify:
+				continue
+			case <-heartbeat.C:
+				if err := s.heartbeat(); err != nil {
+					return err
+				}
+				continue
+			case <-closed:
+				return nil
+			case <-ctx.Done():
+				return nil
+			case <-l.done:
+				return nil
+			}
+		}
+		for _, req := range events {
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
+			if err := s.send(req.Seq, req); err != nil {
+				return err
+			}
+		}
+		cursor = next
+	}
+}
+
+// checkOrigin 非浏览器请求没有 Origin，允许访问
+func (l *Live) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range l.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func matcher(r *http.Request) func(*models.RowRequest) bool {
+	query := r.URL.Query()
+	schemas := split(query.Get("schema"))
+	tables := split(query.Get("table"))
+	actions := split(query.Get("action"))
+	columns := make(map[string][]string)
+	for key, values := range query {
+		if strings.HasPrefix(key, columnParamPrefix) {
+			columns[strings.TrimPrefix(key, columnParamPrefix)] = values
+		}
+	}
+	return func(req *models.RowRequest) bool {
+		if !contains(schemas, req.Schema) || !contains(tables, req.Name) || !contains(actions, req.Action) {
+			return false
+		}
+		for column, values := range columns {
+			if req.ColumnIndex(column) < 0 || !contains(values, sink.StringValue(req.Value(column))) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func contains(list []string, s string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type sseSender struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (s *sseSender) send(seq uint64, req *models.RowRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", seq, req.Action, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *sseSender) notice(event, message string) error {
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, message); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *sseSender) heartbeat() error {
+	if _, err := fmt.Fprint(s.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+type wsSender struct {
+	conn   *websocket.Conn
+	closed chan struct{}
+}
+
+type wsMessage struct {
+	Event   string
+	Seq     uint64             `json:",omitempty"`
+	Request *models.RowRequest `json:",omitempty"`
+	Message string             `json:",omitempty"`
+}
+
+func (s *wsSender) send(seq uint64, req *models.RowRequest) error {
+	return s.write(wsMessage{Event: req.Action, Seq: seq, Request: req})
+}
+
+func (s *wsSender) notice(event, message string) error {
+	return s.write(wsMessage{Event: event, Message: message})
+}
+
+func (s *wsSender) heartbeat() error {
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second*10))
+}
+
+func (s *wsSender) write(m wsMessage) error {
+	s.conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+	return s.conn.WriteJSON(m)
+}
+
+func (l *Live) Close() {
+	if l.done != nil {
+		close(l.done)
+	}
+}
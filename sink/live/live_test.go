@@ -0,0 +1,201 @@
+package live
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/gorilla/websocket"
+)
+
+func newTestLive(t *testing.T) *Live {
+	l := &Live{Enabled: true}
+	l.SetDefaults()
+	l.log = NewLog(l.Retention)
+	l.done = make(chan struct{})
+	l.upgrader = websocket.Upgrader{CheckOrigin: l.checkOrigin}
+	t.Cleanup(l.Close)
+	return l
+}
+
+func newUserRequest(seq uint64, action string, id, name string) *models.RowRequest {
+	return &models.RowRequest{
+		Seq:     seq,
+		Schema:  "db",
+		Name:    "user",
+		Action:  action,
+		Columns: []models.Column{{Name: "id"}, {Name: "name"}},
+		NewRows: []interface{}{id, name},
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	req := newUserRequest(1, "insert", "1", "a")
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{query: "", want: true},
+		{query: "schema=db&table=order,user", want: true},
+		{query: "table=order", want: false},
+		{query: "action=update,delete", want: false},
+		{query: "where.name=a", want: true},
+		{query: "where.name=b&where.name=a", want: true},
+		{query: "where.name=b", want: false},
+		{query: "where.id=1&where.name=b", want: false},
+		{query: "where.age=1", want: false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/events?"+tt.query, nil)
+		if got := matcher(r)(req); got != tt.want {
+			t.Errorf("matcher(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		origin  string
+		allowed []string
+		want    bool
+	}{
+		{origin: "", want: true},
+		{origin: "http://example.com", want: true},
+		{origin: "http://evil.com", want: false},
+		{origin: "http://admin.com", allowed: []string{"http://admin.com/"}, want: true},
+		{origin: "http://evil.com", allowed: []string{"http://admin.com"}, want: false},
+		{origin: "http://evil.com", allowed: []string{"*"}, want: true},
+	}
+	for _, tt := range tests {
+		l := &Live{AllowedOrigins: tt.allowed}
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/events", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := l.checkOrigin(r); got != tt.want {
+			t.Errorf("checkOrigin(%q, %v) = %v, want %v", tt.origin, tt.allowed, got, tt.want)
+		}
+	}
+}
+
+func TestCrossOrigin(t *testing.T) {
+	l := newTestLive(t)
+	server := httptest.NewServer(http.HandlerFunc(l.serve))
+	defer server.Close()
+
+	header := http.Header{"Origin": []string{"http://evil.com"}}
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("websocket from another origin err = %v, want 403", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header = header
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("sse err:%s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("sse from another origin status = %d, want 403", resp.StatusCode)
+	}
+}
+
+// TestResume SSE 按 Last-Event-ID 续传
+func TestResume(t *testing.T) {
+	l := newTestLive(t)
+	l.Write(nil, []*models.RowRequest{
+		newUserRequest(1, "insert", "1", "a"),
+		newUserRequest(2, "update", "1", "b"),
+		newUserRequest(3, "delete", "1", "b"),
+	})
+	server := httptest.NewServer(http.HandlerFunc(l.serve))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"?action=update,delete", nil)
+	req = req.WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("sse err:%s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	ids := make([]string, 0, 2)
+	scanner := bufio.NewScanner(resp.Body)
+	for len(ids) < 2 && scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "id: ") {
+			ids = append(ids, strings.TrimPrefix(line, "id: "))
+		}
+	}
+	if strings.Join(ids, ",") != "2,3" {
+		t.Errorf("resumed ids = %v, want [2 3]", ids)
+	}
+}
+
+type testSender struct {
+	sent    []uint64
+	notices []string
+}
+
+func (s *testSender) send(seq uint64, req *models.RowRequest) error {
+	s.sent = append(s.sent, seq)
+	return nil
+}
+
+func (s *testSender) notice(event, message string) error {
+	s.notices = append(s.notices, event)
+	return nil
+}
+
+func (s *testSender) heartbeat() error { return nil }
+
+func TestRateLimit(t *testing.T) {
+	l := newTestLive(t)
+	l.RateLimit = 10
+	l.RateBurst = 1
+	requests := make([]*models.RowRequest, 0, 10)
+	for seq := uint64(1); seq <= 10; seq++ {
+		requests = append(requests, newUserRequest(seq, "insert", "1", "a"))
+	}
+	l.Write(nil, requests)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+	s := &testSender{}
+	l.stream(r, s, 0, nil)
+	// 突发 1 个，之后每 100ms 1 个
+	if len(s.sent) < 1 || len(s.sent) > 4 {
+		t.Errorf("sent %d events in 250ms, want at most 4 at 10/s", len(s.sent))
+	}
+}
+
+// TestExpiredCursor 续传位置超出保留范围时通知客户端并从最早的事件开始
+func TestExpiredCursor(t *testing.T) {
+	l := newTestLive(t)
+	l.log = NewLog(2)
+	requests := make([]*models.RowRequest, 0, 4)
+	for seq := uint64(1); seq <= 4; seq++ {
+		requests = append(requests, newUserRequest(seq, "insert", "1", "a"))
+	}
+	l.Write(nil, requests)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+	s := &testSender{}
+	l.stream(r, s, 1, nil)
+	if len(s.notices) != 1 || s.notices[0] != "reset" {
+		t.Errorf("notices = %v, want [reset]", s.notices)
+	}
+	if len(s.sent) != 2 || s.sent[0] != 3 || s.sent[1] != 4 {
+		t.Errorf("sent = %v, want [3 4]", s.sent)
+	}
+}
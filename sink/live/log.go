@@ -0,0 +1,97 @@
+package live
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+)
+
+var ErrCursorExpired = errors.New("cursor expired")
+
+// Log 在内存中按队列序号保留最近的 RowRequest，写满后循环覆盖最早的事件
+type Log struct {
+	mu        sync.RWMutex
+	retention int
+	events    []*models.RowRequest
+	// events 中第一个事件的下标
+	start int
+	last  uint64
+	// 有新事件时关闭并替换，用于唤醒等待中的读取方
+	notify chan struct{}
+}
+
+func NewLog(retention int) *Log {
+	return &Log{
+		retention: retention,
+		events:    make([]*models.RowRequest, 0, retention),
+		notify:    make(chan struct{}),
+	}
+}
+
+// Append sink 失败重试时同一批会再次写入，已保留的序号直接跳过
+func (l *Log) Append(requests []*models.RowRequest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	added := false
+	for _, req := range requests {
+		if req.Seq <= l.last {
+			continue
+		}
+		if len(l.events) < l.retention {
+			l.events = append(l.events, req)
+		} else {
+			l.events[l.start] = req
+			l.start = (l.start + 1) % l.retention
+		}
+		l.last = req.Seq
+		added = true
+	}
+	if added {
+		close(l.notify)
+		l.notify = make(chan struct{})
+	}
+}
+
+// Last 最新事件的序号
+func (l *Log) Last() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.last
+}
+
+// After 返回游标之后最多 limit 个符合条件的事件、扫描到的位置及有新事件时关闭的 channel，
+// 游标之后的事件已被覆盖时返回 ErrCursorExpired
+func (l *Log) After(cursor uint64, limit int, match func(*models.RowRequest) bool) ([]*models.RowRequest, uint64, <-chan struct{}, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	n := len(l.events)
+	if n == 0 || cursor >= l.last {
+		return nil, cursor, l.notify, nil
+	}
+	oldest := l.events[l.start].Seq
+	if cursor != 0 && cursor+1 < oldest {
+		return nil, cursor, l.notify, fmt.Errorf("%w: %d is older than retained events, oldest is %d", ErrCursorExpired, cursor, oldest)
+	}
+
+	// 序号递增，二分查找游标之后的第一个事件
+	lo, hi := 0, n
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if l.events[(l.start+mid)%n].Seq <= cursor {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	matched := make([]*models.RowRequest, 0, limit)
+	for i := lo; i < n && len(matched) < limit; i++ {
+		req := l.events[(l.start+i)%n]
+		cursor = req.Seq
+		if match == nil || match(req) {
+			matched = append(matched, req)
+		}
+	}
+	return matched, cursor, l.notify, nil
+}
@@ -0,0 +1,359 @@
+package mongodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoDB 按主键生成 _id 以 $set 方式 upsert 文档，子表可作为数组嵌入父文档，
+// 同一源事务内对同一集合的操作以有序 bulk write 提交
+type MongoDB struct {
+	Enabled bool   `env:""`
+	URI     string `env:""`
+	// 默认库与集合模板，可在规则中通过 MongoDatabase/MongoCollection 覆盖
+	Database   string `env:""`
+	Collection string `env:""`
+	TimeoutMs  int    `env:""`
+
+	client *mongo.Client
+}
+
+type embed struct {
+	collection string
+	// 父表名，默认与集合同名，用于查找父表规则
+	table string
+	// 子表字段，与父表主键 parentKeys 一一对应
+	columns    []string
+	parentKeys []string
+	field      string
+}
+
+// operation 同一集合上待执行的写操作
+type operation struct {
+	database   string
+	collection string
+	models     []mongo.WriteModel
+}
+
+func (m *MongoDB) SetDefaults() {
+	if m.URI == "" {
+		m.URI = "mongodb://127.0.0.1:27017"
+	}
+	if m.Database == "" {
+		m.Database = "{{schema}}"
+	}
+	if m.Collection == "" {
+		m.Collection = "{{table}}"
+	}
+	if m.TimeoutMs == 0 {
+		m.TimeoutMs = 30000
+	}
+}
+
+func (m *MongoDB) Init() error {
+	if !m.Enabled || m.client != nil {
+		return nil
+	}
+	ctx, cancel := m.context()
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(m.URI))
+	if err != nil {
+		logrus.Errorf("mongodb connect err:%s", err.Error())
+		return err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		logrus.Errorf("mongodb ping err:%s", err.Error())
+		client.Disconnect(context.Background())
+		return err
+	}
+	m.client = client
+	return nil
+}
+
+func (m *MongoDB) String() string { return "mongodb" }
+
+func (m *MongoDB) IsEnabled() bool {
+	return m != nil && m.Enabled
+}
+
+func (m *MongoDB) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	for start := 0; start < len(requests); {
+		end := start + 1
+		for end < len(requests) && requests[end].Transaction() == requests[start].Transaction() {
+			end++
+		}
+		if err := m.writeTransaction(rules, requests[start:end]); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+func (m *MongoDB) writeTransaction(rules rule.Rules, requests []*models.RowRequest) error {
+	operations := make([]*operation, 0)
+	index := make(map[string]*operation)
+	add := func(database, collection string, writes ...mongo.WriteModel) {
+		if len(writes) == 0 {
+			return
+		}
+		key := database + "." + collection
+		op, ok := index[key]
+		if !ok {
+			op = &operation{database: database, collection: collection}
+			index[key] = op
+			operations = append(operations, op)
+		}
+		op.models = append(op.models, writes...)
+	}
+
+	for _, req := range requests {
+		r := rules.Find(req.Schema, req.Name)
+		database, collection := m.Database, m.Collection
+		if r != nil && r.MongoDatabase != "" {
+			database = r.MongoDatabase
+		}
+		if r != nil && r.MongoCollection != "" {
+			collection = r.MongoCollection
+		}
+		database = sink.Render(database, req)
+
+		if r != nil && r.MongoEmbed != "" {
+			e, err := parseEmbed(r.MongoEmbed)
+			if err != nil {
+				return err
+			}
+			add(database, e.collection, embedModels(rules, r, e, req)...)
+			continue
+		}
+
+		collection = sink.Render(collection, req)
+		switch req.Action {
+		case canal.DeleteAction:
+			add(database, collection, mongo.NewDeleteOneModel().SetFilter(bson.M{"_id": documentID(r, sink.Before(req))}))
+		default:
+			if req.Action == canal.UpdateAction {
+				// 主键变化时删除旧文档
+				oldID, newID := documentID(r, sink.Before(req)), documentID(r, req)
+				if fmt.Sprint(oldID) != fmt.Sprint(newID) {
+					add(database, collection, mongo.NewDeleteOneModel().SetFilter(bson.M{"_id": oldID}))
+				}
+			}
+			// 使用 $set 保留嵌入的子表数组
+			add(database, collection, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": documentID(r, req)}).
+				SetUpdate(bson.M{"$set": fields(r, req, req.NewRows)}).
+				SetUpsert(true))
+		}
+	}
+
+	ctx, cancel := m.context()
+	defer cancel()
+	for _, op := range operations {
+		_, err := m.client.Database(op.database).Collection(op.collection).
+			BulkWrite(ctx, op.models, options.BulkWrite().SetOrdered(true))
+		if err != nil {
+			logrus.Errorf("mongodb bulk write %s.%s err:%s", op.database, op.collection, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// embedModels 子表行嵌入父文档数组，先按子表主键 $pull 旧元素再 $push 新元素，
+// 父文档 _id 按父表规则生成
+func embedModels(rules rule.Rules, r *rule.Rule, e *embed, req *models.RowRequest) []mongo.WriteModel {
+	if len(req.PrimaryKeys) == 0 {
+		logrus.Warnf("mongodb skip embedding %s.%s without primary key", req.Schema, req.Name)
+		return nil
+	}
+	list := make([]mongo.WriteModel, 0, 3)
+	if req.OldRows != nil {
+		parentID := embedParentID(rules, e, req, req.OldRows)
+		list = append(list, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": parentID}).
+			SetUpdate(bson.M{"$pull": bson.M{e.field: elementFilter(r, req, req.OldRows)}}))
+	}
+	if req.NewRows != nil {
+		parentID := embedParentID(rules, e, req, req.NewRows)
+		element := nested(fields(r, req, req.NewRows))
+		// 新行插入时不存在旧元素，仍先 $pull 保证重放幂等
+		if req.OldRows == nil {
+			list = append(list, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": parentID}).
+				SetUpdate(bson.M{"$pull": bson.M{e.field: elementFilter(r, req, req.NewRows)}}))
+		}
+		list = append(list, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": parentID}).
+			SetUpdate(bson.M{"$push": bson.M{e.field: element}}).
+			SetUpsert(true))
+	}
+	return list
+}
+
+// embedParentID 以子表中的关联字段构造父表主键，按父表规则的 MongoIDTemplate 或主键生成 _id，
+// 因此父表的 MongoIDTemplate 只能引用主键字段
+func embedParentID(rules rule.Rules, e *embed, req *models.RowRequest, row []interface{}) interface{} {
+	parent := &models.RowRequest{
+		Schema:      req.Schema,
+		Name:        e.table,
+		Action:      canal.InsertAction,
+		PrimaryKeys: e.parentKeys,
+	}
+	for i, name := range e.columns {
+		column := models.Column{Name: e.parentKeys[i]}
+		var v interface{}
+		if index := req.ColumnIndex(name); index >= 0 {
+			column.Type, column.RawType = req.Columns[index].Type, req.Columns[index].RawType
+			if index < len(row) {
+				v = row[index]
+			}
+		}
+		parent.Columns = append(parent.Columns, column)
+		parent.NewRows = append(parent.NewRows, v)
+	}
+	return documentID(rules.Find(parent.Schema, parent.Name), parent)
+}
+
+func elementFilter(r *rule.Rule, req *models.RowRequest, row []interface{}) bson.M {
+	filter := bson.M{}
+	for _, pk := range req.PrimaryKeys {
+		index := req.ColumnIndex(pk)
+		if index < 0 || index >= len(row) {
+			continue
+		}
+		filter[r.MongoField(pk)] = value(req.Columns[index], row[index])
+	}
+	return filter
+}
+
+// documentID 配置了 MongoIDTemplate 时使用渲染结果，单主键使用主键值，联合主键使用子文档
+func documentID(r *rule.Rule, req *models.RowRequest) interface{} {
+	if r != nil && r.MongoIDTemplate != "" {
+		return sink.Render(r.MongoIDTemplate, req)
+	}
+	if len(req.PrimaryKeys) == 1 {
+		return typedValue(req.Value(req.PrimaryKeys[0]))
+	}
+	id := bson.D{}
+	for _, pk := range req.PrimaryKeys {
+		id = append(id, bson.E{Key: pk, Value: typedValue(req.Value(pk))})
+	}
+	return id
+}
+
+// fields 按 MongoFieldMappings 转换字段路径，路径可包含 . 表示嵌套字段，映射为 - 的字段不写入
+func fields(r *rule.Rule, req *models.RowRequest, row []interface{}) bson.M {
+	doc := bson.M{}
+	for i, column := range req.Columns {
+		path := r.MongoField(column.Name)
+		if path == "-" || i >= len(row) {
+			continue
+		}
+		doc[path] = value(column, row[i])
+	}
+	return doc
+}
+
+// nested 将 a.b 形式的路径展开为嵌套文档
+func nested(flat bson.M) bson.M {
+	doc := bson.M{}
+	for path, v := range flat {
+		parts := strings.Split(path, ".")
+		current := doc
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := current[part].(bson.M)
+			if !ok {
+				child = bson.M{}
+				current[part] = child
+			}
+			current = child
+		}
+		current[parts[len(parts)-1]] = v
+	}
+	return doc
+}
+
+// value binary/bit/datetime/timestamp 转为对应的 BSON 类型，数字转为 int64/float64
+func value(column models.Column, v interface{}) interface{} {
+	switch column.Type {
+	case encoder.ColumnTypeBinary, encoder.ColumnTypeBit, encoder.ColumnTypeDatetime, encoder.ColumnTypeTimestamp:
+		return sink.SQLValue(column, v)
+	}
+	return typedValue(v)
+}
+
+func typedValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		if f, err := t.Float64(); err == nil {
+			return f
+		}
+		return t.String()
+	case map[string]interface{}:
+		doc := make(bson.M, len(t))
+		for k := range t {
+			doc[k] = typedValue(t[k])
+		}
+		return doc
+	case []interface{}:
+		list := make(bson.A, len(t))
+		for i := range t {
+			list[i] = typedValue(t[i])
+		}
+		return list
+	}
+	return v
+}
+
+// parseEmbed 格式为 collection:parentKeys:field[:table]，parentKeys 以 , 分隔，
+// 每项为 column 或 column=parentColumn，对应父表主键
+func parseEmbed(s string) (*embed, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 && len(parts) != 4 {
+		return nil, fmt.Errorf("invalid mongo embed %s", s)
+	}
+	e := &embed{collection: parts[0], table: parts[0], field: parts[2]}
+	if len(parts) == 4 && parts[3] != "" {
+		e.table = parts[3]
+	}
+	for _, item := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if kv[0] == "" {
+			return nil, fmt.Errorf("invalid mongo embed %s", s)
+		}
+		parentKey := kv[0]
+		if len(kv) == 2 && kv[1] != "" {
+			parentKey = kv[1]
+		}
+		e.columns = append(e.columns, kv[0])
+		e.parentKeys = append(e.parentKeys, parentKey)
+	}
+	return e, nil
+}
+
+func (m *MongoDB) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Duration(m.TimeoutMs)*time.Millisecond)
+}
+
+func (m *MongoDB) Close() {
+	if m.client != nil {
+		m.client.Disconnect(context.Background())
+	}
+}
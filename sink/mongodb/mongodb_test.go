@@ -0,0 +1,79 @@
+package mongodb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newItemRequest() *models.RowRequest {
+	return &models.RowRequest{
+		Schema:      "shop",
+		Name:        "order_items",
+		Action:      "insert",
+		Columns:     []models.Column{{Name: "id"}, {Name: "shop_id"}, {Name: "order_id"}},
+		PrimaryKeys: []string{"id"},
+		NewRows:     []interface{}{int64(7), int64(2), int64(100)},
+	}
+}
+
+func TestParseEmbed(t *testing.T) {
+	e, err := parseEmbed("orders:shop_id,order_id=id:items:order")
+	if err != nil {
+		t.Fatalf("parseEmbed err:%s", err.Error())
+	}
+	want := &embed{
+		collection: "orders",
+		table:      "order",
+		columns:    []string{"shop_id", "order_id"},
+		parentKeys: []string{"shop_id", "id"},
+		field:      "items",
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("parseEmbed = %+v, want %+v", e, want)
+	}
+	if _, err := parseEmbed("orders:items"); err == nil {
+		t.Errorf("parseEmbed without field, want error")
+	}
+}
+
+func TestEmbedParentID(t *testing.T) {
+	tests := []struct {
+		name  string
+		embed string
+		rules rule.Rules
+		want  interface{}
+	}{
+		{
+			name:  "single key",
+			embed: "orders:order_id:items",
+			want:  int64(100),
+		},
+		{
+			name:  "parent template",
+			embed: "orders:order_id=id:items",
+			rules: rule.Rules{{Schema: "shop", Table: "orders", MongoIDTemplate: "order-{{id}}"}},
+			want:  "order-100",
+		},
+		{
+			name:  "composite key",
+			embed: "orders:shop_id,order_id=id:items",
+			want:  bson.D{{Key: "shop_id", Value: int64(2)}, {Key: "id", Value: int64(100)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := parseEmbed(tt.embed)
+			if err != nil {
+				t.Fatalf("parseEmbed err:%s", err.Error())
+			}
+			req := newItemRequest()
+			if got := embedParentID(tt.rules, e, req, req.NewRows); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("embedParentID = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package nats
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	nats "github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// NATS 开启 JetStream 时等待发布确认，并以 binlog 位置作为 Msg-Id 去重
+type NATS struct {
+	Enabled bool   `env:""`
+	URL     string `env:""`
+	// 认证方式三选一
+	User      string `env:""`
+	Password  string `env:""`
+	Token     string `env:""`
+	CredsFile string `env:""`
+	// 默认 subject 模板，可在规则中通过 NATSSubject 覆盖
+	Subject string `env:""`
+
+	JetStream bool `env:""`
+	// stream 不存在时按 StreamSubjects 创建
+	Stream         string   `env:""`
+	StreamSubjects []string `env:""`
+	// 去重窗口，需大于消费失败后的最长重试时间
+	DuplicateWindowSeconds int `env:""`
+	AckTimeoutMs           int `env:""`
+
+	conn *nats.Conn
+	js   nats.JetStreamContext
+}
+
+func (n *NATS) SetDefaults() {
+	if n.URL == "" {
+		n.URL = nats.DefaultURL
+	}
+	if n.Subject == "" {
+		n.Subject = "cdc.{{schema}}.{{table}}.{{action}}"
+	}
+	if n.Stream == "" {
+		n.Stream = "CDC"
+	}
+	if len(n.StreamSubjects) == 0 {
+		n.StreamSubjects = []string{"cdc.>"}
+	}
+	if n.DuplicateWindowSeconds == 0 {
+		n.DuplicateWindowSeconds = 120
+	}
+	if n.AckTimeoutMs == 0 {
+		n.AckTimeoutMs = 10000
+	}
+}
+
+func (n *NATS) Init() error {
+	if !n.Enabled || n.conn != nil {
+		return nil
+	}
+	options := []nats.Option{
+		nats.Name("transfer-mysql"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logrus.Warnf("nats disconnected err:%s", err.Error())
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			logrus.Infof("nats reconnected to %s", conn.ConnectedUrl())
+		}),
+	}
+	switch {
+	case n.CredsFile != "":
+		options = append(options, nats.UserCredentials(n.CredsFile))
+	case n.Token != "":
+		options = append(options, nats.Token(n.Token))
+	case n.User != "":
+		options = append(options, nats.UserInfo(n.User, n.Password))
+	}
+
+	conn, err := nats.Connect(n.URL, options...)
+	if err != nil {
+		logrus.Errorf("nats connect err:%s", err.Error())
+		return err
+	}
+	if n.JetStream {
+		js, err := conn.JetStream()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		if err := n.ensureStream(js); err != nil {
+			logrus.Errorf("nats ensure stream %s err:%s", n.Stream, err.Error())
+			conn.Close()
+			return err
+		}
+		n.js = js
+	}
+	n.conn = conn
+	return nil
+}
+
+func (n *NATS) ensureStream(js nats.JetStreamContext) error {
+	if _, err := js.StreamInfo(n.Stream); err == nil {
+		return nil
+	}
+	_, err := js.AddStream(&nats.StreamConfig{
+		Name:       n.Stream,
+		Subjects:   n.StreamSubjects,
+		Storage:    nats.FileStorage,
+		Duplicates: time.Duration(n.DuplicateWindowSeconds) * time.Second,
+	})
+	if err == nil {
+		logrus.Infof("nats create stream %s", n.Stream)
+	}
+	return err
+}
+
+func (n *NATS) String() string { return "nats" }
+
+func (n *NATS) IsEnabled() bool {
+	return n != nil && n.Enabled
+}
+
+func (n *NATS) ChangedColumns(r *rule.Rule) bool { return true }
+
+func (n *NATS) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	messages := make([]*nats.Msg, 0, len(requests))
+	for _, req := range requests {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		subject := n.Subject
+		if r := rules.Find(req.Schema, req.Name); r != nil && r.NATSSubject != "" {
+			subject = r.NATSSubject
+		}
+		msg := &nats.Msg{
+			Subject: sink.Render(subject, req),
+			Data:    data,
+			Header:  nats.Header{},
+		}
+		// 重试时 Msg-Id 不变，JetStream 在去重窗口内丢弃重复消息
+		msg.Header.Set(nats.MsgIdHdr, req.Position())
+		messages = append(messages, msg)
+	}
+	if n.js == nil {
+		return n.publish(messages)
+	}
+	return n.publishJetStream(messages)
+}
+
+func (n *NATS) publish(messages []*nats.Msg) error {
+	for _, msg := range messages {
+		if err := n.conn.PublishMsg(msg); err != nil {
+			return err
+		}
+	}
+	return n.conn.FlushTimeout(time.Duration(n.AckTimeoutMs) * time.Millisecond)
+}
+
+// publishJetStream 异步发布后等待全部确认
+func (n *NATS) publishJetStream(messages []*nats.Msg) error {
+	futures := make([]nats.PubAckFuture, 0, len(messages))
+	for _, msg := range messages {
+		future, err := n.js.PublishMsgAsync(msg)
+		if err != nil {
+			return err
+		}
+		futures = append(futures, future)
+	}
+
+	timeout := time.After(time.Duration(n.AckTimeoutMs) * time.Millisecond)
+	for _, future := range futures {
+		select {
+		case ack := <-future.Ok():
+			if ack.Duplicate {
+				logrus.Debugf("nats duplicate message %s seq %d", future.Msg().Header.Get(nats.MsgIdHdr), ack.Sequence)
+			}
+		case err := <-future.Err():
+			return err
+		case <-timeout:
+			return errors.New("nats wait publish ack timeout")
+		}
+	}
+	return nil
+}
+
+func (n *NATS) Close() {
+	if n.conn != nil {
+		n.conn.Drain()
+	}
+}
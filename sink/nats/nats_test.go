@@ -0,0 +1,122 @@
+package nats
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/nats-io/nats-server/v2/server"
+	nats "github.com/nats-io/nats.go"
+	"github.com/siddontang/go-mysql/canal"
+)
+
+func runServer(t *testing.T) *server.Server {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("new nats server err:%s", err.Error())
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatalf("nats server not ready")
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func newTestNATS(t *testing.T, jetStream bool) *NATS {
+	s := runServer(t)
+	n := &NATS{Enabled: true, URL: s.ClientURL(), JetStream: jetStream}
+	n.SetDefaults()
+	if err := n.Init(); err != nil {
+		t.Fatalf("init err:%s", err.Error())
+	}
+	t.Cleanup(n.Close)
+	return n
+}
+
+func newRequest(id int, pos uint32) *models.RowRequest {
+	return &models.RowRequest{
+		Schema:      "db",
+		Name:        "user",
+		Action:      canal.InsertAction,
+		Columns:     []models.Column{{Name: "id"}},
+		PrimaryKeys: []string{"id"},
+		NewRows:     []interface{}{id},
+		LogName:     "mysql-bin.000001",
+		LogPos:      pos,
+	}
+}
+
+func TestWriteCore(t *testing.T) {
+	n := newTestNATS(t, false)
+	sub, err := n.conn.SubscribeSync("audit.>")
+	if err != nil {
+		t.Fatalf("subscribe err:%s", err.Error())
+	}
+	rules := rule.Rules{{Schema: "db", Table: "user", NATSSubject: "audit.{{table}}.{{action}}"}}
+	if err := n.Write(rules, []*models.RowRequest{newRequest(1, 100)}); err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("next msg err:%s", err.Error())
+	}
+	if msg.Subject != "audit.user.insert" || msg.Header.Get(nats.MsgIdHdr) != "mysql-bin.000001:100:0" {
+		t.Errorf("msg subject:%s header:%v", msg.Subject, msg.Header)
+	}
+}
+
+func TestWriteJetStreamDeduplicate(t *testing.T) {
+	n := newTestNATS(t, true)
+	info, err := n.js.StreamInfo(n.Stream)
+	if err != nil {
+		t.Fatalf("stream info err:%s", err.Error())
+	}
+	if info.Config.Duplicates != 120*time.Second {
+		t.Errorf("stream duplicates = %s, want 2m", info.Config.Duplicates)
+	}
+
+	requests := []*models.RowRequest{newRequest(1, 100), newRequest(2, 200)}
+	// 重试时相同位置的消息被去重
+	for i := 0; i < 2; i++ {
+		if err := n.Write(nil, requests); err != nil {
+			t.Fatalf("Write err:%s", err.Error())
+		}
+	}
+	info, err = n.js.StreamInfo(n.Stream)
+	if err != nil {
+		t.Fatalf("stream info err:%s", err.Error())
+	}
+	if info.State.Msgs != 2 {
+		t.Errorf("stream msgs = %d, want 2", info.State.Msgs)
+	}
+
+	msg, err := n.js.GetMsg(n.Stream, 2)
+	if err != nil {
+		t.Fatalf("get msg err:%s", err.Error())
+	}
+	var req models.RowRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil || req.LogPos != 200 {
+		t.Errorf("stream msg = %s", msg.Data)
+	}
+	if msg.Subject != "cdc.db.user.insert" {
+		t.Errorf("stream msg subject = %s", msg.Subject)
+	}
+}
+
+func TestWriteJetStreamNoStream(t *testing.T) {
+	n := newTestNATS(t, true)
+	rules := rule.Rules{{Schema: "db", Table: "user", NATSSubject: "other.{{table}}"}}
+	if err := n.Write(rules, []*models.RowRequest{newRequest(1, 100)}); err == nil {
+		t.Errorf("Write to a subject without stream should fail")
+	}
+}
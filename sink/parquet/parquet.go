@@ -0,0 +1,402 @@
+package parquet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/objectstore"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/sirupsen/logrus"
+	"github.com/xitongsys/parquet-go-source/local"
+	pq "github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	TargetLocal = "local"
+	TargetS3    = "s3"
+
+	stagingSuffix = ".jsonl"
+)
+
+// Parquet 按表与小时分区写入 Parquet 文件，分区格式为 table=schema.table/dt=YYYY-MM-DD/hour=HH。
+// 事件先追加到本地暂存文件并 fsync，达到大小或时长后转换为 Parquet 写入本地目录或 S3，
+// 转换失败的暂存文件保留并定时重试
+type Parquet struct {
+	Enabled bool `env:""`
+	// local/s3
+	Target string `env:""`
+	// 本地输出目录
+	Dir        string `env:""`
+	StagingDir string `env:""`
+	// S3 对象 key 前缀
+	Prefix string `env:""`
+	// 暂存文件达到大小或时长后生成 Parquet 文件
+	MaxSizeMB     int `env:""`
+	MaxAgeSeconds int `env:""`
+	// snappy/gzip/none
+	Compression string         `env:""`
+	S3          objectstore.S3 `env:""`
+
+	mu      sync.Mutex
+	buffers map[string]*buffer
+	// 已关闭等待转换的暂存文件
+	pending []string
+	// 转换及上传在 mu 之外进行，flushMu 保证同一时间只有一个转换
+	flushMu sync.Mutex
+	flush   chan struct{}
+	done    chan struct{}
+}
+
+type buffer struct {
+	partition string
+	path      string
+	file      *os.File
+	size      int64
+	columns   string
+	created   time.Time
+}
+
+func (p *Parquet) SetDefaults() {
+	if p.Target == "" {
+		p.Target = TargetLocal
+	}
+	if p.Dir == "" {
+		p.Dir = "data/lake"
+	}
+	if p.StagingDir == "" {
+		p.StagingDir = "data/lake_staging"
+	}
+	if p.MaxSizeMB == 0 {
+		p.MaxSizeMB = 128
+	}
+	if p.MaxAgeSeconds == 0 {
+		p.MaxAgeSeconds = 600
+	}
+	if p.Compression == "" {
+		p.Compression = "snappy"
+	}
+	p.S3.SetDefaults()
+}
+
+func (p *Parquet) Init() error {
+	if !p.Enabled || p.buffers != nil {
+		return nil
+	}
+	if p.Target == TargetS3 {
+		if err := p.S3.Init(); err != nil {
+			logrus.Errorf("parquet init s3 err:%s", err.Error())
+			return err
+		}
+	} else if p.Target != TargetLocal {
+		return fmt.Errorf("unsupported parquet target %s", p.Target)
+	}
+	if _, err := compressionCodec(p.Compression); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.StagingDir, 0755); err != nil {
+		return err
+	}
+
+	// 上次退出时未转换的暂存文件
+	err := filepath.Walk(p.StagingDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(path, stagingSuffix) {
+			p.pending = append(p.pending, path)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(p.pending)
+	p.buffers = make(map[string]*buffer)
+	p.flush = make(chan struct{}, 1)
+	p.done = make(chan struct{})
+	if len(p.pending) > 0 {
+		p.flush <- struct{}{}
+	}
+	go p.rollLoop()
+	return nil
+}
+
+func (p *Parquet) rollLoop() {
+	ticker := time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			for partition, b := range p.buffers {
+				if time.Since(b.created) >= time.Duration(p.MaxAgeSeconds)*time.Second {
+					p.seal(partition)
+				}
+			}
+			p.mu.Unlock()
+			p.flushPending()
+		case <-p.flush:
+			p.flushPending()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Parquet) String() string { return "parquet" }
+
+func (p *Parquet) IsEnabled() bool {
+	return p != nil && p.Enabled
+}
+
+func (p *Parquet) ChangedColumns(r *rule.Rule) bool { return true }
+
+// Write 暂存文件 fsync 后返回，Parquet 在后台生成，失败不影响本次写入
+func (p *Parquet) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	touched := make(map[string]*buffer)
+	for _, req := range requests {
+		partition := partitionOf(req)
+		columns := columnsKey(req.Columns)
+		b, ok := p.buffers[partition]
+		if ok && b.columns != columns {
+			// 表结构变化后使用新的 schema
+			p.seal(partition)
+			ok = false
+		}
+		if !ok {
+			var err error
+			b, err = p.openBuffer(partition, columns)
+			if err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		n, err := b.file.Write(append(data, '\n'))
+		if err != nil {
+			return err
+		}
+		b.size += int64(n)
+		touched[partition] = b
+	}
+
+	for partition, b := range touched {
+		if err := b.file.Sync(); err != nil {
+			logrus.Errorf("parquet sync staging %s err:%s", b.path, err.Error())
+			return err
+		}
+		if b.size >= int64(p.MaxSizeMB)<<20 {
+			p.seal(partition)
+		}
+	}
+	return nil
+}
+
+func (p *Parquet) openBuffer(partition, columns string) (*buffer, error) {
+	dir := filepath.Join(p.StagingDir, filepath.FromSlash(partition))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("part-%d%s", time.Now().UnixNano(), stagingSuffix))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	b := &buffer{partition: partition, path: path, file: f, columns: columns, created: time.Now()}
+	p.buffers[partition] = b
+	return b, nil
+}
+
+// seal 关闭暂存文件并加入待转换列表，调用方需持有 mu
+func (p *Parquet) seal(partition string) {
+	b, ok := p.buffers[partition]
+	if !ok {
+		return
+	}
+	delete(p.buffers, partition)
+	if err := b.file.Close(); err != nil {
+		logrus.Errorf("parquet close staging %s err:%s", b.path, err.Error())
+	}
+	p.pending = append(p.pending, b.path)
+	// 通知后台转换
+	select {
+	case p.flush <- struct{}{}:
+	default:
+	}
+}
+
+// flushPending 取出待转换列表后释放 mu 再转换上传，失败的文件放回列表等待重试
+func (p *Parquet) flushPending() {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+	p.mu.Lock()
+	pending := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+
+	failed := make([]string, 0)
+	for _, path := range pending {
+		if err := p.convert(path); err != nil {
+			logrus.Errorf("parquet convert %s err:%s", path, err.Error())
+			failed = append(failed, path)
+		}
+	}
+	p.mu.Lock()
+	p.pending = append(failed, p.pending...)
+	p.mu.Unlock()
+}
+
+// convert 将暂存文件转换为 Parquet 写入目标位置，成功后删除暂存文件
+func (p *Parquet) convert(path string) error {
+	requests, err := readStaging(path)
+	if err != nil {
+		return err
+	}
+	if len(requests) == 0 {
+		return os.Remove(path)
+	}
+	rel, err := filepath.Rel(p.StagingDir, path)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(filepath.ToSlash(rel), stagingSuffix) + ".parquet"
+
+	if p.Target == TargetS3 {
+		tmp := strings.TrimSuffix(path, stagingSuffix) + ".parquet.tmp"
+		defer os.Remove(tmp)
+		if err := p.writeParquet(tmp, requests); err != nil {
+			return err
+		}
+		key := strings.Trim(p.Prefix, "/")
+		if key != "" {
+			key += "/"
+		}
+		headers := http.Header{"Content-Type": {"application/octet-stream"}}
+		if err := p.S3.UploadFile(key+name, tmp, headers); err != nil {
+			return err
+		}
+	} else {
+		// 临时文件与目标文件在同一目录，Dir 与 StagingDir 不在同一文件系统时 rename 仍然有效
+		target := filepath.Join(p.Dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		tmp := target + ".tmp"
+		if err := p.writeParquet(tmp, requests); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, target); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	logrus.Infof("parquet write %s rows:%d", name, len(requests))
+	return os.Remove(path)
+}
+
+func (p *Parquet) writeParquet(path string, requests []*models.RowRequest) error {
+	schema, err := buildSchema(requests[0].Columns)
+	if err != nil {
+		return err
+	}
+	fw, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+	pw, err := writer.NewJSONWriter(schema, fw, 1)
+	if err != nil {
+		return err
+	}
+	pw.CompressionType, _ = compressionCodec(p.Compression)
+	for _, req := range requests {
+		data, err := json.Marshal(row(req))
+		if err != nil {
+			return err
+		}
+		if err := pw.Write(string(data)); err != nil {
+			return err
+		}
+	}
+	return pw.WriteStop()
+}
+
+func readStaging(path string) ([]*models.RowRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	requests := make([]*models.RowRequest, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var req models.RowRequest
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&req); err != nil {
+			// 异常退出时最后一行可能不完整
+			logrus.Warnf("parquet skip invalid staging line in %s err:%s", path, err.Error())
+			continue
+		}
+		requests = append(requests, &req)
+	}
+	return requests, scanner.Err()
+}
+
+// partitionOf 按事件时间(UTC)分区
+func partitionOf(req *models.RowRequest) string {
+	t := time.Unix(int64(req.Timestamp), 0).UTC()
+	return fmt.Sprintf("table=%s.%s/dt=%s/hour=%02d", req.Schema, req.Name, t.Format("2006-01-02"), t.Hour())
+}
+
+func columnsKey(columns []models.Column) string {
+	var b strings.Builder
+	for i := range columns {
+		b.WriteString(columns[i].Name + ":" + columns[i].RawType + ",")
+	}
+	return b.String()
+}
+
+func compressionCodec(name string) (pq.CompressionCodec, error) {
+	switch name {
+	case "snappy":
+		return pq.CompressionCodec_SNAPPY, nil
+	case "gzip":
+		return pq.CompressionCodec_GZIP, nil
+	case "none":
+		return pq.CompressionCodec_UNCOMPRESSED, nil
+	}
+	return 0, fmt.Errorf("unsupported parquet compression %s", name)
+}
+
+func (p *Parquet) Close() {
+	if p.buffers == nil {
+		return
+	}
+	close(p.done)
+	p.mu.Lock()
+	for partition := range p.buffers {
+		p.seal(partition)
+	}
+	p.mu.Unlock()
+	p.flushPending()
+}
@@ -0,0 +1,145 @@
+package parquet
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func newRequest(id int64) *models.RowRequest {
+	return &models.RowRequest{
+		Schema:      "db",
+		Name:        "user",
+		Action:      "insert",
+		Columns:     []models.Column{{Name: "id", Type: encoder.ColumnTypeNumber, RawType: "bigint"}, {Name: "name", RawType: "varchar(32)"}},
+		PrimaryKeys: []string{"id"},
+		NewRows:     []interface{}{id, "name"},
+		Timestamp:   1609459200,
+	}
+}
+
+func newTestParquet(t *testing.T, target string) *Parquet {
+	dir := t.TempDir()
+	p := &Parquet{Enabled: true, Target: target, Dir: filepath.Join(dir, "lake"), StagingDir: filepath.Join(dir, "staging")}
+	p.SetDefaults()
+	return p
+}
+
+func files(dir, suffix string) []string {
+	var list []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(path, suffix) {
+			list = append(list, path)
+		}
+		return nil
+	})
+	return list
+}
+
+func TestWriteLocal(t *testing.T) {
+	p := newTestParquet(t, TargetLocal)
+	if err := p.Init(); err != nil {
+		t.Fatalf("init err:%s", err.Error())
+	}
+	if err := p.Write(nil, []*models.RowRequest{newRequest(1), newRequest(2)}); err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	p.Close()
+
+	list := files(p.Dir, ".parquet")
+	if len(list) != 1 || !strings.Contains(filepath.ToSlash(list[0]), "table=db.user/dt=2021-01-01/hour=00/") {
+		t.Errorf("parquet files = %v, want one file in partition", list)
+	}
+	if tmp := files(p.Dir, ".tmp"); len(tmp) != 0 {
+		t.Errorf("tmp files = %v, want none", tmp)
+	}
+	if len(list) == 1 {
+		fr, err := local.NewLocalFileReader(list[0])
+		if err != nil {
+			t.Fatalf("open parquet err:%s", err.Error())
+		}
+		defer fr.Close()
+		pr, err := reader.NewParquetReader(fr, nil, 1)
+		if err != nil {
+			t.Fatalf("read parquet err:%s", err.Error())
+		}
+		if pr.GetNumRows() != 2 {
+			t.Errorf("rows = %d, want 2", pr.GetNumRows())
+		}
+		pr.ReadStop()
+	}
+	if staging := files(p.StagingDir, stagingSuffix); len(staging) != 0 {
+		t.Errorf("staging files = %v, want none", staging)
+	}
+}
+
+// TestUploadOutsideLock 上传阻塞时 Write 不受影响
+func TestUploadOutsideLock(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		<-release
+		mu.Lock()
+		keys = append(keys, r.URL.Path)
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	p := newTestParquet(t, TargetS3)
+	p.Prefix = "cdc"
+	p.S3.Endpoint = server.URL
+	p.S3.Bucket = "lake"
+	if err := p.Init(); err != nil {
+		t.Fatalf("init err:%s", err.Error())
+	}
+	if err := p.Write(nil, []*models.RowRequest{newRequest(1)}); err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	p.mu.Lock()
+	p.seal(partitionOf(newRequest(1)))
+	p.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		// 等待后台开始上传
+		time.Sleep(100 * time.Millisecond)
+		done <- p.Write(nil, []*models.RowRequest{newRequest(2)})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Write err:%s", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Write blocked by upload")
+	}
+	close(release)
+	p.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(keys) != 2 {
+		t.Fatalf("uploaded = %v, want 2 objects", keys)
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "/lake/cdc/table=db.user/dt=2021-01-01/hour=00/") || !strings.HasSuffix(key, ".parquet") {
+			t.Errorf("uploaded key = %s, want parquet in partition", key)
+		}
+	}
+	if tmp := files(p.StagingDir, ".tmp"); len(tmp) != 0 {
+		t.Errorf("tmp files = %v, want none", tmp)
+	}
+}
@@ -0,0 +1,117 @@
+package parquet
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/sink"
+)
+
+// parquet-go v1.5 中逻辑类型直接写在 type 中
+const (
+	typeString    = "type=UTF8"
+	typeInt32     = "type=INT32"
+	typeInt64     = "type=INT64"
+	typeFloat     = "type=FLOAT"
+	typeDouble    = "type=DOUBLE"
+	typeDate      = "type=DATE"
+	typeTimestamp = "type=TIMESTAMP_MICROS"
+)
+
+type schemaNode struct {
+	Tag    string        `json:"Tag"`
+	Fields []*schemaNode `json:"Fields,omitempty"`
+}
+
+// metaFields 每行附带的事件元数据
+var metaFields = []*schemaNode{
+	{Tag: "name=_action, " + typeString + ", repetitiontype=REQUIRED"},
+	{Tag: "name=_position, " + typeString + ", repetitiontype=REQUIRED"},
+	{Tag: "name=_version, " + typeInt64 + ", repetitiontype=REQUIRED"},
+	{Tag: "name=_timestamp, type=TIMESTAMP_MILLIS, repetitiontype=REQUIRED"},
+}
+
+// buildSchema 按 MySQL 字段类型生成 parquet-go 的 JSON schema
+func buildSchema(columns []models.Column) (string, error) {
+	root := &schemaNode{Tag: "name=parquet_go_root, repetitiontype=REQUIRED"}
+	root.Fields = append(root.Fields, metaFields...)
+	for _, column := range columns {
+		root.Fields = append(root.Fields, &schemaNode{
+			Tag: "name=" + column.Name + ", " + columnType(column) + ", repetitiontype=OPTIONAL",
+		})
+	}
+	data, err := json.Marshal(root)
+	return string(data), err
+}
+
+func columnType(column models.Column) string {
+	rawType := strings.ToLower(column.RawType)
+	unsigned := strings.Contains(rawType, "unsigned")
+	switch column.Type {
+	case encoder.ColumnTypeNumber:
+		switch {
+		case strings.HasPrefix(rawType, "bigint"):
+			// unsigned bigint 可能超出 INT64
+			if unsigned {
+				return typeString
+			}
+			return typeInt64
+		case strings.HasPrefix(rawType, "int") || strings.HasPrefix(rawType, "mediumint"):
+			if unsigned {
+				return typeInt64
+			}
+			return typeInt32
+		}
+		return typeInt32
+	case encoder.ColumnTypeFloat:
+		if strings.HasPrefix(rawType, "float") {
+			return typeFloat
+		}
+		return typeDouble
+	case encoder.ColumnTypeDate:
+		return typeDate
+	case encoder.ColumnTypeDatetime, encoder.ColumnTypeTimestamp:
+		return typeTimestamp
+	}
+	return typeString
+}
+
+// row 生成写入 parquet 的一行，delete 使用修改前的行
+func row(req *models.RowRequest) map[string]interface{} {
+	values := req.Row()
+	r := make(map[string]interface{}, len(req.Columns)+len(metaFields))
+	r["_action"] = req.Action
+	r["_position"] = req.Position()
+	r["_version"] = req.Version()
+	r["_timestamp"] = int64(req.Timestamp) * 1000
+	for i, column := range req.Columns {
+		if i < len(values) {
+			r[column.Name] = value(column, values[i])
+		}
+	}
+	return r
+}
+
+func value(column models.Column, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch columnType(column) {
+	case typeString:
+		return sink.StringValue(v)
+	case typeDate:
+		if t, err := time.Parse("2006-01-02", sink.StringValue(v)); err == nil {
+			return t.Unix() / 86400
+		}
+		return nil
+	case typeTimestamp:
+		if t, ok := sink.SQLValue(column, v).(time.Time); ok {
+			return t.UnixNano() / int64(time.Microsecond)
+		}
+		return nil
+	}
+	return v
+}
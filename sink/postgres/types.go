@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"strings"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+)
+
+// defaultTypeMappings MySQL 字段类型到 Postgres 类型的默认映射，可通过 TypeMappings 覆盖
+var defaultTypeMappings = map[string]string{
+	"tinyint(1)":         "boolean",
+	"tinyint":            "smallint",
+	"tinyint unsigned":   "smallint",
+	"smallint":           "smallint",
+	"smallint unsigned":  "integer",
+	"mediumint":          "integer",
+	"mediumint unsigned": "integer",
+	"int":                "integer",
+	"int unsigned":       "bigint",
+	"bigint":             "bigint",
+	"bigint unsigned":    "numeric(20)",
+	"float":              "real",
+	"double":             "double precision",
+	"decimal":            "numeric",
+	"year":               "smallint",
+	"bit":                "bigint",
+	"bit(1)":             "boolean",
+	"char":               "text",
+	"varchar":            "text",
+	"tinytext":           "text",
+	"text":               "text",
+	"mediumtext":         "text",
+	"longtext":           "text",
+	"enum":               "text",
+	"set":                "text",
+	"binary":             "bytea",
+	"varbinary":          "bytea",
+	"tinyblob":           "bytea",
+	"blob":               "bytea",
+	"mediumblob":         "bytea",
+	"longblob":           "bytea",
+	"date":               "date",
+	"time":               "time",
+	"datetime":           "timestamptz",
+	"timestamp":          "timestamptz",
+	"json":               "jsonb",
+	"geometry":           "text",
+	"point":              "text",
+	"linestring":         "text",
+	"polygon":            "text",
+}
+
+// typeMapper 依次按完整类型(如 tinyint(1))、带 unsigned 的基础类型、基础类型查找映射
+type typeMapper map[string]string
+
+func newTypeMapper(mappings []string) typeMapper {
+	m := make(typeMapper, len(defaultTypeMappings)+len(mappings))
+	for k, v := range defaultTypeMappings {
+		m[k] = v
+	}
+	for _, item := range mappings {
+		// 格式为 mysqlType:pgType，如 tinyint(1):boolean
+		i := strings.LastIndex(item, ":")
+		if i <= 0 {
+			continue
+		}
+		m[normalizeType(item[:i])] = strings.TrimSpace(item[i+1:])
+	}
+	return m
+}
+
+func (m typeMapper) Type(column models.Column) string {
+	rawType := normalizeType(column.RawType)
+	if t, ok := m[rawType]; ok {
+		return t
+	}
+	base := rawType
+	args := ""
+	if i := strings.Index(rawType, "("); i >= 0 {
+		base = rawType[:i]
+		if j := strings.Index(rawType, ")"); j > i {
+			args = rawType[i : j+1]
+		}
+	}
+	unsigned := strings.Contains(rawType, "unsigned")
+	if unsigned {
+		if t, ok := m[base+" unsigned"]; ok {
+			return t
+		}
+	}
+	if t, ok := m[base]; ok {
+		// 保留 decimal 的精度
+		if t == "numeric" && args != "" {
+			return t + args
+		}
+		return t
+	}
+	return "text"
+}
+
+func normalizeType(t string) string {
+	t = strings.ToLower(strings.TrimSpace(t))
+	return strings.Join(strings.Fields(t), " ")
+}
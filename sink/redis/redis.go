@@ -0,0 +1,218 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ModeHash   = "hash"
+	ModeString = "string"
+	ModeZSet   = "zset"
+	ModeList   = "list"
+	ModeStream = "stream"
+)
+
+type Redis struct {
+	Enabled bool `env:""`
+	// 多个地址时使用 cluster 模式
+	Addrs    []string `env:""`
+	Password string   `env:""`
+	DB       int      `env:""`
+	// 默认写入方式与 key 模板，可在规则中覆盖
+	Mode      string `env:""`
+	Key       string `env:""`
+	TimeoutMs int    `env:""`
+
+	client goredis.UniversalClient
+}
+
+func (r *Redis) SetDefaults() {
+	if len(r.Addrs) == 0 {
+		r.Addrs = []string{"127.0.0.1:6379"}
+	}
+	if r.Mode == "" {
+		r.Mode = ModeHash
+	}
+	if r.Key == "" {
+		r.Key = "{{schema}}:{{table}}:{{pk}}"
+	}
+	if r.TimeoutMs == 0 {
+		r.TimeoutMs = 5000
+	}
+}
+
+func (r *Redis) Init() error {
+	if !r.Enabled || r.client != nil {
+		return nil
+	}
+	client := goredis.NewUniversalClient(&goredis.UniversalOptions{
+		Addrs:    r.Addrs,
+		Password: r.Password,
+		DB:       r.DB,
+	})
+	ctx, cancel := r.context()
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		logrus.Errorf("redis ping err:%s", err.Error())
+		return err
+	}
+	r.client = client
+	return nil
+}
+
+func (r *Redis) GetClient() goredis.UniversalClient {
+	return r.client
+}
+
+func (r *Redis) String() string { return "redis" }
+
+func (r *Redis) IsEnabled() bool {
+	return r != nil && r.Enabled
+}
+
+// ChangedColumns list/stream 模式按事件追加，可以只写入变化的字段，其他模式按行写入完整字段
+func (r *Redis) ChangedColumns(rl *rule.Rule) bool {
+	mode := r.mode(rl)
+	return mode == ModeList || mode == ModeStream
+}
+
+func (r *Redis) mode(rl *rule.Rule) string {
+	if rl != nil && rl.RedisMode != "" {
+		return strings.ToLower(rl.RedisMode)
+	}
+	return r.Mode
+}
+
+func (r *Redis) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	ctx, cancel := r.context()
+	defer cancel()
+
+	pipe := r.client.Pipeline()
+	for _, req := range requests {
+		rl := rules.Find(req.Schema, req.Name)
+		if err := r.write(ctx, pipe, rl, req); err != nil {
+			return err
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	if err == goredis.Nil {
+		return nil
+	}
+	return err
+}
+
+func (r *Redis) write(ctx context.Context, pipe goredis.Pipeliner, rl *rule.Rule, req *models.RowRequest) error {
+	mode, keyTemplate := r.mode(rl), r.Key
+	var ttl time.Duration
+	var scoreColumn string
+	if rl != nil {
+		if rl.RedisKey != "" {
+			keyTemplate = rl.RedisKey
+		}
+		ttl = time.Duration(rl.RedisTTLSeconds) * time.Second
+		scoreColumn = rl.RedisScoreColumn
+	}
+	key := sink.Render(keyTemplate, req)
+
+	switch mode {
+	case ModeHash, ModeString:
+		if req.Action == canal.UpdateAction {
+			// 主键或 key 相关字段修改后清理旧 key
+			if oldKey := sink.RenderBefore(keyTemplate, req); oldKey != key {
+				pipe.Del(ctx, oldKey)
+			}
+		}
+		if req.Action == canal.DeleteAction {
+			pipe.Del(ctx, key)
+			return nil
+		}
+		if mode == ModeHash {
+			pipe.HSet(ctx, key, rowFields(req))
+		} else {
+			data, err := json.Marshal(rowValues(req))
+			if err != nil {
+				return err
+			}
+			pipe.Set(ctx, key, data, 0)
+		}
+	case ModeZSet:
+		member := sink.PrimaryKey(req)
+		if req.Action == canal.DeleteAction {
+			pipe.ZRem(ctx, key, member)
+			return nil
+		}
+		score, err := strconv.ParseFloat(sink.StringValue(req.Value(scoreColumn)), 64)
+		if err != nil {
+			score = float64(req.Timestamp)
+		}
+		pipe.ZAdd(ctx, key, &goredis.Z{Score: score, Member: member})
+	case ModeList:
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		pipe.RPush(ctx, key, data)
+	case ModeStream:
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		pipe.XAdd(ctx, &goredis.XAddArgs{
+			Stream: key,
+			Values: map[string]interface{}{
+				"action": req.Action,
+				"data":   string(data),
+			},
+		})
+	default:
+		logrus.Warnf("unknown redis mode %s", mode)
+		return nil
+	}
+
+	if ttl > 0 {
+		pipe.Expire(ctx, key, ttl)
+	}
+	return nil
+}
+
+func rowFields(req *models.RowRequest) map[string]interface{} {
+	fields := rowValues(req)
+	for name, value := range fields {
+		fields[name] = sink.StringValue(value)
+	}
+	return fields
+}
+
+func rowValues(req *models.RowRequest) map[string]interface{} {
+	row := req.Row()
+	values := make(map[string]interface{}, len(req.Columns))
+	for i := range req.Columns {
+		var value interface{}
+		if i < len(row) {
+			value = row[i]
+		}
+		values[req.Columns[i].Name] = value
+	}
+	return values
+}
+
+func (r *Redis) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Duration(r.TimeoutMs)*time.Millisecond)
+}
+
+func (r *Redis) Close() {
+	if r.client != nil {
+		r.client.Close()
+	}
+}
@@ -0,0 +1,137 @@
+package redis
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/siddontang/go-mysql/canal"
+)
+
+func newTestRedis(t *testing.T) (*Redis, *miniredis.Miniredis) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("run miniredis err:%s", err.Error())
+	}
+	t.Cleanup(server.Close)
+	r := &Redis{Enabled: true, Addrs: []string{server.Addr()}}
+	r.SetDefaults()
+	if err := r.Init(); err != nil {
+		t.Fatalf("init redis err:%s", err.Error())
+	}
+	t.Cleanup(r.Close)
+	return r, server
+}
+
+func newRequest(action string, oldRow, newRow []interface{}) *models.RowRequest {
+	return &models.RowRequest{
+		Schema:      "db",
+		Name:        "user",
+		Action:      action,
+		Columns:     []models.Column{{Name: "id"}, {Name: "name"}, {Name: "score"}},
+		PrimaryKeys: []string{"id"},
+		OldRows:     oldRow,
+		NewRows:     newRow,
+		Timestamp:   100,
+	}
+}
+
+func TestWriteHash(t *testing.T) {
+	r, server := newTestRedis(t)
+	err := r.Write(nil, []*models.RowRequest{
+		newRequest(canal.InsertAction, nil, []interface{}{1, "a", 10}),
+		newRequest(canal.InsertAction, nil, []interface{}{2, "b", 20}),
+	})
+	if err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	if got := server.HGet("db:user:1", "name"); got != "a" {
+		t.Errorf("hash name = %s, want a", got)
+	}
+
+	// 主键修改后清理旧 key
+	err = r.Write(nil, []*models.RowRequest{
+		newRequest(canal.UpdateAction, []interface{}{1, "a", 10}, []interface{}{3, "a", 10}),
+		newRequest(canal.DeleteAction, []interface{}{2, "b", 20}, nil),
+	})
+	if err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	if server.Exists("db:user:1") || server.Exists("db:user:2") {
+		t.Errorf("old keys should be deleted, keys:%v", server.Keys())
+	}
+	if got := server.HGet("db:user:3", "score"); got != "10" {
+		t.Errorf("hash score = %s, want 10", got)
+	}
+}
+
+func TestWriteString(t *testing.T) {
+	r, server := newTestRedis(t)
+	rules := rule.Rules{{Schema: "db", Table: "user", RedisMode: ModeString, RedisKey: "user:{{id}}", RedisTTLSeconds: 60}}
+	err := r.Write(rules, []*models.RowRequest{newRequest(canal.InsertAction, nil, []interface{}{1, "a", 10})})
+	if err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	data, err := server.Get("user:1")
+	if err != nil {
+		t.Fatalf("get err:%s", err.Error())
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &values); err != nil {
+		t.Fatalf("unmarshal err:%s", err.Error())
+	}
+	if values["name"] != "a" || values["score"] != float64(10) {
+		t.Errorf("string value = %s", data)
+	}
+	if ttl := server.TTL("user:1"); ttl.Seconds() != 60 {
+		t.Errorf("ttl = %s, want 60s", ttl)
+	}
+	if r.ChangedColumns(rules.Find("db", "user")) || r.ChangedColumns(nil) {
+		t.Errorf("string and hash modes should require full columns")
+	}
+}
+
+func TestWriteZSetAndList(t *testing.T) {
+	r, server := newTestRedis(t)
+	rules := rule.Rules{
+		{Schema: "db", Table: "user", RedisMode: ModeZSet, RedisKey: "rank", RedisScoreColumn: "score"},
+		{Schema: "db", Table: "log", RedisMode: ModeList, RedisKey: "logs"},
+	}
+	log := newRequest(canal.InsertAction, nil, []interface{}{1, "x", 0})
+	log.Name = "log"
+	err := r.Write(rules, []*models.RowRequest{
+		newRequest(canal.InsertAction, nil, []interface{}{1, "a", 10}),
+		newRequest(canal.InsertAction, nil, []interface{}{2, "b", 20}),
+		newRequest(canal.DeleteAction, []interface{}{1, "a", 10}, nil),
+		log,
+	})
+	if err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	members, err := server.ZMembers("rank")
+	if err != nil || len(members) != 1 || members[0] != "2" {
+		t.Errorf("zset members = %v, err:%v", members, err)
+	}
+	if score, _ := server.ZScore("rank", "2"); score != 20 {
+		t.Errorf("zset score = %f, want 20", score)
+	}
+	list, err := server.List("logs")
+	if err != nil || len(list) != 1 {
+		t.Fatalf("list = %v, err:%v", list, err)
+	}
+	var req models.RowRequest
+	if err := json.Unmarshal([]byte(list[0]), &req); err != nil || req.Name != "log" {
+		t.Errorf("list item = %s", list[0])
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	r, server := newTestRedis(t)
+	server.SetError("READONLY You can't write against a read only replica")
+	err := r.Write(nil, []*models.RowRequest{newRequest(canal.InsertAction, nil, []interface{}{1, "a", 10})})
+	if err == nil {
+		t.Errorf("Write should return the redis error")
+	}
+}
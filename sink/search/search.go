@@ -0,0 +1,343 @@
+package search
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/httpserver"
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	_ "github.com/blevesearch/bleve/analysis/analyzer/simple"
+	_ "github.com/blevesearch/bleve/analysis/lang/cjk"
+	_ "github.com/blevesearch/bleve/analysis/lang/en"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/sirupsen/logrus"
+)
+
+// Search 为每张表维护一个本地 Bleve 索引，文档 ID 为主键，字段名可在规则中通过 SearchFieldMappings 转换，
+// 创建索引时按规则的 SearchFieldTypes/SearchAnalyzers/SearchStoreColumns 生成 mapping，之后修改规则需删除索引目录重建。
+// 需开启 HTTP 服务，GET {Path} 列出索引，GET {Path}/{schema.table}?q=&from=&size= 按 query string 语法搜索
+type Search struct {
+	Enabled bool   `env:""`
+	Dir     string `env:""`
+	// 需要建立索引的表，格式为 schema.table，支持 * 通配，为空时全部建立
+	Tables []string `env:""`
+	Path   string   `env:""`
+	// 单次搜索返回的最大条数
+	MaxSize int `env:""`
+
+	mu      sync.RWMutex
+	indices map[string]bleve.Index
+}
+
+type SearchResponse struct {
+	Total uint64
+	Hits  []Hit
+}
+
+type Hit struct {
+	ID     string
+	Score  float64
+	Fields map[string]interface{}
+}
+
+func (s *Search) SetDefaults() {
+	if s.Dir == "" {
+		s.Dir = "data/index"
+	}
+	if s.Path == "" {
+		s.Path = "/search"
+	}
+	if s.MaxSize == 0 {
+		s.MaxSize = 100
+	}
+}
+
+func (s *Search) Init() error {
+	if !s.Enabled || s.indices != nil {
+		return nil
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		logrus.Errorf("search mkdir %s err:%s", s.Dir, err.Error())
+		return err
+	}
+	s.indices = make(map[string]bleve.Index)
+	path := strings.TrimSuffix(s.Path, "/")
+	httpserver.HandleFunc(path, s.list)
+	httpserver.HandleFunc(path+"/", s.search)
+	return nil
+}
+
+func (s *Search) String() string { return "search" }
+
+func (s *Search) IsEnabled() bool {
+	return s != nil && s.Enabled
+}
+
+// Write 每个索引一个 batch，按主键覆盖写入，重复写入结果不变
+func (s *Search) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	tables := make([]string, 0)
+	batches := make(map[string]*bleve.Batch)
+	for _, req := range requests {
+		table := req.Schema + "." + req.Name
+		if !sink.MatchTables(s.Tables, req.Schema, req.Name) {
+			continue
+		}
+		id := sink.PrimaryKey(req)
+		if id == "" {
+			logrus.Warnf("search skip %s without primary key", table)
+			continue
+		}
+		batch, ok := batches[table]
+		if !ok {
+			index, err := s.index(table, func() *mapping.IndexMappingImpl {
+				return indexMapping(rules.Find(req.Schema, req.Name), req)
+			})
+			if err != nil {
+				return err
+			}
+			batch = index.NewBatch()
+			batches[table] = batch
+			tables = append(tables, table)
+		}
+
+		switch req.Action {
+		case canal.DeleteAction:
+			batch.Delete(id)
+			continue
+		case canal.UpdateAction:
+			// 主键变化时删除旧文档
+			if before := sink.PrimaryKey(sink.Before(req)); before != "" && before != id {
+				batch.Delete(before)
+			}
+		}
+		if err := batch.Index(id, document(rules.Find(req.Schema, req.Name), req)); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range tables {
+		index, _ := s.index(table, nil)
+		if err := index.Batch(batches[table]); err != nil {
+			logrus.Errorf("search index %s err:%s", table, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// index 打开表对应的索引，不存在且 create 不为 nil 时使用其返回的 mapping 创建
+func (s *Search) index(table string, create func() *mapping.IndexMappingImpl) (bleve.Index, error) {
+	s.mu.RLock()
+	index, ok := s.indices[table]
+	s.mu.RUnlock()
+	if ok {
+		return index, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.indices == nil {
+		return nil, errors.New("search is closed")
+	}
+	if index, ok := s.indices[table]; ok {
+		return index, nil
+	}
+	path := filepath.Join(s.Dir, table)
+	index, err := bleve.Open(path)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		if create == nil {
+			return nil, err
+		}
+		index, err = bleve.New(path, create())
+		if err == nil {
+			logrus.Infof("search create index %s", table)
+		}
+	}
+	if err != nil {
+		logrus.Errorf("search open index %s err:%s", table, err.Error())
+		return nil, err
+	}
+	s.indices[table] = index
+	return index, nil
+}
+
+// indexMapping 为当前的字段生成显式 mapping，之后新增的字段仍按动态 mapping 写入
+func indexMapping(r *rule.Rule, req *models.RowRequest) *mapping.IndexMappingImpl {
+	im := bleve.NewIndexMapping()
+	if r != nil && len(r.SearchStoreColumns) > 0 {
+		im.StoreDynamic = false
+	}
+	dm := bleve.NewDocumentMapping()
+	for _, column := range req.Columns {
+		field := r.SearchField(column.Name)
+		if field == "-" {
+			continue
+		}
+		var fm *mapping.FieldMapping
+		switch fieldType(r, column) {
+		case "":
+			continue
+		case "numeric":
+			fm = bleve.NewNumericFieldMapping()
+		case "datetime":
+			fm = bleve.NewDateTimeFieldMapping()
+		case "boolean":
+			fm = bleve.NewBooleanFieldMapping()
+		case "keyword":
+			fm = bleve.NewTextFieldMapping()
+			fm.Analyzer = keyword.Name
+		default:
+			fm = bleve.NewTextFieldMapping()
+			fm.Analyzer = r.SearchAnalyzer(column.Name)
+		}
+		fm.Store = r.SearchStore(column.Name)
+		dm.AddFieldMappingsAt(field, fm)
+	}
+	im.DefaultMapping = dm
+	return im
+}
+
+// fieldType 未配置时数值为 numeric、时间为 datetime，其他为 text，二进制与空间字段返回空字符串
+func fieldType(r *rule.Rule, column models.Column) string {
+	switch column.Type {
+	case encoder.ColumnTypeBinary, encoder.ColumnTypeGeometry:
+		return ""
+	}
+	if t := r.SearchFieldType(column.Name); t != "" {
+		return t
+	}
+	switch column.Type {
+	case encoder.ColumnTypeNumber, encoder.ColumnTypeFloat, encoder.ColumnTypeDecimal:
+		return "numeric"
+	case encoder.ColumnTypeDatetime, encoder.ColumnTypeTimestamp:
+		return "datetime"
+	}
+	return "text"
+}
+
+// document 按字段类型转换取值，无法转换时写入字符串
+func document(r *rule.Rule, req *models.RowRequest) map[string]interface{} {
+	row := req.Row()
+	doc := make(map[string]interface{}, len(req.Columns))
+	for i := range req.Columns {
+		column := req.Columns[i]
+		field := r.SearchField(column.Name)
+		if field == "-" || i >= len(row) || row[i] == nil {
+			continue
+		}
+		switch fieldType(r, column) {
+		case "":
+			continue
+		case "numeric":
+			if f, err := strconv.ParseFloat(sink.StringValue(row[i]), 64); err == nil {
+				doc[field] = f
+				continue
+			}
+		case "datetime":
+			doc[field] = sink.SQLValue(column, row[i])
+			continue
+		case "boolean":
+			if b, err := strconv.ParseBool(sink.StringValue(row[i])); err == nil {
+				doc[field] = b
+				continue
+			}
+		}
+		doc[field] = sink.StringValue(row[i])
+	}
+	return doc
+}
+
+func (s *Search) list(w http.ResponseWriter, r *http.Request) {
+	entries, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	tables := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			tables = append(tables, entry.Name())
+		}
+	}
+	sort.Strings(tables)
+	writeJSON(w, http.StatusOK, tables)
+}
+
+func (s *Search) search(w http.ResponseWriter, r *http.Request) {
+	table := strings.Trim(strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(s.Path, "/")), "/")
+	if table == "" || strings.ContainsAny(table, `/\`) || strings.HasPrefix(table, ".") {
+		writeError(w, http.StatusBadRequest, "invalid table")
+		return
+	}
+	index, err := s.index(table, nil)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		writeError(w, http.StatusNotFound, "index not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	q := query.Get("q")
+	size, _ := strconv.Atoi(query.Get("size"))
+	if size <= 0 || size > s.MaxSize {
+		size = s.MaxSize
+	}
+	from, _ := strconv.Atoi(query.Get("from"))
+	var req *bleve.SearchRequest
+	if q == "" {
+		req = bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), size, from, false)
+	} else {
+		req = bleve.NewSearchRequestOptions(bleve.NewQueryStringQuery(q), size, from, false)
+	}
+	req.Fields = []string{"*"}
+	result, err := index.Search(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resp := SearchResponse{Total: result.Total, Hits: make([]Hit, 0, len(result.Hits))}
+	for _, hit := range result.Hits {
+		resp.Hits = append(resp.Hits, Hit{ID: hit.ID, Score: hit.Score, Fields: hit.Fields})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Warnf("search write response err:%s", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func (s *Search) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for table, index := range s.indices {
+		if err := index.Close(); err != nil {
+			logrus.Errorf("search close index %s err:%s", table, err.Error())
+		}
+	}
+	s.indices = nil
+}
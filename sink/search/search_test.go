@@ -0,0 +1,118 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+func newTestSearch(t *testing.T) *Search {
+	s := &Search{Enabled: true, Dir: t.TempDir()}
+	s.SetDefaults()
+	s.indices = make(map[string]bleve.Index)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newArticleRequest(id int64, code, title string, price string) *models.RowRequest {
+	return &models.RowRequest{
+		Schema: "blog",
+		Name:   "article",
+		Action: "insert",
+		Columns: []models.Column{
+			{Name: "id", Type: encoder.ColumnTypeNumber},
+			{Name: "code", Type: encoder.ColumnTypeString},
+			{Name: "title", Type: encoder.ColumnTypeString},
+			{Name: "price", Type: encoder.ColumnTypeDecimal},
+		},
+		PrimaryKeys: []string{"id"},
+		NewRows:     []interface{}{id, code, title, price},
+	}
+}
+
+func TestIndexMapping(t *testing.T) {
+	rules := rule.Rules{{
+		Schema:             "blog",
+		Table:              "article",
+		SearchFieldTypes:   []string{"code:keyword"},
+		SearchAnalyzers:    []string{"title:en"},
+		SearchStoreColumns: []string{"id", "title"},
+	}}
+	s := newTestSearch(t)
+	err := s.Write(rules, []*models.RowRequest{
+		newArticleRequest(1, "AB-12", "She runs fast", "9.5"),
+		newArticleRequest(2, "CD-34", "Slow walking", "20"),
+	})
+	if err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	index, err := s.index("blog.article", nil)
+	if err != nil {
+		t.Fatalf("index err:%s", err.Error())
+	}
+
+	term := bleve.NewTermQuery("AB-12")
+	term.SetField("code")
+	match := bleve.NewMatchQuery("running")
+	match.SetField("title")
+	min := 10.0
+	price := bleve.NewNumericRangeQuery(&min, nil)
+	price.SetField("price")
+	tests := []struct {
+		name  string
+		query query.Query
+		want  string
+	}{
+		{name: "keyword", query: term, want: "1"},
+		{name: "analyzer", query: match, want: "1"},
+		{name: "numeric", query: price, want: "2"},
+	}
+	for _, tt := range tests {
+		req := bleve.NewSearchRequest(tt.query)
+		req.Fields = []string{"*"}
+		result, err := index.Search(req)
+		if err != nil {
+			t.Fatalf("%s search err:%s", tt.name, err.Error())
+		}
+		if len(result.Hits) != 1 || result.Hits[0].ID != tt.want {
+			t.Errorf("%s hits = %v, want [%s]", tt.name, result.Hits, tt.want)
+			continue
+		}
+		fields := result.Hits[0].Fields
+		if _, ok := fields["title"]; !ok {
+			t.Errorf("%s stored fields = %v, want title", tt.name, fields)
+		}
+		if _, ok := fields["code"]; ok {
+			t.Errorf("%s stored fields = %v, want code not stored", tt.name, fields)
+		}
+	}
+}
+
+func TestIndexMappingAnalyzer(t *testing.T) {
+	rules := rule.Rules{{Schema: "blog", Table: "article", SearchAnalyzers: []string{"title:unknown"}}}
+	s := newTestSearch(t)
+	if err := s.Write(rules, []*models.RowRequest{newArticleRequest(1, "AB-12", "title", "1")}); err == nil {
+		t.Errorf("Write with unknown analyzer, want error")
+	}
+
+	im := indexMapping(nil, newArticleRequest(1, "AB-12", "title", "1"))
+	want := map[string]string{"id": "number", "code": "text", "title": "text", "price": "number"}
+	for field, typ := range want {
+		if fm := fieldMapping(im, field); fm == nil || fm.Type != typ || !fm.Store {
+			t.Errorf("field %s mapping = %+v, want stored %s", field, fm, typ)
+		}
+	}
+}
+
+func fieldMapping(im *mapping.IndexMappingImpl, field string) *mapping.FieldMapping {
+	dm, ok := im.DefaultMapping.Properties[field]
+	if !ok || len(dm.Fields) == 0 {
+		return nil
+	}
+	return dm.Fields[0]
+}
@@ -0,0 +1,219 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/siddontang/go-mysql/canal"
+)
+
+// Sink 消费 RowRequest 队列的下游，Write 返回 nil 后队列中的记录才会被删除
+type Sink interface {
+	String() string
+	IsEnabled() bool
+	Write(rules rule.Rules, requests []*models.RowRequest) error
+	Close()
+}
+
+// PermanentError 重试也无法成功的错误，如下游返回 4xx，consumer 不再重试整批，
+// 逐条写入后将失败的记录转入死信队列
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+func IsPermanent(err error) bool {
+	_, ok := err.(*PermanentError)
+	return ok
+}
+
+// ChangedColumnsSink 按事件输出、可以只写入 update 变化字段的 sink。
+// 其他 sink 按整行建表、重建文档或渲染模板，规则中的 OnlyChangedColumns 对其不生效
+type ChangedColumnsSink interface {
+	ChangedColumns(r *rule.Rule) bool
+}
+
+// OnlyChangedColumns 规则开启 OnlyChangedColumns 且 sink 支持时，update 只保留变化的字段及主键，返回裁剪后的副本
+func OnlyChangedColumns(s Sink, rules rule.Rules, requests []*models.RowRequest) []*models.RowRequest {
+	changed, ok := s.(ChangedColumnsSink)
+	if !ok {
+		return requests
+	}
+	var result []*models.RowRequest
+	for i, req := range requests {
+		r := rules.Find(req.Schema, req.Name)
+		if req.Action != canal.UpdateAction || r == nil || !r.OnlyChangedColumns || !changed.ChangedColumns(r) {
+			if result != nil {
+				result = append(result, req)
+			}
+			continue
+		}
+		if result == nil {
+			result = make([]*models.RowRequest, i, len(requests))
+			copy(result, requests[:i])
+		}
+		result = append(result, onlyChangedColumns(req))
+	}
+	if result == nil {
+		return requests
+	}
+	return result
+}
+
+func onlyChangedColumns(req *models.RowRequest) *models.RowRequest {
+	trimmed := *req
+	trimmed.Columns = make([]models.Column, 0, len(req.ChangedColumns)+len(req.PrimaryKeys))
+	trimmed.OldRows = make([]interface{}, 0, cap(trimmed.Columns))
+	trimmed.NewRows = make([]interface{}, 0, cap(trimmed.Columns))
+	for i := range req.Columns {
+		name := req.Columns[i].Name
+		if !containsString(req.ChangedColumns, name) && !containsString(req.PrimaryKeys, name) {
+			continue
+		}
+		trimmed.Columns = append(trimmed.Columns, req.Columns[i])
+		if i < len(req.OldRows) {
+			trimmed.OldRows = append(trimmed.OldRows, req.OldRows[i])
+		}
+		if i < len(req.NewRows) {
+			trimmed.NewRows = append(trimmed.NewRows, req.NewRows[i])
+		}
+	}
+	return &trimmed
+}
+
+func containsString(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
+
+// PrimaryKey 主键值以 : 拼接，没有主键时返回空字符串
+func PrimaryKey(req *models.RowRequest) string {
+	values := req.PrimaryKeyValues()
+	keys := make([]string, len(values))
+	for i := range values {
+		keys[i] = StringValue(values[i])
+	}
+	return strings.Join(keys, ":")
+}
+
+// MatchTables tables 格式为 schema.table，支持 * 通配，为空时全部匹配
+func MatchTables(tables []string, schema, table string) bool {
+	if len(tables) == 0 {
+		return true
+	}
+	for _, item := range tables {
+		kv := strings.SplitN(item, ".", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		r := rule.Rule{Schema: kv[0], Table: kv[1]}
+		if r.Match(schema, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// Render 渲染 {{schema}}、{{table}}、{{action}}、{{column}}、{{before.column}}、{{after.column}} 模板
+func Render(template string, req *models.RowRequest) string {
+	s, _ := render(template, req)
+	return s
+}
+
+// RenderKey 同 Render，模板中存在取值为空的变量时返回 false
+func RenderKey(template string, req *models.RowRequest) (string, bool) {
+	return render(template, req)
+}
+
+func render(template string, req *models.RowRequest) (string, bool) {
+	complete := true
+	var b strings.Builder
+	rest := template
+	for {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			b.WriteString(rest)
+			break
+		}
+		end := strings.Index(rest[start:], "}}")
+		if end < 0 {
+			b.WriteString(rest)
+			break
+		}
+		b.WriteString(rest[:start])
+		value := resolve(strings.TrimSpace(rest[start+2:start+end]), req)
+		if value == "" {
+			complete = false
+		}
+		b.WriteString(value)
+		rest = rest[start+end+2:]
+	}
+	return b.String(), complete
+}
+
+func resolve(name string, req *models.RowRequest) string {
+	switch name {
+	case "schema":
+		return req.Schema
+	case "table":
+		return req.Name
+	case "action":
+		return req.Action
+	case "pk":
+		return PrimaryKey(req)
+	}
+	if strings.HasPrefix(name, "before.") {
+		return StringValue(req.OldValue(strings.TrimPrefix(name, "before.")))
+	}
+	if strings.HasPrefix(name, "after.") {
+		return StringValue(req.NewValue(strings.TrimPrefix(name, "after.")))
+	}
+	return StringValue(req.Value(name))
+}
+
+// StringValue 对象、数组等复杂类型以 JSON 输出
+func StringValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case json.Number:
+		return v.String()
+	case map[string]interface{}, []interface{}, json.RawMessage:
+		data, err := json.Marshal(v)
+		if err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// RenderBefore 使用修改前的行渲染模板，用于清理 update 前的旧 key
+func RenderBefore(template string, req *models.RowRequest) string {
+	return Render(template, Before(req))
+}
+
+// Before 返回只包含修改前数据的副本
+func Before(req *models.RowRequest) *models.RowRequest {
+	before := *req
+	before.NewRows = nil
+	return &before
+}
@@ -0,0 +1,92 @@
+package sink
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/siddontang/go-mysql/canal"
+)
+
+type testSink struct{}
+
+func (s *testSink) String() string  { return "test" }
+func (s *testSink) IsEnabled() bool { return true }
+func (s *testSink) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	return nil
+}
+func (s *testSink) Close() {}
+
+type testChangedSink struct {
+	testSink
+	changed bool
+}
+
+func (s *testChangedSink) ChangedColumns(r *rule.Rule) bool { return s.changed }
+
+func TestOnlyChangedColumns(t *testing.T) {
+	rules := rule.Rules{{Schema: "db", Table: "user", OnlyChangedColumns: true}}
+	newRequest := func(table, action string) *models.RowRequest {
+		return &models.RowRequest{
+			Schema:         "db",
+			Name:           table,
+			Action:         action,
+			Columns:        []models.Column{{Name: "id"}, {Name: "name"}, {Name: "age"}},
+			PrimaryKeys:    []string{"id"},
+			OldRows:        []interface{}{1, "a", 10},
+			NewRows:        []interface{}{1, "b", 10},
+			ChangedColumns: []string{"name"},
+		}
+	}
+	requests := []*models.RowRequest{
+		newRequest("user", canal.InsertAction),
+		newRequest("user", canal.UpdateAction),
+		newRequest("order", canal.UpdateAction),
+	}
+
+	result := OnlyChangedColumns(&testChangedSink{changed: true}, rules, requests)
+	if len(result) != 3 || result[0] != requests[0] || result[2] != requests[2] {
+		t.Fatalf("OnlyChangedColumns should keep requests without the rule")
+	}
+	trimmed := result[1]
+	if want := []models.Column{{Name: "id"}, {Name: "name"}}; !reflect.DeepEqual(trimmed.Columns, want) {
+		t.Errorf("Columns = %+v, want %+v", trimmed.Columns, want)
+	}
+	if want := []interface{}{1, "b"}; !reflect.DeepEqual(trimmed.NewRows, want) {
+		t.Errorf("NewRows = %+v, want %+v", trimmed.NewRows, want)
+	}
+	// 其他 sink 仍需使用完整字段，不能修改原请求
+	if len(requests[1].Columns) != 3 || len(requests[1].NewRows) != 3 {
+		t.Errorf("OnlyChangedColumns modified the original request")
+	}
+
+	// 未声明支持的 sink 默认使用完整字段
+	result = OnlyChangedColumns(&testSink{}, rules, requests)
+	if !reflect.DeepEqual(result, requests) || result[1] != requests[1] {
+		t.Errorf("OnlyChangedColumns should not trim for sinks without ChangedColumns")
+	}
+	result = OnlyChangedColumns(&testChangedSink{changed: false}, rules, requests)
+	if result[1] != requests[1] {
+		t.Errorf("OnlyChangedColumns should not trim when ChangedColumns returns false")
+	}
+}
+
+func TestMatchTables(t *testing.T) {
+	tests := []struct {
+		tables []string
+		table  string
+		want   bool
+	}{
+		{tables: nil, table: "user", want: true},
+		{tables: []string{"db.user"}, table: "user", want: true},
+		{tables: []string{"db.user"}, table: "order", want: false},
+		{tables: []string{"db.*"}, table: "order", want: true},
+		{tables: []string{"other.*", "user"}, table: "user", want: false},
+	}
+	for _, tt := range tests {
+		if got := MatchTables(tt.tables, "db", tt.table); got != tt.want {
+			t.Errorf("MatchTables(%v, db, %s) = %v, want %v", tt.tables, tt.table, got, tt.want)
+		}
+	}
+}
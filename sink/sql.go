@@ -0,0 +1,55 @@
+package sink
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/models"
+)
+
+// SQLValue 将队列中编码后的字段值还原为可绑定到 SQL 参数的值
+// binary -> []byte，bit -> uint64，json/geojson -> JSON 字符串，datetime/timestamp -> time.Time。
+// JSON 字段中的字符串、布尔值等标量同样序列化为 JSON 文本，不能按 SQL 字符串或整数绑定
+func SQLValue(column models.Column, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch column.Type {
+	case encoder.ColumnTypeJSON:
+		if data, err := json.Marshal(value); err == nil {
+			return string(data)
+		}
+	case encoder.ColumnTypeBinary:
+		if s, ok := value.(string); ok {
+			if data, err := base64.StdEncoding.DecodeString(s); err == nil {
+				return data
+			}
+		}
+	case encoder.ColumnTypeBit:
+		if s, ok := value.(string); ok {
+			if bits, err := strconv.ParseUint(s, 2, 64); err == nil {
+				return bits
+			}
+		}
+	case encoder.ColumnTypeDatetime, encoder.ColumnTypeTimestamp:
+		if s, ok := value.(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return t
+			}
+		}
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		return v.String()
+	case map[string]interface{}, []interface{}, json.RawMessage:
+		data, err := json.Marshal(v)
+		if err == nil {
+			return string(data)
+		}
+	}
+	return value
+}
@@ -0,0 +1,7 @@
+//go:build !cgo
+// +build !cgo
+
+package sqlite
+
+// go-sqlite3 需要 cgo，CGO_ENABLED=0 编译时只能在运行时报错
+const cgoEnabled = false
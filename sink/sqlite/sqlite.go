@@ -0,0 +1,520 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/siddontang/go-mysql/canal"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	checkpointTable = "_transfer_checkpoint"
+	timeFormat      = "2006-01-02 15:04:05.999999"
+)
+
+// SQLite 将选定的表镜像到本地 SQLite 文件，表结构按 MySQL 字段类型自动创建，并跟随字段的增删、改名及类型变化。
+// 每个源事务对应一个 SQLite 事务，并在同一事务中更新文件内的 checkpoint 表，重放时跳过已应用的行
+type SQLite struct {
+	Enabled bool   `env:""`
+	Path    string `env:""`
+	// 需要镜像的表，格式为 schema.table，支持 * 通配，为空时全部镜像
+	Tables []string `env:""`
+	// 目标表名模板，SQLite 没有 schema，默认以 _ 连接
+	Table         string `env:""`
+	BusyTimeoutMs int    `env:""`
+
+	mu         sync.Mutex
+	db         *sql.DB
+	checkpoint *position
+	// 已知的目标表字段，事务回滚后清空
+	columns map[string][]tableColumn
+}
+
+type position struct {
+	logName  string
+	logPos   uint32
+	rowIndex int
+}
+
+func (s *SQLite) SetDefaults() {
+	if s.Path == "" {
+		s.Path = "data/mirror.db"
+	}
+	if s.Table == "" {
+		s.Table = "{{schema}}_{{table}}"
+	}
+	if s.BusyTimeoutMs == 0 {
+		s.BusyTimeoutMs = 5000
+	}
+}
+
+func (s *SQLite) Init() error {
+	if !s.Enabled || s.db != nil {
+		return nil
+	}
+	if !cgoEnabled {
+		return errors.New("sqlite sink requires cgo, build with CGO_ENABLED=1 and a C compiler")
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", s.Path)
+	if err != nil {
+		logrus.Errorf("sqlite open %s err:%s", s.Path, err.Error())
+		return err
+	}
+	// 单连接，PRAGMA 对后续所有语句生效
+	db.SetMaxOpenConns(1)
+	pragmas := []string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = FULL",
+		fmt.Sprintf("PRAGMA busy_timeout = %d", s.BusyTimeoutMs),
+		`CREATE TABLE IF NOT EXISTS ` + quoteName(checkpointTable) + ` (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			log_name TEXT NOT NULL,
+			log_pos INTEGER NOT NULL,
+			row_index INTEGER NOT NULL,
+			updated_at TEXT NOT NULL
+		)`,
+	}
+	for _, pragma := range pragmas {
+		if _, err := db.Exec(pragma); err != nil {
+			logrus.Errorf("sqlite init err:%s", err.Error())
+			db.Close()
+			return err
+		}
+	}
+
+	var c position
+	err = db.QueryRow("SELECT log_name, log_pos, row_index FROM "+quoteName(checkpointTable)+" WHERE id = 1").
+		Scan(&c.logName, &c.logPos, &c.rowIndex)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		logrus.Errorf("sqlite load checkpoint err:%s", err.Error())
+		db.Close()
+		return err
+	default:
+		s.checkpoint = &c
+		logrus.Infof("sqlite checkpoint %s:%d:%d", c.logName, c.logPos, c.rowIndex)
+	}
+	s.db = db
+	s.columns = make(map[string][]tableColumn)
+	return nil
+}
+
+func (s *SQLite) String() string { return "sqlite" }
+
+func (s *SQLite) IsEnabled() bool {
+	return s != nil && s.Enabled
+}
+
+func (s *SQLite) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return errors.New("sqlite is closed")
+	}
+
+	for start := 0; start < len(requests); {
+		end := start + 1
+		for end < len(requests) && requests[end].Transaction() == requests[start].Transaction() {
+			end++
+		}
+		if err := s.apply(requests[start:end]); err != nil {
+			// ALTER TABLE 随事务回滚，重新读取表结构
+			s.columns = make(map[string][]tableColumn)
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+// apply 在一个 SQLite 事务中写入同一源事务的行，并更新 checkpoint
+func (s *SQLite) apply(requests []*models.RowRequest) error {
+	pending := make([]*models.RowRequest, 0, len(requests))
+	for _, req := range requests {
+		if sink.MatchTables(s.Tables, req.Schema, req.Name) && !s.applied(req) {
+			pending = append(pending, req)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, req := range pending {
+		if err := s.execute(tx, req); err != nil {
+			logrus.Errorf("sqlite %s.%s %s err:%s", req.Schema, req.Name, req.Action, err.Error())
+			tx.Rollback()
+			return err
+		}
+	}
+	last := pending[len(pending)-1]
+	_, err = tx.Exec("INSERT OR REPLACE INTO "+quoteName(checkpointTable)+
+		" (id, log_name, log_pos, row_index, updated_at) VALUES (1, ?, ?, ?, ?)",
+		last.LogName, last.LogPos, last.RowIndex, time.Now().UTC().Format(timeFormat))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.checkpoint = &position{logName: last.LogName, logPos: last.LogPos, rowIndex: last.RowIndex}
+	return nil
+}
+
+// applied 行位置不晚于 checkpoint 时已写入过
+func (s *SQLite) applied(req *models.RowRequest) bool {
+	c := s.checkpoint
+	if c == nil || req.LogName == "" {
+		return false
+	}
+	if req.LogName != c.logName {
+		return req.LogName < c.logName
+	}
+	if req.LogPos != c.logPos {
+		return req.LogPos < c.logPos
+	}
+	return req.RowIndex <= c.rowIndex
+}
+
+func (s *SQLite) execute(tx *sql.Tx, req *models.RowRequest) error {
+	table := sink.Render(s.Table, req)
+	if err := s.ensureTable(tx, table, req); err != nil {
+		return err
+	}
+	switch req.Action {
+	case canal.DeleteAction:
+		return s.delete(tx, table, req, req.OldRows)
+	case canal.UpdateAction:
+		// 主键变化时先删除旧行
+		if primaryKeyChanged(req) {
+			if err := s.delete(tx, table, req, req.OldRows); err != nil {
+				return err
+			}
+		}
+	}
+	return s.upsert(tx, table, req)
+}
+
+// ensureTable 表不存在时创建，只新增字段时 ADD COLUMN，字段删除、改名、类型或主键变化时重建表。
+// binlog 中没有 DDL 信息，字段数不变且同一位置新旧字段名都只出现一次时视为改名并保留数据
+func (s *SQLite) ensureTable(tx *sql.Tx, table string, req *models.RowRequest) error {
+	known, ok := s.columns[table]
+	if !ok {
+		var err error
+		if known, err = tableColumns(tx, table); err != nil {
+			return err
+		}
+		s.columns[table] = known
+	}
+
+	if len(known) == 0 {
+		if err := createTable(tx, table, req); err != nil {
+			return err
+		}
+		s.columns[table] = definedColumns(req)
+		logrus.Infof("sqlite create table %s", table)
+		return nil
+	}
+
+	existing := make(map[string]tableColumn, len(known))
+	for _, column := range known {
+		existing[column.name] = column
+	}
+	added := make([]models.Column, 0)
+	rebuild := !equalNames(primaryKeys(known), req.PrimaryKeys)
+	for _, column := range req.Columns {
+		c, ok := existing[column.Name]
+		switch {
+		case !ok:
+			added = append(added, column)
+		case !strings.EqualFold(c.typ, columnType(column)):
+			rebuild = true
+		}
+	}
+	if rebuild || len(req.Columns)-len(added) < len(known) {
+		return s.rebuildTable(tx, table, known, req)
+	}
+
+	for _, column := range added {
+		if _, err := tx.Exec("ALTER TABLE " + quoteName(table) + " ADD COLUMN " + quoteName(column.Name) + " " + columnType(column)); err != nil {
+			return err
+		}
+		known = append(known, tableColumn{name: column.Name, typ: columnType(column)})
+		logrus.Infof("sqlite add column %s.%s", table, column.Name)
+	}
+	s.columns[table] = known
+	return nil
+}
+
+// rebuildTable 按新结构创建临时表并复制数据，类型变化时由 SQLite 按类型亲和性转换
+func (s *SQLite) rebuildTable(tx *sql.Tx, table string, known []tableColumn, req *models.RowRequest) error {
+	tmp := table + "_rebuild"
+	if _, err := tx.Exec("DROP TABLE IF EXISTS " + quoteName(tmp)); err != nil {
+		return err
+	}
+	if err := createTable(tx, tmp, req); err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool, len(known))
+	for _, column := range known {
+		existing[column.name] = true
+	}
+	current := make(map[string]bool, len(req.Columns))
+	for _, column := range req.Columns {
+		current[column.Name] = true
+	}
+	targets := make([]string, 0, len(req.Columns))
+	sources := make([]string, 0, len(req.Columns))
+	for i, column := range req.Columns {
+		source := column.Name
+		if !existing[source] {
+			if len(known) != len(req.Columns) || current[known[i].name] {
+				continue
+			}
+			source = known[i].name
+			logrus.Infof("sqlite rename column %s.%s to %s", table, source, column.Name)
+		}
+		targets = append(targets, quoteName(column.Name))
+		sources = append(sources, quoteName(source))
+	}
+	statements := []string{
+		"DROP TABLE " + quoteName(table),
+		"ALTER TABLE " + quoteName(tmp) + " RENAME TO " + quoteName(table),
+	}
+	if len(targets) > 0 {
+		statements = append([]string{fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", quoteName(tmp),
+			strings.Join(targets, ", "), strings.Join(sources, ", "), quoteName(table))}, statements...)
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	s.columns[table] = definedColumns(req)
+	logrus.Infof("sqlite rebuild table %s", table)
+	return nil
+}
+
+func createTable(tx *sql.Tx, table string, req *models.RowRequest) error {
+	definitions := make([]string, 0, len(req.Columns)+1)
+	for _, column := range req.Columns {
+		definitions = append(definitions, quoteName(column.Name)+" "+columnType(column))
+	}
+	if len(req.PrimaryKeys) > 0 {
+		definitions = append(definitions, "PRIMARY KEY ("+joinNames(req.PrimaryKeys)+")")
+	}
+	_, err := tx.Exec("CREATE TABLE " + quoteName(table) + " (" + strings.Join(definitions, ", ") + ")")
+	return err
+}
+
+type tableColumn struct {
+	name string
+	typ  string
+	// 在主键中的位置，从 1 开始，不是主键时为 0
+	pk int
+}
+
+// definedColumns 返回 createTable 按请求创建的字段
+func definedColumns(req *models.RowRequest) []tableColumn {
+	columns := make([]tableColumn, len(req.Columns))
+	for i, column := range req.Columns {
+		columns[i] = tableColumn{name: column.Name, typ: columnType(column)}
+		for j, pk := range req.PrimaryKeys {
+			if pk == column.Name {
+				columns[i].pk = j + 1
+			}
+		}
+	}
+	return columns
+}
+
+func primaryKeys(columns []tableColumn) []string {
+	keys := make([]string, 0)
+	for pk := 1; ; pk++ {
+		found := false
+		for _, column := range columns {
+			if column.pk == pk {
+				keys = append(keys, column.name)
+				found = true
+			}
+		}
+		if !found {
+			return keys
+		}
+	}
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// tableColumns 按字段顺序返回，表不存在时为空
+func tableColumns(tx *sql.Tx, table string) ([]tableColumn, error) {
+	rows, err := tx.Query("PRAGMA table_info(" + quoteName(table) + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make([]tableColumn, 0)
+	for rows.Next() {
+		var (
+			c          tableColumn
+			cid        int
+			notNull    int
+			defaultVal interface{}
+		)
+		if err := rows.Scan(&cid, &c.name, &c.typ, &notNull, &defaultVal, &c.pk); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+// upsert 没有主键的表只能追加
+func (s *SQLite) upsert(tx *sql.Tx, table string, req *models.RowRequest) error {
+	columns := make([]string, 0, len(req.Columns))
+	placeholders := make([]string, 0, len(req.Columns))
+	updates := make([]string, 0, len(req.Columns))
+	args := make([]interface{}, 0, len(req.Columns))
+	for i, column := range req.Columns {
+		if i >= len(req.NewRows) {
+			continue
+		}
+		columns = append(columns, quoteName(column.Name))
+		placeholders = append(placeholders, "?")
+		updates = append(updates, quoteName(column.Name)+" = excluded."+quoteName(column.Name))
+		args = append(args, value(column, req.NewRows[i]))
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quoteName(table),
+		strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	if len(req.PrimaryKeys) > 0 {
+		query += " ON CONFLICT (" + joinNames(req.PrimaryKeys) + ") DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+	_, err := tx.Exec(query, args...)
+	return err
+}
+
+func (s *SQLite) delete(tx *sql.Tx, table string, req *models.RowRequest, row []interface{}) error {
+	if len(req.PrimaryKeys) == 0 {
+		logrus.Warnf("sqlite skip delete %s.%s without primary key", req.Schema, req.Name)
+		return nil
+	}
+	conditions := make([]string, 0, len(req.PrimaryKeys))
+	args := make([]interface{}, 0, len(req.PrimaryKeys))
+	for _, pk := range req.PrimaryKeys {
+		index := req.ColumnIndex(pk)
+		if index < 0 || index >= len(row) {
+			return fmt.Errorf("primary key %s of %s.%s not found", pk, req.Schema, req.Name)
+		}
+		conditions = append(conditions, quoteName(pk)+" = ?")
+		args = append(args, value(req.Columns[index], row[index]))
+	}
+	_, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", quoteName(table), strings.Join(conditions, " AND ")), args...)
+	return err
+}
+
+// columnType 按 SQLite 类型亲和性映射，超出 int64 的 bigint unsigned 与 decimal 使用 TEXT 保留精度
+func columnType(column models.Column) string {
+	rawType := strings.ToLower(column.RawType)
+	switch column.Type {
+	case encoder.ColumnTypeNumber:
+		if strings.HasPrefix(rawType, "bigint") && strings.Contains(rawType, "unsigned") {
+			return "TEXT"
+		}
+		return "INTEGER"
+	case encoder.ColumnTypeBit:
+		return "INTEGER"
+	case encoder.ColumnTypeFloat:
+		return "REAL"
+	case encoder.ColumnTypeBinary:
+		return "BLOB"
+	}
+	return "TEXT"
+}
+
+// value 时间按 MySQL 格式写入，TIMESTAMP 为 UTC
+func value(column models.Column, v interface{}) interface{} {
+	v = sink.SQLValue(column, v)
+	switch t := v.(type) {
+	case time.Time:
+		if column.Type == encoder.ColumnTypeTimestamp {
+			return t.UTC().Format(timeFormat)
+		}
+		return t.Format(timeFormat)
+	case bool:
+		if t {
+			return 1
+		}
+		return 0
+	case uint64:
+		if t > math.MaxInt64 {
+			return fmt.Sprint(t)
+		}
+		return int64(t)
+	}
+	return v
+}
+
+func primaryKeyChanged(req *models.RowRequest) bool {
+	for _, pk := range req.PrimaryKeys {
+		if sink.StringValue(req.OldValue(pk)) != sink.StringValue(req.NewValue(pk)) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinNames(names []string) string {
+	quoted := make([]string, len(names))
+	for i := range names {
+		quoted[i] = quoteName(names[i])
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func quoteName(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func (s *SQLite) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db != nil {
+		s.db.Close()
+		s.db = nil
+	}
+}
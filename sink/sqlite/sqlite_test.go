@@ -0,0 +1,209 @@
+//go:build cgo
+// +build cgo
+
+package sqlite
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/JieWaZi/transfer-mysql/encoder"
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/siddontang/go-mysql/canal"
+)
+
+func newTestSQLite(t *testing.T, path string) *SQLite {
+	s := &SQLite{Enabled: true, Path: path}
+	s.SetDefaults()
+	if err := s.Init(); err != nil {
+		t.Fatalf("Init err:%s", err.Error())
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newUserRequest(columns []models.Column, row ...interface{}) *models.RowRequest {
+	return &models.RowRequest{
+		Schema:      "db",
+		Name:        "user",
+		Action:      canal.InsertAction,
+		Columns:     columns,
+		PrimaryKeys: []string{"id"},
+		NewRows:     row,
+	}
+}
+
+func (s *SQLite) query(t *testing.T, query string) [][]interface{} {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		t.Fatalf("query %s err:%s", query, err.Error())
+	}
+	defer rows.Close()
+	names, _ := rows.Columns()
+	result := make([][]interface{}, 0)
+	for rows.Next() {
+		row := make([]interface{}, len(names))
+		dest := make([]interface{}, len(names))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			t.Fatalf("scan err:%s", err.Error())
+		}
+		for i := range row {
+			if b, ok := row[i].([]byte); ok {
+				row[i] = string(b)
+			}
+		}
+		result = append(result, row)
+	}
+	return result
+}
+
+func (s *SQLite) schema(t *testing.T, table string) []tableColumn {
+	tx, err := s.db.Begin()
+	if err != nil {
+		t.Fatalf("begin err:%s", err.Error())
+	}
+	defer tx.Rollback()
+	columns, err := tableColumns(tx, table)
+	if err != nil {
+		t.Fatalf("table info err:%s", err.Error())
+	}
+	return columns
+}
+
+func TestEnsureTable(t *testing.T) {
+	id := models.Column{Name: "id", Type: encoder.ColumnTypeNumber, RawType: "int"}
+	name := models.Column{Name: "name", Type: encoder.ColumnTypeString, RawType: "varchar(20)"}
+	nick := models.Column{Name: "nick", Type: encoder.ColumnTypeString, RawType: "varchar(20)"}
+	age := models.Column{Name: "age", Type: encoder.ColumnTypeNumber, RawType: "int"}
+	ageText := models.Column{Name: "age", Type: encoder.ColumnTypeString, RawType: "varchar(10)"}
+	tests := []struct {
+		name    string
+		columns []models.Column
+		row     []interface{}
+		schema  []tableColumn
+		rows    [][]interface{}
+	}{
+		{
+			name:    "create",
+			columns: []models.Column{id, name},
+			row:     []interface{}{int64(1), "a"},
+			schema:  []tableColumn{{"id", "INTEGER", 1}, {"name", "TEXT", 0}},
+			rows:    [][]interface{}{{int64(1), "a"}},
+		},
+		{
+			name:    "add column",
+			columns: []models.Column{id, name, age},
+			row:     []interface{}{int64(2), "b", int64(20)},
+			schema:  []tableColumn{{"id", "INTEGER", 1}, {"name", "TEXT", 0}, {"age", "INTEGER", 0}},
+			rows:    [][]interface{}{{int64(1), "a", nil}, {int64(2), "b", int64(20)}},
+		},
+		{
+			name:    "rename column",
+			columns: []models.Column{id, nick, age},
+			row:     []interface{}{int64(3), "c", int64(30)},
+			schema:  []tableColumn{{"id", "INTEGER", 1}, {"nick", "TEXT", 0}, {"age", "INTEGER", 0}},
+			rows:    [][]interface{}{{int64(1), "a", nil}, {int64(2), "b", int64(20)}, {int64(3), "c", int64(30)}},
+		},
+		{
+			name:    "change type",
+			columns: []models.Column{id, nick, ageText},
+			row:     []interface{}{int64(4), "d", "40+"},
+			schema:  []tableColumn{{"id", "INTEGER", 1}, {"nick", "TEXT", 0}, {"age", "TEXT", 0}},
+			rows: [][]interface{}{
+				{int64(1), "a", nil}, {int64(2), "b", "20"}, {int64(3), "c", "30"}, {int64(4), "d", "40+"},
+			},
+		},
+		{
+			name:    "drop column",
+			columns: []models.Column{id, ageText},
+			row:     []interface{}{int64(5), "50"},
+			schema:  []tableColumn{{"id", "INTEGER", 1}, {"age", "TEXT", 0}},
+			rows:    [][]interface{}{{int64(1), nil}, {int64(2), "20"}, {int64(3), "30"}, {int64(4), "40+"}, {int64(5), "50"}},
+		},
+	}
+
+	s := newTestSQLite(t, filepath.Join(t.TempDir(), "mirror.db"))
+	for _, tt := range tests {
+		if err := s.Write(nil, []*models.RowRequest{newUserRequest(tt.columns, tt.row...)}); err != nil {
+			t.Fatalf("%s Write err:%s", tt.name, err.Error())
+		}
+		if got := s.schema(t, "db_user"); !reflect.DeepEqual(got, tt.schema) {
+			t.Errorf("%s schema = %v, want %v", tt.name, got, tt.schema)
+		}
+		if got := s.query(t, `SELECT * FROM "db_user" ORDER BY id`); !reflect.DeepEqual(got, tt.rows) {
+			t.Errorf("%s rows = %v, want %v", tt.name, got, tt.rows)
+		}
+	}
+	// 缓存的表结构与文件一致
+	if !reflect.DeepEqual(s.columns["db_user"], s.schema(t, "db_user")) {
+		t.Errorf("cached columns = %v, want %v", s.columns["db_user"], s.schema(t, "db_user"))
+	}
+}
+
+// TestReplay 重启后重放已应用的事务时跳过 checkpoint 及之前的行
+func TestReplay(t *testing.T) {
+	columns := []models.Column{
+		{Name: "id", Type: encoder.ColumnTypeNumber, RawType: "int"},
+		{Name: "name", Type: encoder.ColumnTypeString, RawType: "varchar(20)"},
+	}
+	newRequest := func(pos uint32, rowIndex int, id int64, name string) *models.RowRequest {
+		req := newUserRequest(columns, id, name)
+		req.LogName = "mysql-bin.000002"
+		req.TxnPos = pos
+		req.LogPos = pos + 100
+		req.RowIndex = rowIndex
+		return req
+	}
+	path := filepath.Join(t.TempDir(), "mirror.db")
+	s := newTestSQLite(t, path)
+	err := s.Write(nil, []*models.RowRequest{newRequest(1000, 0, 1, "a"), newRequest(1000, 1, 2, "b")})
+	if err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	s.Close()
+
+	s = newTestSQLite(t, path)
+	if want := (&position{logName: "mysql-bin.000002", logPos: 1100, rowIndex: 1}); !reflect.DeepEqual(s.checkpoint, want) {
+		t.Fatalf("checkpoint = %+v, want %+v", s.checkpoint, want)
+	}
+	tests := []struct {
+		name    string
+		req     *models.RowRequest
+		applied bool
+	}{
+		{name: "earlier file", req: &models.RowRequest{LogName: "mysql-bin.000001", LogPos: 9000}, applied: true},
+		{name: "earlier position", req: &models.RowRequest{LogName: "mysql-bin.000002", LogPos: 900}, applied: true},
+		{name: "same row", req: &models.RowRequest{LogName: "mysql-bin.000002", LogPos: 1100, RowIndex: 1}, applied: true},
+		{name: "next row", req: &models.RowRequest{LogName: "mysql-bin.000002", LogPos: 1100, RowIndex: 2}},
+		{name: "later file", req: &models.RowRequest{LogName: "mysql-bin.000003", LogPos: 4}},
+		{name: "without position", req: &models.RowRequest{}},
+	}
+	for _, tt := range tests {
+		if got := s.applied(tt.req); got != tt.applied {
+			t.Errorf("%s applied = %v, want %v", tt.name, got, tt.applied)
+		}
+	}
+
+	// 重放的行不覆盖之后的修改，同一事务中未应用的行继续写入
+	if _, err := s.db.Exec(`UPDATE "db_user" SET name = 'changed' WHERE id = 1`); err != nil {
+		t.Fatalf("update err:%s", err.Error())
+	}
+	err = s.Write(nil, []*models.RowRequest{
+		newRequest(1000, 0, 1, "a"), newRequest(1000, 1, 2, "b"), newRequest(1000, 2, 3, "c"),
+	})
+	if err != nil {
+		t.Fatalf("replay Write err:%s", err.Error())
+	}
+	want := [][]interface{}{{int64(1), "changed"}, {int64(2), "b"}, {int64(3), "c"}}
+	if got := s.query(t, `SELECT * FROM "db_user" ORDER BY id`); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %v, want %v", got, want)
+	}
+	got := s.query(t, `SELECT log_name, log_pos, row_index FROM "_transfer_checkpoint"`)
+	if want := [][]interface{}{{"mysql-bin.000002", int64(1100), int64(2)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("checkpoint = %v, want %v", got, want)
+	}
+}
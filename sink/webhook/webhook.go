@@ -0,0 +1,243 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/rule"
+	"github.com/JieWaZi/transfer-mysql/sink"
+	"github.com/sirupsen/logrus"
+)
+
+const SignatureHeader = "X-Transfer-Signature"
+
+type Webhook struct {
+	Enabled bool `env:""`
+	// 默认回调地址，可在规则中通过 WebhookURLs 覆盖
+	URLs []string `env:""`
+	// 大于 1 时以 JSON 数组批量推送
+	BatchSize int `env:""`
+	// HMAC-SHA256 签名密钥，签名放在 X-Transfer-Signature 中
+	Secret string `env:""`
+	// 格式为 Key: Value
+	Headers []string `env:""`
+	// mTLS 证书
+	CertFile string `env:""`
+	KeyFile  string `env:""`
+	CAFile   string `env:""`
+
+	TimeoutMs int `env:""`
+	// 5xx、429 及网络错误的重试次数，0 表示不重试，小于 0 时使用默认值
+	MaxRetries     int `env:""`
+	RetryBackoffMs int `env:""`
+
+	client *http.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (w *Webhook) SetDefaults() {
+	if w.BatchSize == 0 {
+		w.BatchSize = 1
+	}
+	if w.TimeoutMs == 0 {
+		w.TimeoutMs = 10000
+	}
+	if w.MaxRetries < 0 {
+		w.MaxRetries = 3
+	}
+	if w.RetryBackoffMs == 0 {
+		w.RetryBackoffMs = 500
+	}
+}
+
+func (w *Webhook) Init() error {
+	if !w.Enabled || w.client != nil {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if w.CertFile != "" || w.CAFile != "" {
+		tlsConfig, err := w.tlsConfig()
+		if err != nil {
+			logrus.Errorf("webhook load tls config err:%s", err.Error())
+			return err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	w.client = &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(w.TimeoutMs) * time.Millisecond,
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	return nil
+}
+
+func (w *Webhook) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if w.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(w.CertFile, w.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if w.CAFile != "" {
+		ca, err := ioutil.ReadFile(w.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("invalid ca file " + w.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+func (w *Webhook) String() string { return "webhook" }
+
+func (w *Webhook) IsEnabled() bool {
+	return w != nil && w.Enabled
+}
+
+func (w *Webhook) ChangedColumns(r *rule.Rule) bool { return true }
+
+// Write 所有地址均返回 2xx 才算成功
+func (w *Webhook) Write(rules rule.Rules, requests []*models.RowRequest) error {
+	urls := make([]string, 0)
+	grouped := make(map[string][]*models.RowRequest)
+	for _, req := range requests {
+		targets := w.URLs
+		if r := rules.Find(req.Schema, req.Name); r != nil && len(r.WebhookURLs) > 0 {
+			targets = r.WebhookURLs
+		}
+		for _, url := range targets {
+			if _, ok := grouped[url]; !ok {
+				urls = append(urls, url)
+			}
+			grouped[url] = append(grouped[url], req)
+		}
+	}
+
+	for _, url := range urls {
+		list := grouped[url]
+		for start := 0; start < len(list); start += w.BatchSize {
+			end := start + w.BatchSize
+			if end > len(list) {
+				end = len(list)
+			}
+			var payload interface{} = list[start:end]
+			if w.BatchSize == 1 {
+				payload = list[start]
+			}
+			data, err := json.Marshal(payload)
+			if err != nil {
+				return err
+			}
+			if err := w.post(url, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// post 5xx、429 及网络错误按退避重试，其他 4xx 作为 PermanentError 返回，Close 后立即结束等待
+func (w *Webhook) post(url string, data []byte) error {
+	backoff := time.Duration(w.RetryBackoffMs) * time.Millisecond
+	var lastErr error
+	for attempt := 0; attempt <= w.MaxRetries; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-w.ctx.Done():
+				timer.Stop()
+				return w.ctx.Err()
+			}
+			backoff *= 2
+		}
+		retry, wait, err := w.send(url, data)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			return err
+		}
+		if wait > backoff {
+			backoff = wait
+		}
+		logrus.Warnf("webhook post %s attempt %d err:%s", url, attempt+1, err.Error())
+	}
+	return lastErr
+}
+
+func (w *Webhook) send(url string, data []byte) (bool, time.Duration, error) {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, header := range w.Headers {
+		kv := strings.SplitN(header, ":", 2)
+		if len(kv) == 2 {
+			req.Header.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		}
+	}
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.Secret, data))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 == 2 {
+		return false, 0, nil
+	}
+	err = fmt.Errorf("webhook %s status %d", url, resp.StatusCode)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode/100 == 5 {
+		var wait time.Duration
+		if seconds, e := strconv.Atoi(resp.Header.Get("Retry-After")); e == nil {
+			wait = time.Duration(seconds) * time.Second
+		}
+		return true, wait, err
+	}
+	if resp.StatusCode/100 == 4 {
+		return false, 0, sink.Permanent(err)
+	}
+	return false, 0, err
+}
+
+// Sign 返回 payload 的 HMAC-SHA256 十六进制签名
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *Webhook) Close() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+}
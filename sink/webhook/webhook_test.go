@@ -0,0 +1,92 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JieWaZi/transfer-mysql/models"
+	"github.com/JieWaZi/transfer-mysql/sink"
+)
+
+func newTestWebhook(t *testing.T, maxRetries int, statuses ...int) (*Webhook, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if r.Header.Get(SignatureHeader) == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		status := http.StatusOK
+		if int(n) <= len(statuses) {
+			status = statuses[n-1]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	w := &Webhook{Enabled: true, URLs: []string{server.URL}, Secret: "secret", MaxRetries: maxRetries, RetryBackoffMs: 10}
+	w.SetDefaults()
+	if err := w.Init(); err != nil {
+		t.Fatalf("init err:%s", err.Error())
+	}
+	t.Cleanup(w.Close)
+	return w, &calls
+}
+
+var requests = []*models.RowRequest{{Schema: "db", Name: "user", Action: "insert"}}
+
+func TestWriteRetry(t *testing.T) {
+	w, calls := newTestWebhook(t, -1, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	if w.MaxRetries != 3 {
+		t.Errorf("default MaxRetries = %d, want 3", w.MaxRetries)
+	}
+	if err := w.Write(nil, requests); err != nil {
+		t.Fatalf("Write err:%s", err.Error())
+	}
+	if *calls != 3 {
+		t.Errorf("calls = %d, want 3", *calls)
+	}
+}
+
+func TestWriteWithoutRetry(t *testing.T) {
+	w, calls := newTestWebhook(t, 0, http.StatusServiceUnavailable)
+	err := w.Write(nil, requests)
+	if err == nil || sink.IsPermanent(err) {
+		t.Errorf("Write err = %v, want temporary error", err)
+	}
+	if *calls != 1 {
+		t.Errorf("calls = %d, want 1", *calls)
+	}
+}
+
+func TestWritePermanentError(t *testing.T) {
+	w, calls := newTestWebhook(t, 3, http.StatusBadRequest)
+	err := w.Write(nil, requests)
+	if !sink.IsPermanent(err) {
+		t.Errorf("Write err = %v, want permanent error", err)
+	}
+	if *calls != 1 {
+		t.Errorf("calls = %d, want 1", *calls)
+	}
+}
+
+func TestCloseStopsRetry(t *testing.T) {
+	w, _ := newTestWebhook(t, 3, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	w.RetryBackoffMs = int(time.Hour / time.Millisecond)
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Write(nil, requests)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	w.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Write should fail after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Write did not return after Close")
+	}
+}
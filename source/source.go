@@ -0,0 +1,150 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/siddontang/go-mysql/client"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	RowImageFull    = "FULL"
+	RowImageMinimal = "MINIMAL"
+	RowImageNoBlob  = "NOBLOB"
+)
+
+// Source 回源查询使用的只读连接池
+type Source struct {
+	Enabled        bool   `env:""`
+	Host           string `env:""`
+	Port           int    `env:""`
+	User           string `env:""`
+	Password       string `env:""`
+	MaxConnections int    `env:""`
+	BatchSize      int    `env:""`
+	// 为空时从源库 binlog_row_image 读取
+	RowImage string `env:""`
+
+	idle  chan *client.Conn
+	slots chan struct{}
+}
+
+func (s *Source) SetDefaults() {
+	if s.Host == "" {
+		s.Host = "127.0.0.1"
+	}
+	if s.Port == 0 {
+		s.Port = 3306
+	}
+	if s.User == "" {
+		s.User = "root"
+	}
+	if s.Password == "" {
+		s.Password = "123456"
+	}
+	if s.MaxConnections == 0 {
+		s.MaxConnections = 4
+	}
+	if s.BatchSize == 0 {
+		s.BatchSize = 100
+	}
+}
+
+func (s *Source) Init() error {
+	if !s.Enabled || s.idle != nil {
+		return nil
+	}
+	if s.MaxConnections <= 0 || s.BatchSize <= 0 {
+		return errors.New("source MaxConnections and BatchSize must be greater than 0")
+	}
+	s.idle = make(chan *client.Conn, s.MaxConnections)
+	s.slots = make(chan struct{}, s.MaxConnections)
+
+	if s.RowImage == "" {
+		res, err := s.Execute("SELECT @@GLOBAL.binlog_row_image")
+		if err != nil {
+			logrus.Errorf("source get binlog_row_image err:%s", err.Error())
+			return err
+		}
+		rowImage, err := res.GetString(0, 0)
+		if err != nil {
+			return err
+		}
+		s.RowImage = rowImage
+	}
+	s.RowImage = strings.ToUpper(s.RowImage)
+	logrus.Infof("source binlog_row_image:%s", s.RowImage)
+	return nil
+}
+
+func (s *Source) IsEnabled() bool {
+	return s != nil && s.Enabled && s.idle != nil
+}
+
+func (s *Source) Execute(cmd string, args ...interface{}) (*mysql.Result, error) {
+	if s.idle == nil {
+		return nil, errors.New("source is not initialized")
+	}
+	conn, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	res, err := conn.Execute(cmd, args...)
+	// 出错的连接直接丢弃，下次重新建立
+	s.put(conn, err != nil)
+	return res, err
+}
+
+func (s *Source) Close() {
+	if s.idle == nil {
+		return
+	}
+	for {
+		select {
+		case conn := <-s.idle:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (s *Source) get() (*client.Conn, error) {
+	s.slots <- struct{}{}
+	select {
+	case conn := <-s.idle:
+		return conn, nil
+	default:
+	}
+	conn, err := s.connect()
+	if err != nil {
+		<-s.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (s *Source) put(conn *client.Conn, broken bool) {
+	if broken {
+		conn.Close()
+	} else {
+		s.idle <- conn
+	}
+	<-s.slots
+}
+
+func (s *Source) connect() (*client.Conn, error) {
+	conn, err := client.Connect(fmt.Sprintf("%s:%d", s.Host, s.Port), s.User, s.Password, "")
+	if err != nil {
+		logrus.Errorf("source connect err:%s", err.Error())
+		return nil, err
+	}
+	if _, err := conn.Execute("SET SESSION TRANSACTION READ ONLY"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"github.com/JieWaZi/transfer-mysql/utils"
 	"github.com/siddontang/go-mysql/mysql"
 	"github.com/sirupsen/logrus"
@@ -9,6 +11,8 @@ import (
 	"path/filepath"
 )
 
+var ErrCursorNotFound = errors.New("cursor not found")
+
 type BoltStorage struct {
 	BoltStoragePath string `env:""`
 	BoltFilePath    string `env:""`
@@ -18,6 +22,12 @@ type BoltStorage struct {
 	PositionBucket   string `env:""`
 	TaskBucket       string `env:""`
 	PositionKey      string `env:""`
+	// 任务 ID 在 TaskBucket 中的 key
+	TaskIDKey string `env:""`
+	// 各消费方已提交的 RowRequest 序号
+	CursorBucket string `env:""`
+	// sink 多次写入失败后跳过的记录
+	DeadLetterBucket string `env:""`
 
 	boltDB *bbolt.DB
 }
@@ -45,9 +55,18 @@ func (b *BoltStorage) SetDefaults() {
 	if b.TaskBucket == "" {
 		b.TaskBucket = "Task"
 	}
+	if b.CursorBucket == "" {
+		b.CursorBucket = "Cursor"
+	}
+	if b.DeadLetterBucket == "" {
+		b.DeadLetterBucket = "DeadLetter"
+	}
 	if b.PositionKey == "" {
 		b.PositionKey = "bolt_position_key"
 	}
+	if b.TaskIDKey == "" {
+		b.TaskIDKey = "task_id"
+	}
 }
 
 func (b *BoltStorage) Init() error {
@@ -79,10 +98,32 @@ func (b *BoltStorage) AddRowRequest(data []byte) error {
 	return b.AddByBucketName([]byte(b.RowRequestBucket), data)
 }
 
+func (b *BoltStorage) BatchAddRowRequest(list [][]byte) error {
+	return b.BatchAddByBucketName([]byte(b.RowRequestBucket), list)
+}
+
 func (b *BoltStorage) AddRowRequestByKey(key, data []byte) error {
 	return b.AddByBucketNameAndKey([]byte(b.RowRequestBucket), key, data)
 }
 
+// TaskID 返回保存的任务 ID，没有时使用 generate 生成并保存
+func (b *BoltStorage) TaskID(generate func() string) (string, error) {
+	var id string
+	err := b.boltDB.Update(func(tx *bbolt.Tx) error {
+		bt, err := tx.CreateBucketIfNotExists([]byte(b.TaskBucket))
+		if err != nil {
+			return err
+		}
+		if v := bt.Get([]byte(b.TaskIDKey)); len(v) > 0 {
+			id = string(v)
+			return nil
+		}
+		id = generate()
+		return bt.Put([]byte(b.TaskIDKey), []byte(id))
+	})
+	return id, err
+}
+
 func (b *BoltStorage) AddTaskByKey(key, data []byte) error {
 	return b.AddByBucketNameAndKey([]byte(b.TaskBucket), key, data)
 }
@@ -115,6 +156,126 @@ func (b *BoltStorage) DeleteRowRequest(key []byte) error {
 	return b.DeleteByKeyFromBucket([]byte(b.RowRequestBucket), key)
 }
 
+func (b *BoltStorage) ListRowRequest(after []byte, limit int) ([][]byte, [][]byte, error) {
+	return b.ListByBucketName([]byte(b.RowRequestBucket), after, limit)
+}
+
+func (b *BoltStorage) BatchDeleteRowRequest(keys [][]byte) error {
+	return b.BatchDeleteByBucketName([]byte(b.RowRequestBucket), keys)
+}
+
+func (b *BoltStorage) AddDeadLetter(data []byte) error {
+	return b.AddByBucketName([]byte(b.DeadLetterBucket), data)
+}
+
+// RowRequestSequence 最后写入的 RowRequest 序号
+func (b *BoltStorage) RowRequestSequence() (uint64, error) {
+	var seq uint64
+	err := b.boltDB.View(func(tx *bbolt.Tx) error {
+		seq = tx.Bucket([]byte(b.RowRequestBucket)).Sequence()
+		return nil
+	})
+	return seq, err
+}
+
+// FirstRowRequestSeq 队列中最早的 RowRequest 序号，队列为空时返回 0
+func (b *BoltStorage) FirstRowRequestSeq() (uint64, error) {
+	var seq uint64
+	err := b.boltDB.View(func(tx *bbolt.Tx) error {
+		if k, _ := tx.Bucket([]byte(b.RowRequestBucket)).Cursor().First(); k != nil {
+			seq = utils.BytesToUint64(k)
+		}
+		return nil
+	})
+	return seq, err
+}
+
+func (b *BoltStorage) GetCursor(name string) (uint64, bool, error) {
+	data, err := b.GetByKeyFromBucket([]byte(b.CursorBucket), []byte(name))
+	if err != nil || data == nil {
+		return 0, false, err
+	}
+	return utils.BytesToUint64(data), true, nil
+}
+
+func (b *BoltStorage) ListCursors() (map[string]uint64, error) {
+	cursors := make(map[string]uint64)
+	err := b.boltDB.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(b.CursorBucket)).ForEach(func(k, v []byte) error {
+			cursors[string(k)] = utils.BytesToUint64(v)
+			return nil
+		})
+	})
+	return cursors, err
+}
+
+// SetCursor 保存消费方的序号，并删除所有消费方都已提交的 RowRequest
+func (b *BoltStorage) SetCursor(name string, seq uint64) error {
+	return b.boltDB.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket([]byte(b.CursorBucket)).Put([]byte(name), utils.Uint64ToBytes(seq)); err != nil {
+			return err
+		}
+		return b.purgeRowRequest(tx)
+	})
+}
+
+// MoveCursor 在同一个事务中读取游标、校验并保存，first/last 为队列中最早的序号(队列为空时为 0)及最后写入的序号，
+// 游标不存在时返回 ErrCursorNotFound，check 返回错误时不修改游标并返回该错误
+func (b *BoltStorage) MoveCursor(name string, seq uint64, check func(cursor, first, last uint64) error) error {
+	return b.boltDB.Update(func(tx *bbolt.Tx) error {
+		cursors := tx.Bucket([]byte(b.CursorBucket))
+		data := cursors.Get([]byte(name))
+		if data == nil {
+			return ErrCursorNotFound
+		}
+		requests := tx.Bucket([]byte(b.RowRequestBucket))
+		var first uint64
+		if k, _ := requests.Cursor().First(); k != nil {
+			first = utils.BytesToUint64(k)
+		}
+		if err := check(utils.BytesToUint64(data), first, requests.Sequence()); err != nil {
+			return err
+		}
+		if err := cursors.Put([]byte(name), utils.Uint64ToBytes(seq)); err != nil {
+			return err
+		}
+		return b.purgeRowRequest(tx)
+	})
+}
+
+func (b *BoltStorage) DeleteCursor(name string) error {
+	return b.boltDB.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket([]byte(b.CursorBucket)).Delete([]byte(name)); err != nil {
+			return err
+		}
+		return b.purgeRowRequest(tx)
+	})
+}
+
+// purgeRowRequest 删除序号不大于最小游标的 RowRequest，没有游标时不删除
+func (b *BoltStorage) purgeRowRequest(tx *bbolt.Tx) error {
+	var min uint64
+	found := false
+	err := tx.Bucket([]byte(b.CursorBucket)).ForEach(func(k, v []byte) error {
+		seq := utils.BytesToUint64(v)
+		if !found || seq < min {
+			min = seq
+			found = true
+		}
+		return nil
+	})
+	if err != nil || !found {
+		return err
+	}
+	cursor := tx.Bucket([]byte(b.RowRequestBucket)).Cursor()
+	for k, _ := cursor.First(); k != nil && utils.BytesToUint64(k) <= min; k, _ = cursor.First() {
+		if err := cursor.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (b *BoltStorage) AddByBucketName(bucketName, data []byte) error {
 	return b.boltDB.Update(func(tx *bbolt.Tx) error {
 		bt := tx.Bucket(bucketName)
@@ -158,6 +319,46 @@ func (b *BoltStorage) GetByKeyFromBucket(bucketName, key []byte) ([]byte, error)
 	return entity, nil
 }
 
+// ListByBucketName 按 key 顺序返回 after 之后的最多 limit 条数据，after 为 nil 时从头开始
+func (b *BoltStorage) ListByBucketName(bucketName, after []byte, limit int) ([][]byte, [][]byte, error) {
+	keys := make([][]byte, 0, limit)
+	values := make([][]byte, 0, limit)
+	err := b.boltDB.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(bucketName).Cursor()
+		var k, v []byte
+		if after == nil {
+			k, v = cursor.First()
+		} else {
+			k, v = cursor.Seek(after)
+			if k != nil && bytes.Equal(k, after) {
+				k, v = cursor.Next()
+			}
+		}
+		for ; k != nil && len(keys) < limit; k, v = cursor.Next() {
+			// bolt 中的数据只在事务内有效，需要拷贝
+			keys = append(keys, append([]byte(nil), k...))
+			values = append(values, append([]byte(nil), v...))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return keys, values, nil
+}
+
+func (b *BoltStorage) BatchDeleteByBucketName(bucketName []byte, keys [][]byte) error {
+	return b.boltDB.Update(func(tx *bbolt.Tx) error {
+		bt := tx.Bucket(bucketName)
+		for i := range keys {
+			if err := bt.Delete(keys[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (b *BoltStorage) DeleteByKeyFromBucket(bucketName, key []byte) error {
 	return b.boltDB.Update(func(tx *bbolt.Tx) error {
 		bt := tx.Bucket(bucketName)